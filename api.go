@@ -12,6 +12,7 @@ import (
 
 	"github.com/telehash/gogotelehash/internal/modules/bridge"
 	"github.com/telehash/gogotelehash/internal/modules/paths"
+	"github.com/telehash/gogotelehash/internal/modules/reflex"
 )
 
 type (
@@ -35,6 +36,7 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 
 	innerOptions = append(innerOptions, paths.Module())
 	innerOptions = append(innerOptions, bridge.Module(bridge.Config{}))
+	innerOptions = append(innerOptions, reflex.Module(reflex.Config{}))
 
 	for i, option := range options {
 		innerOptions[i] = e3x.EndpointOption(option)
@@ -1,6 +1,7 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -38,12 +39,26 @@ func (err *BrokenChannelError) Error() string {
 	return fmt.Sprintf("e3x: broken channel (type=%s id=%d hashname=%s)", err.typ, err.id, err.hn)
 }
 
+// ChannelError is returned when the remote endpoint closed the channel with an
+// error.
+type ChannelError struct {
+	hn     hashname.H
+	typ    string
+	id     uint32
+	Reason string
+}
+
+func (err *ChannelError) Error() string {
+	return fmt.Sprintf("e3x: channel error (type=%s id=%d hashname=%s): %s", err.typ, err.id, err.hn, err.Reason)
+}
+
 const (
-	cReadBufferSize  = 100
-	cWriteBufferSize = 100
-	earlyAdHocAck    = 50
-	cBlankSeq        = uint32(0)
-	cInitialSeq      = uint32(1)
+	cReadBufferSize   = 100
+	cWriteBufferSize  = 100
+	earlyAdHocAck     = 50
+	cMaxResendBackoff = 5
+	cBlankSeq         = uint32(0)
+	cInitialSeq       = uint32(1)
 )
 
 type Channel struct {
@@ -61,7 +76,11 @@ type Channel struct {
 	typ          string
 	hashname     hashname.H
 	reliable     bool
+	unordered    bool
+	partial      bool
+	ttl          time.Duration // default deadline of partially reliable packets
 	broken       bool
+	remoteErr    error
 
 	oSeq         uint32 // highest seq in write stream
 	iBufferedSeq uint32 // highest buffered seq in read stream
@@ -69,11 +88,20 @@ type Channel struct {
 	iSeq         uint32 // highest seq in read stream
 	oAckedSeq    uint32 // highest acked seq in write stream
 	iAckedSeq    uint32 // highest acked seq in read stream
+	oSkipSeq     uint32 // highest abandoned seq in write stream
+	iSkipSeq     uint32 // highest abandoned seq in read stream
+
+	iDelivered map[uint32]bool // seqs > iSeq which were read out of order
 
 	deliveredEnd bool
 	receivedEnd  bool
 	readEnd      bool
-	needsResend  bool
+	needsResend  bool // unacked packets are waiting for the resend timer
+
+	modesConfirmed bool // the serverside confirmed the requested modes
+
+	resendBackoff uint   // resends since the last ack (doubles the timeout)
+	lossSeq       uint32 // oSeq at the last loss signal
 
 	openDeadlineReached  bool
 	writeDeadlineReached bool
@@ -83,32 +111,45 @@ type Channel struct {
 	readBuffer  readBufferSlice
 	writeBuffer map[uint32]*writeBufferEntry
 
+	bufferQuota    *bufferQuota
+	iBufferedBytes int // bytes in the read buffer
+	stats          *statCounters
+	tracer         *tracer.Tracer
+	span           *tracer.Span
+
 	tOpenDeadline  *time.Timer
 	tCloseDeadline *time.Timer
 	tReadDeadline  *time.Timer
 	tWriteDeadline *time.Timer
 	tResend        *time.Timer
 	tAcker         *time.Timer
+	tExpire        *time.Timer
+	expireAt       time.Time // when tExpire fires; zero when it is not armed
 }
 
 type ChannelOption func(*Channel) error
 
 type exchangeI interface {
 	deliverPacket(pkt *lob.Packet, dst *Pipe) error
+	pathFeedback(loss bool)
+	resendTimeout() time.Duration
 	RemoteIdentity() *Identity
 	getTID() tracer.ID
 }
 
 type readBufferEntry struct {
-	pkt *lob.Packet
-	seq uint32
-	end bool
+	pkt  *lob.Packet
+	seq  uint32
+	end  bool
+	size int
+	src  *Pipe // the pipe the packet was received on
 }
 
 type writeBufferEntry struct {
 	pkt        *lob.Packet
 	end        bool
 	lastResend time.Time
+	deadline   time.Time // zero when the packet never expires
 	dst        *Pipe
 }
 
@@ -133,6 +174,7 @@ func newChannel(
 		iSeq:         cBlankSeq,
 		oAckedSeq:    cBlankSeq,
 		iAckedSeq:    cBlankSeq,
+		stats:        &statCounters{},
 	}
 
 	c.cndRead = sync.NewCond(&c.mtx)
@@ -152,7 +194,6 @@ func newChannel(
 	}
 
 	c.setOptions(options...)
-	c.traceNew()
 
 	return c
 }
@@ -170,13 +211,28 @@ func registerExchange(x *Exchange) ChannelOption {
 	return func(c *Channel) error {
 		c.channelHooks = x.channelHooks
 		c.channelHooks.channel = c
+		c.bufferQuota = x.bufferQuota
+		c.stats.parent = x.channelStats.get(c.typ, x.stats)
+		c.tracer = x.tracer
 		return nil
 	}
 }
 
+// tracePacket describes pkt in a trace event.
+func tracePacket(pkt *lob.Packet) tracer.Info {
+	var body []byte
+	if pkt.BodyLen() > 0 {
+		body = pkt.Body(nil)
+	}
+	return tracer.Info{
+		"header": pkt.Header(),
+		"body":   base64.StdEncoding.EncodeToString(body),
+	}
+}
+
 func (c *Channel) traceNew() {
-	if tracer.Enabled {
-		tracer.Emit("channel.new", tracer.Info{
+	if c.tracer.Sampled(c.x.getTID()) {
+		info := tracer.Info{
 			"exchange_id": c.x.getTID(),
 			"channel_id":  c.TID,
 			"channel": tracer.Info{
@@ -184,12 +240,24 @@ func (c *Channel) traceNew() {
 				"reliable": c.reliable,
 				"cid":      c.id,
 			},
-		})
+		}
+		c.tracer.Emit("channel.new", c.x.getTID(), info)
+		c.span = c.tracer.Start("channel.lifetime", c.x.getTID(), info)
 	}
 }
 
+// traceClosed ends the lifetime span of the channel.
+func (c *Channel) traceClosed() {
+	c.mtx.Lock()
+	span, err := c.span, c.remoteErr
+	c.span = nil
+	c.mtx.Unlock()
+
+	span.End(err)
+}
+
 func (c *Channel) traceWriteError(pkt *lob.Packet, p *Pipe, reason error) error {
-	if tracer.Enabled {
+	if c.tracer.Sampled(c.x.getTID()) {
 		info := tracer.Info{
 			"channel_id": c.TID,
 			"reason":     reason.Error(),
@@ -201,19 +269,24 @@ func (c *Channel) traceWriteError(pkt *lob.Packet, p *Pipe, reason error) error
 
 		if pkt != nil {
 			info["packet_id"] = pkt.TID
-			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
-				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
-			}
+			info["packet"] = tracePacket(pkt)
 		}
 
-		tracer.Emit("channel.write.error", info)
+		c.tracer.Emit("channel.write.error", c.x.getTID(), info)
 	}
 	return reason
 }
 
 func (c *Channel) traceWrite(pkt *lob.Packet, p *Pipe) {
-	if tracer.Enabled {
+	c.traceSent("channel.write", pkt, p)
+}
+
+func (c *Channel) traceResend(pkt *lob.Packet, p *Pipe) {
+	c.traceSent("channel.resend", pkt, p)
+}
+
+func (c *Channel) traceSent(typ string, pkt *lob.Packet, p *Pipe) {
+	if c.tracer.Sampled(c.x.getTID()) {
 		info := tracer.Info{
 			"channel_id": c.TID,
 		}
@@ -224,18 +297,15 @@ func (c *Channel) traceWrite(pkt *lob.Packet, p *Pipe) {
 
 		if pkt != nil {
 			info["packet_id"] = pkt.TID
-			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
-				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
-			}
+			info["packet"] = tracePacket(pkt)
 		}
 
-		tracer.Emit("channel.write", info)
+		c.tracer.Emit(typ, c.x.getTID(), info)
 	}
 }
 
 func (c *Channel) traceDroppedPacket(pkt *lob.Packet, reason string) {
-	if tracer.Enabled {
+	if c.tracer.Sampled(c.x.getTID()) {
 		info := tracer.Info{
 			"channel_id": c.TID,
 			"packet_id":  pkt.TID,
@@ -243,25 +313,19 @@ func (c *Channel) traceDroppedPacket(pkt *lob.Packet, reason string) {
 		}
 
 		if pkt != nil {
-			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
-				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
-			}
+			info["packet"] = tracePacket(pkt)
 		}
 
-		tracer.Emit("channel.rcv.packet", info)
+		c.tracer.Emit("channel.drop.packet", c.x.getTID(), info)
 	}
 }
 
 func (c *Channel) traceReceivedPacket(pkt *lob.Packet) {
-	if tracer.Enabled {
-		tracer.Emit("channel.rcv.packet", tracer.Info{
+	if c.tracer.Sampled(c.x.getTID()) {
+		c.tracer.Emit("channel.rcv.packet", c.x.getTID(), tracer.Info{
 			"channel_id": c.TID,
 			"packet_id":  pkt.TID,
-			"packet": tracer.Info{
-				"header": pkt.Header(),
-				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
-			},
+			"packet":     tracePacket(pkt),
 		})
 	}
 }
@@ -276,6 +340,16 @@ func (c *Channel) RemoteIdentity() *Identity {
 	return c.x.RemoteIdentity()
 }
 
+// Type returns the channel type.
+func (c *Channel) Type() string {
+	return c.typ
+}
+
+// Reliable returns true for reliable channels.
+func (c *Channel) Reliable() bool {
+	return c.reliable
+}
+
 func (c *Channel) Exchange() *Exchange {
 	if x, ok := c.x.(*Exchange); ok && x != nil {
 		return x
@@ -283,13 +357,13 @@ func (c *Channel) Exchange() *Exchange {
 	return nil
 }
 
-func (e *Endpoint) Open(i Identifier, typ string, reliable bool) (*Channel, error) {
+func (e *Endpoint) Open(i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
 	x, err := e.Dial(i)
 	if err != nil {
 		return nil, err
 	}
 
-	return x.Open(typ, reliable)
+	return x.Open(typ, reliable, options...)
 }
 
 func (c *Channel) WritePacket(pkt *lob.Packet) error {
@@ -347,6 +421,14 @@ func (c *Channel) blockWrite() bool {
 }
 
 func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
+	var deadline time.Time
+	if c.partial && c.ttl > 0 {
+		deadline = time.Now().Add(c.ttl)
+	}
+	return c.writeUntil(pkt, p, deadline)
+}
+
+func (c *Channel) writeUntil(pkt *lob.Packet, p *Pipe, deadline time.Time) error {
 	if pkt.TID == 0 {
 		pkt.TID = tracer.NewID()
 	}
@@ -355,7 +437,7 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 		// When a channel is marked as broken the all writes
 		// must return a BrokenChannelError.
 		return c.traceWriteError(pkt, p,
-			&BrokenChannelError{c.hashname, c.typ, c.id})
+			c.brokenError())
 	}
 
 	if c.writeDeadlineReached {
@@ -372,14 +454,29 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 			io.EOF)
 	}
 
-	c.oSeq++
+	// a vetoed packet is restored so it can be written again
 	hdr := pkt.Header()
+	saved, extra := *hdr, copyExtra(hdr.Extra)
+
+	c.oSeq++
 	hdr.C, hdr.HasC = c.id, true
 	if c.reliable {
 		hdr.Seq, hdr.HasSeq = c.oSeq, true
 	}
-	if !c.serverside && c.oSeq == cInitialSeq {
-		hdr.Type, hdr.HasType = c.typ, true
+	if c.oSeq == cInitialSeq {
+		if !c.serverside {
+			hdr.Type, hdr.HasType = c.typ, true
+		}
+		// the serverside echoes the modes it accepted
+		c.applyModeHeaders(hdr)
+	}
+
+	if err := c.channelHooks.SendPacket(pkt); err != nil {
+		// vetoed by a hook
+		c.oSeq--
+		*hdr = saved
+		hdr.Extra = extra
+		return c.traceWriteError(pkt, p, err)
 	}
 
 	end := hdr.HasEnd && hdr.End
@@ -387,13 +484,20 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 		c.deliveredEnd = true
 		c.setCloseDeadline()
 	}
+	if end || c.oSeq == cInitialSeq {
+		// open and end packets never expire
+		deadline = time.Time{}
+	}
 
 	if c.reliable {
 		if c.oSeq%30 == 0 || hdr.End {
 			c.applyAckHeaders(pkt)
 		}
-		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt, end, time.Time{}, p}
-		c.needsResend = false
+		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt, end, time.Time{}, deadline, p}
+		c.applySkipHeader(hdr)
+		c.needsResend = true
+		c.tResend.Reset(c.resendTimeout())
+		c.armExpirer(deadline)
 	}
 
 	err := c.x.deliverPacket(pkt, p)
@@ -401,6 +505,7 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 		return c.traceWriteError(pkt, p, err)
 	}
 	statChannelSndPkt.Add(1)
+	c.stats.countSent(pkt.BodyLen())
 	if pkt.Header().HasAck {
 		statChannelSndAckInline.Add(1)
 	}
@@ -418,9 +523,28 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	return nil
 }
 
+// copyExtra returns a shallow copy of the extra headers.
+func copyExtra(extra map[string]interface{}) map[string]interface{} {
+	if extra == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(extra))
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
 func (c *Channel) ReadPacket() (*lob.Packet, error) {
+	pkt, _, err := c.ReadPacketFrom()
+	return pkt, err
+}
+
+// ReadPacketFrom reads the next packet and returns the pipe it was received
+// on. Replies can be sent over the same pipe with WritePacketTo.
+func (c *Channel) ReadPacketFrom() (*lob.Packet, *Pipe, error) {
 	if c == nil {
-		return nil, os.ErrInvalid
+		return nil, nil, os.ErrInvalid
 	}
 
 	c.mtx.Lock()
@@ -428,13 +552,15 @@ func (c *Channel) ReadPacket() (*lob.Packet, error) {
 		c.cndRead.Wait()
 	}
 
+	var src *Pipe
 	pkt, err := c.peekPacket()
 	if pkt != nil {
+		src = c.readBuffer[c.nextReadable()].src
 		c.readPacket()
 	}
 
 	c.mtx.Unlock()
-	return pkt, err
+	return pkt, src, err
 }
 
 func (c *Channel) blockRead() bool {
@@ -468,8 +594,7 @@ func (c *Channel) blockRead() bool {
 		return true
 	}
 
-	rSeq := c.iSeq + 1
-	if len(c.readBuffer) == 0 || c.readBuffer[0].seq != rSeq {
+	if c.nextReadable() < 0 {
 		// Packet has not yet been received
 		// defer the read
 		return true
@@ -482,7 +607,7 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 	if c.broken {
 		// When a channel is marked as broken the all reads
 		// must return a BrokenChannelError.
-		return nil, &BrokenChannelError{c.hashname, c.typ, c.id}
+		return nil, c.brokenError()
 	}
 
 	if c.readDeadlineReached {
@@ -497,10 +622,11 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 		return nil, io.EOF
 	}
 
-	e := c.readBuffer[0]
+	e := c.readBuffer[c.nextReadable()]
 
 	{ // clean headers
 		h := e.pkt.Header()
+		c.cleanModeHeaders(h)
 		h.HasAck = false
 		h.HasC = false
 		h.HasMiss = false
@@ -519,21 +645,32 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 }
 
 func (c *Channel) readPacket() {
-	rSeq := c.iSeq + 1
-	e := c.readBuffer[0]
+	idx := c.nextReadable()
+	e := c.readBuffer[idx]
 
-	c.iSeq = rSeq
+	if e.seq == c.iSeq+1 {
+		c.iSeq = e.seq
+	} else {
+		// read out of order (unordered channels only)
+		if c.iDelivered == nil {
+			c.iDelivered = make(map[uint32]bool)
+		}
+		c.iDelivered[e.seq] = true
+	}
 
 	// remove entry
-	copy(c.readBuffer, c.readBuffer[1:])
+	copy(c.readBuffer[idx:], c.readBuffer[idx+1:])
 	c.readBuffer = c.readBuffer[:len(c.readBuffer)-1]
+	c.advanceReadSeq()
+	c.bufferQuota.release(e.size)
+	c.iBufferedBytes -= e.size
 
 	if e.end {
 		c.deliverAck()
 		c.readEnd = true
 	}
 
-	if c.iSeq == cInitialSeq && !c.serverside {
+	if e.seq == cInitialSeq && !c.serverside {
 		c.unsetOpenDeadline()
 	}
 
@@ -550,19 +687,32 @@ func (c *Channel) readPacket() {
 	}
 }
 
-func (c *Channel) receivedPacket(pkt *lob.Packet) {
-	const (
-		errBrokenChannel   = "broken channel"
-		errMissingSeq      = "missing seq"
-		errDuplicatePacket = "duplicate packet"
-		errFullBuffer      = "full buffer"
-	)
+// reasons for dropping received packets (passed to the OnDropPacket hooks)
+var (
+	errBrokenChannel   = errors.New("broken channel")
+	errMissingSeq      = errors.New("missing seq")
+	errDuplicatePacket = errors.New("duplicate packet")
+	errFullBuffer      = errors.New("full buffer")
+	errBufferQuota     = errors.New("buffer quota exceeded")
+)
 
+func (c *Channel) receivedPacket(pkt *lob.Packet, src *Pipe) {
 	c.mtx.Lock()
 
 	if c.broken {
+		c.channelHooks.DropPacket(pkt, errBrokenChannel)
+		c.stats.countDropped()
+		c.mtx.Unlock()
+		c.traceDroppedPacket(pkt, errBrokenChannel.Error())
+		statChannelRcvPktDrop.Add(1)
+		return
+	}
+
+	if err := c.channelHooks.ReceivePacket(pkt); err != nil {
+		// drop: vetoed by a hook
+		c.stats.countDropped()
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errBrokenChannel)
+		c.traceDroppedPacket(pkt, err.Error())
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
@@ -575,12 +725,32 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		end, hasEnd   = hdr.End, hdr.HasEnd
 	)
 
+	if reason, found := hdr.GetString("err"); found {
+		// the remote endpoint closed the channel with an error
+		c.remoteErr = &ChannelError{c.hashname, c.typ, c.id, reason}
+		c.broken = true
+		c.unsetTimers()
+
+		c.cndWrite.Broadcast()
+		c.cndRead.Broadcast()
+		c.cndClose.Broadcast()
+		c.mtx.Unlock()
+
+		c.traceReceivedPacket(pkt)
+		c.channelHooks.Closed()
+		return
+	}
+
 	if !c.reliable {
 		// unreliable channels (internaly) emulate reliable channels.
 		seq = c.iBufferedSeq + 1
 		hasSeq = true
 
 	} else {
+		if !c.serverside && !c.modesConfirmed {
+			c.confirmModes(hdr)
+		}
+
 		// determine what to drop from the write buffer
 		if hasAck {
 			if hasSeq {
@@ -601,6 +771,7 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 			for i := oldAck + 1; i <= ack; i++ {
 				if e := c.writeBuffer[i]; e != nil {
+					c.channelHooks.AckPacket(e.pkt)
 					e.pkt.Free()
 				}
 				delete(c.writeBuffer, i)
@@ -612,9 +783,18 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 			}
 
 			if changed {
+				c.resendBackoff = 0
+				if c.needsResend {
+					c.tResend.Reset(c.resendTimeout())
+				}
+				if ack >= c.lossSeq {
+					// acks for packets sent before the last loss don't prove
+					// that the path recovered
+					c.x.pathFeedback(false)
+				}
 				c.cndWrite.Signal()
 				if c.deliveredEnd || c.receivedEnd {
-					c.cndClose.Signal()
+					c.cndClose.Broadcast()
 				}
 			}
 
@@ -622,12 +802,16 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 				c.processMissingPackets(ack, miss)
 			}
 		}
+
+		c.receivedSkip(hdr)
 	}
 
 	if !hasSeq {
 		// drop: is not a valid packet
+		c.channelHooks.DropPacket(pkt, errMissingSeq)
+		c.stats.countDropped()
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errMissingSeq)
+		c.traceDroppedPacket(pkt, errMissingSeq.Error())
 
 		if !hasAck {
 			statChannelRcvPktDrop.Add(1)
@@ -641,30 +825,48 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		c.iSeenSeq = seq
 	}
 
-	if seq <= c.iSeq {
+	if seq <= c.iSeq || c.iDelivered[seq] {
 		// drop: the reader already read a packet with this seq
+		c.channelHooks.DropPacket(pkt, errDuplicatePacket)
+		c.stats.countDropped()
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errDuplicatePacket)
+		c.traceDroppedPacket(pkt, errDuplicatePacket.Error())
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
 	if len(c.readBuffer) >= cReadBufferSize {
 		// drop: the read buffer is full
+		c.channelHooks.DropPacket(pkt, errFullBuffer)
+		c.stats.countDropped()
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errFullBuffer)
+		c.traceDroppedPacket(pkt, errFullBuffer.Error())
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
 	if c.readBuffer.IndexOf(seq) >= 0 {
 		// drop: a packet with this seq is already buffered
+		c.channelHooks.DropPacket(pkt, errDuplicatePacket)
+		c.stats.countDropped()
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errDuplicatePacket)
+		c.traceDroppedPacket(pkt, errDuplicatePacket.Error())
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
+	size := pkt.BodyLen()
+	if !c.bufferQuota.reserve(size) {
+		// drop: the peer exceeded its buffer quota
+		c.channelHooks.DropPacket(pkt, errBufferQuota)
+		c.stats.countDropped()
+		c.mtx.Unlock()
+		c.traceDroppedPacket(pkt, errBufferQuota.Error())
+		statChannelRcvPktDrop.Add(1)
+		return
+	}
+	c.iBufferedBytes += size
+
 	if c.iBufferedSeq < seq {
 		c.iBufferedSeq = seq
 	}
@@ -673,7 +875,7 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		c.deliverAck()
 	}
 
-	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt, seq, end})
+	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt, seq, end, size, src})
 	sort.Sort(c.readBuffer)
 
 	c.cndRead.Signal()
@@ -681,6 +883,54 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 	c.traceReceivedPacket(pkt)
 	statChannelRcvPkt.Add(1)
+	c.stats.countReceived(size)
+}
+
+// reject refuses the channel. err is delivered to the remote endpoint and the
+// channel is marked as broken.
+func (c *Channel) reject(err error) {
+	c.mtx.Lock()
+
+	if c.broken {
+		c.mtx.Unlock()
+		return
+	}
+
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.C, hdr.HasC = c.id, true
+	hdr.SetString("err", err.Error())
+
+	c.broken = true
+	c.unsetTimers()
+
+	c.cndWrite.Broadcast()
+	c.cndRead.Broadcast()
+	c.cndClose.Broadcast()
+
+	c.mtx.Unlock()
+
+	c.x.deliverPacket(pkt, nil)
+	pkt.Free()
+
+	c.channelHooks.Closed()
+}
+
+// brokenError returns the error for operations on a broken channel.
+func (c *Channel) brokenError() error {
+	if c.remoteErr != nil {
+		return c.remoteErr
+	}
+	return &BrokenChannelError{c.hashname, c.typ, c.id}
+}
+
+// releaseBuffered returns the unread bytes to the buffer quota of the exchange.
+func (c *Channel) releaseBuffered() {
+	c.mtx.Lock()
+	c.bufferQuota.release(c.iBufferedBytes)
+	c.bufferQuota = nil
+	c.iBufferedBytes = 0
+	c.mtx.Unlock()
 }
 
 func (c *Channel) Errorf(format string, args ...interface{}) error {
@@ -698,7 +948,7 @@ func (c *Channel) Error(err error) error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	for c.blockWrite() {
@@ -739,7 +989,7 @@ func (c *Channel) Close() error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	c.setCloseDeadline()
@@ -785,7 +1035,7 @@ func (c *Channel) Close() error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	c.unsetTimers()
@@ -800,6 +1050,48 @@ func (c *Channel) Close() error {
 	return nil
 }
 
+// flush delivers an end packet and waits until the remote endpoint
+// acknowledged all the buffered packets. flush returns early when ctx is done.
+func (c *Channel) flush(ctx context.Context) {
+	// wake the waits below when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.mtx.Lock()
+			c.cndWrite.Broadcast()
+			c.cndClose.Broadcast()
+			c.mtx.Unlock()
+		case <-done:
+		}
+	}()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.deliveredEnd {
+		for c.blockWrite() && !c.broken && ctx.Err() == nil {
+			c.cndWrite.Wait()
+		}
+
+		if c.broken || ctx.Err() != nil {
+			return
+		}
+
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.End, hdr.HasEnd = true, true
+		if err := c.write(pkt, nil); err != nil {
+			return
+		}
+	}
+
+	for c.reliable && len(c.writeBuffer) > 0 && !c.broken && ctx.Err() == nil {
+		c.cndClose.Wait()
+	}
+}
+
 func (c *Channel) blockClose() bool {
 	if c.broken {
 		return false
@@ -836,6 +1128,10 @@ func (c *Channel) buildMissList() []uint32 {
 		}
 
 		for seq < e.seq {
+			if c.iDelivered[seq] {
+				seq++
+				continue
+			}
 			if miss == nil {
 				miss = make([]uint32, 0, cReadBufferSize)
 			}
@@ -851,6 +1147,10 @@ func (c *Channel) buildMissList() []uint32 {
 	}
 
 	for seq <= c.iSeenSeq {
+		if c.iDelivered[seq] {
+			seq++
+			continue
+		}
 		if miss == nil {
 			miss = make([]uint32, 0, cReadBufferSize)
 		}
@@ -874,12 +1174,17 @@ ADD_HIGHEST_ACCEPTABLE_SEQ:
 
 func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 	var (
-		omiss     = c.buildMissList()
-		now       = time.Now()
-		oneSecAgo = now.Add(-1 * time.Second)
-		last      = ack
+		omiss    = c.buildMissList()
+		now      = time.Now()
+		recently = now.Add(-c.x.resendTimeout())
+		last     = ack
+		resent   int
 	)
 
+	if c.abandonExpired(now) {
+		c.deliverAck()
+	}
+
 	for _, delta := range miss {
 		seq := last + delta
 		last = seq
@@ -889,7 +1194,11 @@ func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 			continue
 		}
 
-		if e.lastResend.After(oneSecAgo) {
+		if e.lastResend.After(recently) {
+			continue
+		}
+
+		if c.channelHooks.ResendPacket(e.pkt) != nil {
 			continue
 		}
 
@@ -900,29 +1209,82 @@ func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 		if len(omiss) > 0 {
 			hdr.Miss, hdr.HasMiss = omiss, true
 		}
+		c.applySkipHeader(hdr)
 		e.lastResend = now
+		c.stats.countRetransmission()
 
 		err := c.x.deliverPacket(e.pkt, e.dst)
 		if err == nil {
 			statChannelSndPkt.Add(1)
+			c.traceResend(e.pkt, e.dst)
 		}
+		resent++
+	}
+
+	if resent > 0 {
+		c.lossSeq = c.oSeq
+		c.x.pathFeedback(true)
 	}
 }
 
+// resendLastPacket is called by the resend timer when the unacked packets were
+// not acknowledged within the resend timeout. Every resend is a loss signal for
+// the active path.
 func (c *Channel) resendLastPacket() {
 	c.mtx.Lock()
 
-	var needsResend bool
-	needsResend, c.needsResend = c.needsResend, true
-	c.tResend.Reset(1 * time.Second)
-
-	if !needsResend {
+	if !c.needsResend && !c.skipPending() {
+		// nothing waits for an ack; write() rearms the timer
 		c.mtx.Unlock()
 		return
 	}
 
-	e := c.writeBuffer[c.oSeq]
+	if c.resendBackoff < cMaxResendBackoff {
+		c.resendBackoff++
+	}
+	c.tResend.Reset(c.resendTimeout())
+
+	c.resendLast(true)
+}
+
+// resendTimeout returns the resend timeout of the active path, doubled for
+// every resend which was not acknowledged.
+func (c *Channel) resendTimeout() time.Duration {
+	d := c.x.resendTimeout() << c.resendBackoff
+	if d > cMaxResendTimeout {
+		d = cMaxResendTimeout
+	}
+	return d
+}
+
+// resendNow resends the last unacknowledged packet without waiting for the
+// resend timer.
+func (c *Channel) resendNow() {
+	if !c.reliable {
+		return
+	}
+
+	c.mtx.Lock()
+	c.resendLast(false)
+}
+
+// resendLast resends the last unacknowledged packet. loss indicates that
+// the packet is resent because it wasn't acknowledged in time. c.mtx must be
+// held and is released by resendLast.
+func (c *Channel) resendLast(loss bool) {
+	skipped := c.abandonExpired(time.Now())
+
+	e := c.lastPending()
 	if e == nil {
+		if skipped || c.skipPending() {
+			// all packets were abandoned; only announce the skip
+			c.deliverAck()
+		}
+		c.mtx.Unlock()
+		return
+	}
+
+	if c.channelHooks.ResendPacket(e.pkt) != nil {
 		c.mtx.Unlock()
 		return
 	}
@@ -935,12 +1297,22 @@ func (c *Channel) resendLastPacket() {
 	if len(omiss) > 0 {
 		hdr.Miss, hdr.HasMiss = omiss, true
 	}
+	c.applySkipHeader(hdr)
 	e.lastResend = time.Now()
+	c.stats.countRetransmission()
+	if loss {
+		c.lossSeq = c.oSeq
+	}
 	c.mtx.Unlock()
 
+	if loss {
+		c.x.pathFeedback(true)
+	}
+
 	err := c.x.deliverPacket(e.pkt, e.dst)
 	if err == nil {
 		statChannelSndPkt.Add(1)
+		c.traceResend(e.pkt, e.dst)
 	}
 }
 
@@ -975,6 +1347,11 @@ func (c *Channel) deliverAck() {
 	hdr := pkt.Header()
 	hdr.C, hdr.HasC = c.id, true
 	c.applyAckHeaders(pkt)
+	c.applySkipHeader(hdr)
+	if c.serverside && c.oAckedSeq == cBlankSeq {
+		// echo the accepted modes until the first reply is acknowledged
+		c.applyModeHeaders(hdr)
+	}
 	err := c.x.deliverPacket(pkt, nil)
 	if err == nil {
 		statChannelSndAckAdHoc.Add(1)
@@ -1028,6 +1405,7 @@ func (c *Channel) onCloseDeadlineReached() {
 	c.unsetOpenDeadline()
 	c.unsetCloseDeadline()
 	c.unsetResender()
+	c.unsetExpirer()
 
 	// broadcast
 	c.cndWrite.Broadcast()
@@ -1059,6 +1437,7 @@ func (c *Channel) unsetTimers() {
 	c.unsetWriteDeadline()
 	c.unsetResender()
 	c.unsetAcker()
+	c.unsetExpirer()
 }
 
 func (c *Channel) unsetReadDeadline() {
@@ -1291,7 +1670,7 @@ func (s readBufferSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s readBufferSlice) IndexOf(seq uint32) int {
 	l := len(s)
 	idx := sort.Search(l, func(i int) bool { return s[i].seq >= seq })
-	if idx == l {
+	if idx == l || s[idx].seq != seq {
 		return -1
 	}
 	return idx
@@ -0,0 +1,381 @@
+package e3x
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// Reliable channels deliver all packets in order by default. The open packet
+// of a channel can ask for two relaxed modes which the serverside channel
+// adopts:
+//
+//   {"type": "stream", "seq": 1, "c": 1, "unordered": true}
+//   {"type": "media", "seq": 1, "c": 1, "partial": true, "ttl": 500}
+//
+// The serverside echoes the modes it accepted in its first reply (and in its
+// acks until that reply is acknowledged). The opening channel drops the modes
+// which were not echoed by the first packet it receives.
+//
+// An unordered channel hands packets to the reader as soon as they arrive.
+// The acks still cover the contiguous prefix of the stream so the sender
+// resends lost packets like it does for ordered channels. Only the open
+// packet and the end packet are always read in order.
+//
+// A partially reliable channel gives up on packets which are not acknowledged
+// before their deadline. The sender tells the receiver which seqs it gave up
+// on with the "skip" header and the receiver moves its read position past
+// them. Open and end packets never expire.
+
+var (
+	// ErrUnreliableChannelMode is returned when an unreliable channel is opened
+	// with a channel mode.
+	ErrUnreliableChannelMode = errors.New("e3x: channel modes require a reliable channel")
+
+	// ErrNotPartiallyReliable is returned by WritePacketUntil when the channel
+	// is not partially reliable.
+	ErrNotPartiallyReliable = errors.New("e3x: channel is not partially reliable")
+)
+
+const (
+	hdrUnordered = "unordered"
+	hdrPartial   = "partial"
+	hdrTTL       = "ttl"
+	hdrSkip      = "skip"
+)
+
+// Unordered makes a reliable channel deliver packets in the order they arrive.
+func Unordered() ChannelOption {
+	return func(c *Channel) error {
+		if !c.reliable {
+			return ErrUnreliableChannelMode
+		}
+		c.unordered = true
+		return nil
+	}
+}
+
+// PartiallyReliable makes a reliable channel abandon packets which are not
+// acknowledged within ttl. When ttl is 0 packets only expire when they are
+// written with WritePacketUntil.
+func PartiallyReliable(ttl time.Duration) ChannelOption {
+	return func(c *Channel) error {
+		if !c.reliable {
+			return ErrUnreliableChannelMode
+		}
+		c.partial = true
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// acceptModes applies the channel modes requested in the open packet.
+func acceptModes(hdr *lob.Header) ChannelOption {
+	return func(c *Channel) error {
+		if !c.reliable {
+			return nil
+		}
+		if v, _ := hdr.GetBool(hdrUnordered); v {
+			c.unordered = true
+		}
+		if v, _ := hdr.GetBool(hdrPartial); v {
+			c.partial = true
+			if ms, found := hdr.GetUint32(hdrTTL); found {
+				c.ttl = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return nil
+	}
+}
+
+// confirmModes drops the requested modes which the serverside didn't echo in
+// its first packet.
+func (c *Channel) confirmModes(hdr *lob.Header) {
+	c.modesConfirmed = true
+	if v, _ := hdr.GetBool(hdrUnordered); !v {
+		c.unordered = false
+	}
+	if v, _ := hdr.GetBool(hdrPartial); !v && c.partial {
+		// only the open packet was written and it never expires
+		c.partial = false
+		c.unsetExpirer()
+	}
+}
+
+// Unordered returns true when the channel delivers packets in the order they
+// arrive. On the opening side this reports the requested mode until the
+// serverside confirmed it with its first packet.
+func (c *Channel) Unordered() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.unordered
+}
+
+// PartiallyReliable returns true when the channel abandons expired packets.
+// On the opening side this reports the requested mode until the serverside
+// confirmed it with its first packet.
+func (c *Channel) PartiallyReliable() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.partial
+}
+
+// ModesConfirmed returns true when the serverside confirmed the channel modes
+// and Unordered and PartiallyReliable report the modes in effect. It is
+// always true for serverside channels.
+func (c *Channel) ModesConfirmed() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.serverside || c.modesConfirmed
+}
+
+// WritePacketUntil writes pkt to a partially reliable channel. The packet is
+// abandoned when it is not acknowledged before deadline.
+func (c *Channel) WritePacketUntil(pkt *lob.Packet, deadline time.Time) error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	c.mtx.Lock()
+	for c.blockWrite() {
+		c.cndWrite.Wait()
+	}
+
+	// the serverside may have declined the mode while the write was blocked
+	if !c.partial {
+		c.mtx.Unlock()
+		return ErrNotPartiallyReliable
+	}
+
+	err := c.writeUntil(pkt, nil, deadline)
+
+	if !c.blockWrite() {
+		c.cndWrite.Signal()
+	}
+	if !c.blockRead() {
+		c.cndRead.Signal()
+	}
+
+	c.mtx.Unlock()
+	return err
+}
+
+// applyModeHeaders adds the requested channel modes to the open packet.
+func (c *Channel) applyModeHeaders(hdr *lob.Header) {
+	if c.unordered {
+		hdr.SetBool(hdrUnordered, true)
+	}
+	if c.partial {
+		hdr.SetBool(hdrPartial, true)
+		if c.ttl > 0 {
+			hdr.SetUint32(hdrTTL, uint32(c.ttl/time.Millisecond))
+		}
+	}
+}
+
+// cleanModeHeaders removes the channel mode headers before pkt is handed to
+// the reader.
+func (c *Channel) cleanModeHeaders(hdr *lob.Header) {
+	if hdr.Extra == nil {
+		return
+	}
+	if c.unordered {
+		delete(hdr.Extra, hdrUnordered)
+	}
+	if c.partial {
+		delete(hdr.Extra, hdrPartial)
+		delete(hdr.Extra, hdrTTL)
+		delete(hdr.Extra, hdrSkip)
+	}
+}
+
+// abandonExpired drops the packets from the write buffer whose deadline
+// passed and returns true when the skip position moved.
+func (c *Channel) abandonExpired(now time.Time) bool {
+	if !c.partial {
+		return false
+	}
+
+	abandoned := false
+	for seq, e := range c.writeBuffer {
+		if e.deadline.IsZero() || now.Before(e.deadline) {
+			continue
+		}
+		// e.pkt is not freed as a resend might still be in flight.
+		delete(c.writeBuffer, seq)
+		abandoned = true
+	}
+
+	if len(c.writeBuffer) == 0 {
+		c.needsResend = false
+	}
+
+	if abandoned {
+		c.cndWrite.Signal()
+		if c.deliveredEnd {
+			c.cndClose.Broadcast()
+		}
+	}
+
+	return c.advanceSkip()
+}
+
+// armExpirer makes sure the expire timer fires no later than deadline.
+func (c *Channel) armExpirer(deadline time.Time) {
+	if deadline.IsZero() {
+		return
+	}
+	if !c.expireAt.IsZero() && !deadline.Before(c.expireAt) {
+		return
+	}
+
+	c.expireAt = deadline
+	d := deadline.Sub(time.Now())
+	if c.tExpire == nil {
+		c.tExpire = time.AfterFunc(d, c.onExpirerFired)
+	} else {
+		c.tExpire.Reset(d)
+	}
+}
+
+// onExpirerFired is called by the expire timer when the earliest deadline in
+// the write buffer passed. The abandoned packets are announced right away and
+// the timer is armed for the next deadline.
+func (c *Channel) onExpirerFired() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.expireAt = time.Time{}
+	if c.broken || !c.partial {
+		return
+	}
+
+	now := time.Now()
+	if c.abandonExpired(now) {
+		c.deliverAck()
+	}
+
+	for _, e := range c.writeBuffer {
+		c.armExpirer(e.deadline)
+	}
+}
+
+func (c *Channel) unsetExpirer() {
+	if c.tExpire != nil {
+		c.tExpire.Stop()
+	}
+	c.expireAt = time.Time{}
+}
+
+// advanceSkip moves oSkipSeq over the abandoned packets that directly follow
+// the acknowledged ones.
+func (c *Channel) advanceSkip() bool {
+	old := c.oSkipSeq
+	if c.oSkipSeq < c.oAckedSeq {
+		c.oSkipSeq = c.oAckedSeq
+	}
+	for c.oSkipSeq < c.oSeq && c.writeBuffer[c.oSkipSeq+1] == nil {
+		c.oSkipSeq++
+	}
+	return c.oSkipSeq > old && c.oSkipSeq > c.oAckedSeq
+}
+
+// lastPending returns the unacknowledged packet with the highest seq. This is
+// the last written packet unless it was abandoned.
+func (c *Channel) lastPending() *writeBufferEntry {
+	if e := c.writeBuffer[c.oSeq]; e != nil || !c.partial {
+		return e
+	}
+	for seq := c.oSeq; seq > c.oAckedSeq; seq-- {
+		if e := c.writeBuffer[seq]; e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// skipPending returns true when the remote endpoint was not yet told about all
+// the abandoned packets.
+func (c *Channel) skipPending() bool {
+	return c.partial && c.oSkipSeq > c.oAckedSeq
+}
+
+// applySkipHeader tells the remote endpoint about the abandoned packets it
+// has not acknowledged yet.
+func (c *Channel) applySkipHeader(hdr *lob.Header) {
+	if !c.partial {
+		return
+	}
+	c.advanceSkip()
+	if c.oSkipSeq > c.oAckedSeq {
+		hdr.SetUint32(hdrSkip, c.oSkipSeq)
+	}
+}
+
+// receivedSkip records the seqs the remote endpoint abandoned.
+func (c *Channel) receivedSkip(hdr *lob.Header) {
+	if !c.partial {
+		return
+	}
+
+	skip, found := hdr.GetUint32(hdrSkip)
+	if !found || skip <= c.iSkipSeq {
+		return
+	}
+
+	// never skip beyond the window the read buffer can accept
+	if limit := c.iSeq + cReadBufferSize; skip > limit {
+		skip = limit
+	}
+
+	c.iSkipSeq = skip
+	if c.advanceReadSeq() {
+		c.maybeDeliverAdHocAck()
+		c.cndRead.Signal()
+		c.cndWrite.Signal()
+	}
+}
+
+// advanceReadSeq moves iSeq over the packets that were already read out of
+// order and over the abandoned packets that were not received. It returns true
+// when iSeq changed.
+func (c *Channel) advanceReadSeq() bool {
+	old := c.iSeq
+	for {
+		next := c.iSeq + 1
+		if c.iDelivered[next] {
+			delete(c.iDelivered, next)
+			c.iSeq = next
+			continue
+		}
+		if next <= c.iSkipSeq && c.readBuffer.IndexOf(next) < 0 {
+			c.iSeq = next
+			continue
+		}
+		return c.iSeq != old
+	}
+}
+
+// nextReadable returns the index of the next packet in the read buffer that
+// can be handed to the reader or -1 when there is none.
+func (c *Channel) nextReadable() int {
+	if len(c.readBuffer) == 0 {
+		return -1
+	}
+
+	if !c.unordered || c.iSeq == cBlankSeq {
+		if c.readBuffer[0].seq == c.iSeq+1 {
+			return 0
+		}
+		return -1
+	}
+
+	for i, e := range c.readBuffer {
+		if !e.end || e.seq == c.iSeq+1 {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,312 @@
+package e3x
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/tracer"
+)
+
+// captureExchange records the packets delivered by a channel.
+type captureExchange struct {
+	mtx  sync.Mutex
+	sent []*lob.Packet
+}
+
+func (x *captureExchange) deliverPacket(pkt *lob.Packet, dst *Pipe) error {
+	x.mtx.Lock()
+	x.sent = append(x.sent, pkt)
+	x.mtx.Unlock()
+	return nil
+}
+
+func (x *captureExchange) pathFeedback(loss bool)       {}
+func (x *captureExchange) resendTimeout() time.Duration { return time.Second }
+func (x *captureExchange) RemoteIdentity() *Identity    { return nil }
+func (x *captureExchange) getTID() tracer.ID            { return 0 }
+
+func (x *captureExchange) last() *lob.Packet {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if len(x.sent) == 0 {
+		return nil
+	}
+	return x.sent[len(x.sent)-1]
+}
+
+func modePacket(seq uint32, body string, end bool) *lob.Packet {
+	pkt := lob.New([]byte(body))
+	hdr := pkt.Header()
+	hdr.C, hdr.HasC = 1, true
+	hdr.Seq, hdr.HasSeq = seq, true
+	if end {
+		hdr.End, hdr.HasEnd = true, true
+	}
+	return pkt
+}
+
+// openServerChannel returns a serverside channel which read the open packet
+// and answered it.
+func openServerChannel(t *testing.T, open *lob.Packet) (*Channel, *captureExchange) {
+	x := &captureExchange{}
+	open.Header().Type, open.Header().HasType = "test", true
+
+	c := newChannel("a", "test", true, true, x, acceptModes(open.Header()))
+	c.id = 1
+	c.receivedPacket(open, nil)
+
+	pkt, err := c.ReadPacket()
+	if assert.NoError(t, err) {
+		_, found := pkt.Header().Get(hdrUnordered)
+		assert.False(t, found, "mode headers must be removed")
+	}
+	assert.NoError(t, c.WritePacket(lob.New([]byte("welcome"))))
+
+	return c, x
+}
+
+func readBody(t *testing.T, c *Channel) string {
+	pkt, err := c.ReadPacket()
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	return string(pkt.Body(nil))
+}
+
+func TestChannelModeOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newChannel("a", "test", false, false, &captureExchange{})
+	assert.Equal(ErrUnreliableChannelMode, c.setOptions(Unordered()))
+	assert.Equal(ErrUnreliableChannelMode, c.setOptions(PartiallyReliable(time.Second)))
+	c.unsetTimers()
+
+	c = newChannel("a", "test", true, false, &captureExchange{})
+	assert.Equal(ErrNotPartiallyReliable, c.WritePacketUntil(lob.New(nil), time.Now()))
+	c.unsetTimers()
+}
+
+func TestChannelModeNegotiation(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &captureExchange{}
+	c := newChannel("a", "test", true, false, x, Unordered(), PartiallyReliable(250*time.Millisecond))
+	defer c.unsetTimers()
+
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	open := x.last()
+	if assert.NotNil(open) {
+		hdr := open.Header()
+		unordered, _ := hdr.GetBool(hdrUnordered)
+		partial, _ := hdr.GetBool(hdrPartial)
+		ttl, _ := hdr.GetUint32(hdrTTL)
+		assert.True(unordered)
+		assert.True(partial)
+		assert.Equal(uint32(250), ttl)
+
+		s, sx := openServerChannel(t, modePacket(1, "hello", false).SetHeader(*hdr))
+		defer s.unsetTimers()
+		assert.True(s.Unordered())
+		assert.True(s.PartiallyReliable())
+		assert.Equal(250*time.Millisecond, s.ttl)
+
+		// the reply confirms the accepted modes
+		assert.False(c.ModesConfirmed())
+		reply := sx.last()
+		if assert.NotNil(reply) {
+			c.receivedPacket(reply, nil)
+		}
+		assert.True(c.ModesConfirmed())
+		assert.True(c.Unordered())
+		assert.True(c.PartiallyReliable())
+		assert.Equal("welcome", readBody(t, c))
+	}
+}
+
+func TestChannelModeDowngrade(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &captureExchange{}
+	c := newChannel("a", "test", true, false, x, Unordered(), PartiallyReliable(time.Second))
+	defer c.unsetTimers()
+	c.id = 1
+
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	// the serverside only accepted the unordered mode
+	reply := modePacket(1, "welcome", false)
+	reply.Header().Ack, reply.Header().HasAck = 1, true
+	reply.Header().SetBool(hdrUnordered, true)
+	c.receivedPacket(reply, nil)
+
+	assert.True(c.ModesConfirmed())
+	assert.True(c.Unordered())
+	assert.False(c.PartiallyReliable())
+	assert.Equal(ErrNotPartiallyReliable, c.WritePacketUntil(lob.New(nil), time.Now()))
+}
+
+func TestUnorderedChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	open := modePacket(1, "hello", false)
+	open.Header().SetBool(hdrUnordered, true)
+
+	c, _ := openServerChannel(t, open)
+	defer c.unsetTimers()
+
+	c.receivedPacket(modePacket(3, "three", false), nil)
+	assert.Equal("three", readBody(t, c))
+	assert.Equal(uint32(1), c.Info().ReceiveSeq)
+
+	// the read packet is not requested again
+	c.mtx.Lock()
+	assert.Equal([]uint32{1, 99}, c.buildMissList())
+	c.mtx.Unlock()
+
+	c.receivedPacket(modePacket(3, "three", false), nil)
+	assert.Equal(0, c.Stats().Buffered, "duplicate must be dropped")
+
+	// the end packet is only read in order
+	c.receivedPacket(modePacket(5, "", true), nil)
+	c.mtx.Lock()
+	assert.Equal(-1, c.nextReadable())
+	c.mtx.Unlock()
+
+	c.receivedPacket(modePacket(4, "four", false), nil)
+	assert.Equal("four", readBody(t, c))
+	c.receivedPacket(modePacket(2, "two", false), nil)
+	assert.Equal("two", readBody(t, c))
+	assert.Equal(uint32(4), c.Info().ReceiveSeq)
+
+	_, err := c.ReadPacket()
+	assert.Equal(io.EOF, err)
+}
+
+func TestPartiallyReliableSender(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &captureExchange{}
+	c := newChannel("a", "test", true, false, x, PartiallyReliable(0))
+	defer c.unsetTimers()
+	c.id = 1
+
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	ack := &lob.Packet{}
+	ack.Header().C, ack.Header().HasC = 1, true
+	ack.Header().Ack, ack.Header().HasAck = 1, true
+	ack.Header().SetBool(hdrPartial, true)
+	c.receivedPacket(ack, nil)
+	assert.True(c.PartiallyReliable())
+
+	assert.NoError(c.WritePacketUntil(lob.New([]byte("stale")), time.Now().Add(50*time.Millisecond)))
+	assert.NoError(c.WritePacket(lob.New([]byte("fresh"))))
+	assert.Equal(2, c.Stats().InFlight)
+
+	// the skip is announced at the deadline, long before the resend timeout
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(1, c.Stats().InFlight)
+	assert.Equal(uint32(2), c.Info().SendSkipSeq)
+	if pkt := x.last(); assert.NotNil(pkt) {
+		assert.Equal(0, pkt.BodyLen())
+		skip, _ := pkt.Header().GetUint32(hdrSkip)
+		assert.Equal(uint32(2), skip)
+	}
+
+	c.mtx.Lock()
+	c.resendLast(true)
+
+	if pkt := x.last(); assert.NotNil(pkt) {
+		assert.Equal("fresh", string(pkt.Body(nil)))
+		skip, _ := pkt.Header().GetUint32(hdrSkip)
+		assert.Equal(uint32(2), skip)
+	}
+}
+
+func TestPartiallyReliableReceiver(t *testing.T) {
+	assert := assert.New(t)
+
+	open := modePacket(1, "hello", false)
+	open.Header().SetBool(hdrPartial, true)
+
+	c, _ := openServerChannel(t, open)
+	defer c.unsetTimers()
+
+	pkt := modePacket(3, "three", false)
+	pkt.Header().SetUint32(hdrSkip, 2)
+	c.receivedPacket(pkt, nil)
+
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal("three", string(pkt.Body(nil)))
+		_, found := pkt.Header().Get(hdrSkip)
+		assert.False(found)
+	}
+	assert.Equal(uint32(3), c.Info().ReceiveSeq)
+
+	// the abandoned packet arrives late
+	c.receivedPacket(modePacket(2, "two", false), nil)
+	assert.Equal(0, c.Stats().Buffered)
+}
+
+func TestUnorderedChannelOverExchange(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	B.Mux().HandleFunc("echo", true, func(c *Channel) {
+		defer c.Close()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			if pkt.BodyLen() == 0 {
+				continue
+			}
+			if !c.Unordered() || !c.PartiallyReliable() {
+				c.Errorf("modes not negotiated")
+				return
+			}
+			if err = c.WritePacket(lob.New(pkt.Body(nil))); err != nil {
+				return
+			}
+		}
+	})
+
+	c, err := A.Open(B.mustLocalIdentity(t), "echo", true, Unordered(), PartiallyReliable(time.Second))
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	for _, body := range []string{"hello", "world"} {
+		if assert.NoError(c.WritePacket(lob.New([]byte(body)))) {
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err) {
+				assert.Equal(body, string(pkt.Body(nil)))
+			}
+		}
+	}
+
+	// the serverside confirmed both modes
+	assert.True(c.ModesConfirmed())
+	assert.True(c.Unordered())
+	assert.True(c.PartiallyReliable())
+
+	assert.NoError(c.Close())
+
+	_, err = A.Open(B.mustLocalIdentity(t), "echo", false, Unordered())
+	assert.Equal(ErrUnreliableChannelMode, err)
+}
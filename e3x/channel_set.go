@@ -92,6 +92,32 @@ func (p *channelSetAddPromise) Cancel() {
 	p.set.mtx.Unlock()
 }
 
+// Count is like (*channelSet).Count but can be used while the promise holds
+// the lock on the set.
+func (p *channelSetAddPromise) Count(typ string) (n, nType int) {
+	return p.set.count(typ)
+}
+
+// Count returns the number of channels and the number of channels of type typ.
+func (set *channelSet) Count(typ string) (n, nType int) {
+	set.mtx.RLock()
+	defer set.mtx.RUnlock()
+	return set.count(typ)
+}
+
+func (set *channelSet) count(typ string) (n, nType int) {
+	for _, c := range set.channels {
+		if c == nil {
+			continue
+		}
+		n++
+		if c.typ == typ {
+			nType++
+		}
+	}
+	return n, nType
+}
+
 func (set *channelSet) Add(id uint32, c *Channel) (ok bool) {
 	set.mtx.Lock()
 	defer set.mtx.Unlock()
@@ -2,9 +2,11 @@ package e3x
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -565,3 +567,177 @@ func BenchmarkChannelsReliable(b *testing.B) {
 		b.StopTimer()
 	})
 }
+
+func TestPacketHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	var (
+		mtx     sync.Mutex
+		errVeto = errors.New("vetoed")
+		audited []string
+		acked   int
+	)
+
+	A.DefaultChannelHooks().Register(ChannelHook{
+		OnSendPacket: func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error {
+			if pkt.BodyLen() > 0 && string(pkt.Body(nil)) == "secret" {
+				return errVeto
+			}
+			pkt.Header().SetString("audit", "A")
+			return nil
+		},
+		OnAckPacket: func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error {
+			mtx.Lock()
+			acked++
+			mtx.Unlock()
+			return nil
+		},
+	})
+
+	B.DefaultChannelHooks().Register(ChannelHook{
+		OnReceivePacket: func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error {
+			if v, ok := pkt.Header().GetString("audit"); ok && pkt.BodyLen() > 0 {
+				mtx.Lock()
+				audited = append(audited, v+":"+string(pkt.Body(nil)))
+				mtx.Unlock()
+			}
+			return nil
+		},
+	})
+
+	B.Mux().HandleFunc("echo", true, func(c *Channel) {
+		defer c.Close()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			if pkt.BodyLen() == 0 {
+				continue
+			}
+			if err = c.WritePacket(lob.New(pkt.Body(nil))); err != nil {
+				return
+			}
+		}
+	})
+
+	c, err := A.Open(B.mustLocalIdentity(t), "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, body := range []string{"hello", "secret", "world"} {
+		err := c.WritePacket(lob.New([]byte(body)))
+		if body == "secret" {
+			assert.Equal(errVeto, err)
+			continue
+		}
+		if assert.NoError(err) {
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err) {
+				assert.Equal(body, string(pkt.Body(nil)))
+			}
+		}
+	}
+
+	assert.NoError(c.Close())
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(2, len(audited))
+	if len(audited) == 2 {
+		assert.Equal("A:hello", audited[0])
+		assert.Equal("A:world", audited[1])
+	}
+	assert.True(acked > 0)
+}
+
+func TestVetoedPacketIsRestored(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		x       = &captureExchange{}
+		errVeto = errors.New("vetoed")
+		veto    = true
+	)
+
+	c := newChannel("a", "test", true, false, x, Unordered())
+	defer c.unsetTimers()
+	c.id = 1
+	c.channelHooks.Register(ChannelHook{
+		OnSendPacket: func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error {
+			pkt.Header().SetString("audit", "A")
+			if veto {
+				return errVeto
+			}
+			return nil
+		},
+	})
+
+	pkt := lob.New([]byte("hello"))
+	pkt.Header().SetString("app", "x")
+
+	assert.Equal(errVeto, c.WritePacket(pkt))
+	hdr := pkt.Header()
+	assert.False(hdr.HasC || hdr.HasSeq || hdr.HasType)
+	assert.Equal(map[string]interface{}{"app": "x"}, hdr.Extra)
+	assert.Equal(uint32(0), c.Info().SendSeq)
+	assert.Nil(x.last())
+
+	// the restored packet is written as the open packet
+	veto = false
+	assert.NoError(c.WritePacket(pkt))
+	if sent := x.last(); assert.NotNil(sent) {
+		hdr := sent.Header()
+		assert.Equal(uint32(1), hdr.Seq)
+		assert.Equal("test", hdr.Type)
+		unordered, _ := hdr.GetBool(hdrUnordered)
+		assert.True(unordered)
+	}
+}
+
+func TestReadPacketFrom(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newChannel("a", "test", false, true, &captureExchange{})
+	defer c.unsetTimers()
+
+	var (
+		p1 = &Pipe{}
+		p2 = &Pipe{}
+	)
+
+	c.receivedPacket(lob.New([]byte("one")), p1)
+	c.receivedPacket(lob.New([]byte("two")), p2)
+
+	pkt, src, err := c.ReadPacketFrom()
+	if assert.NoError(err) {
+		assert.Equal("one", string(pkt.Body(nil)))
+		assert.True(src == p1)
+	}
+	assert.NoError(c.WritePacketTo(lob.New([]byte("reply")), src))
+
+	pkt, src, err = c.ReadPacketFrom()
+	if assert.NoError(err) {
+		assert.Equal("two", string(pkt.Body(nil)))
+		assert.True(src == p2)
+	}
+}
+
+func TestReadBufferIndexOf(t *testing.T) {
+	assert := assert.New(t)
+
+	s := readBufferSlice{{seq: 2}, {seq: 4}, {seq: 5}}
+	assert.Equal(0, s.IndexOf(2))
+	assert.Equal(2, s.IndexOf(5))
+	assert.Equal(-1, s.IndexOf(3))
+	assert.Equal(-1, s.IndexOf(1))
+	assert.Equal(-1, s.IndexOf(6))
+}
@@ -0,0 +1,203 @@
+// Package dashboard serves a live view of the state of an endpoint, its
+// exchanges, their address books and their channels.
+//
+//   http.Handle("/debug/telehash", dashboard.Handler(e))
+//
+// The handler serves an HTML page or, when the client asks for it (with
+// ?format=json or an Accept header of application/json), JSON.
+package dashboard
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+)
+
+// Handler returns a http.Handler which serves the dashboard of e.
+func Handler(e *e3x.Endpoint) http.Handler {
+	return &handler{e}
+}
+
+type handler struct {
+	e *e3x.Endpoint
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := Collect(h.e)
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(snapshot)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Snapshot is the state of an endpoint.
+type Snapshot struct {
+	Hashname   string      `json:"hashname"`
+	Transports []Transport `json:"transports"`
+	Exchanges  []Exchange  `json:"exchanges"`
+	At         time.Time   `json:"at"`
+}
+
+// Transport lists the local addresses of a transport network.
+type Transport struct {
+	Network   string   `json:"network"`
+	Addresses []string `json:"addresses"`
+}
+
+type Exchange struct {
+	Remote      string    `json:"remote"`
+	State       string    `json:"state"`
+	CSID        string    `json:"csid"`
+	LocalToken  string    `json:"local_token"`
+	RemoteToken string    `json:"remote_token"`
+	RTT         float64   `json:"rtt_ms"` // smoothed latency of the active path
+	Handshakes  uint64    `json:"handshakes"`
+	Paths       []Path    `json:"paths"`
+	Channels    []Channel `json:"channels"`
+}
+
+// Path is an entry in the address book of an exchange.
+type Path struct {
+	Network   string    `json:"network"`
+	Address   string    `json:"address"`
+	Active    bool      `json:"active"`
+	Reachable bool      `json:"reachable"`
+	Verified  bool      `json:"verified"`
+	Backup    bool      `json:"backup"`
+	Relayed   bool      `json:"relayed"`
+	Latency   float64   `json:"latency_ms"`
+	EWMA      float64   `json:"ewma_ms"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+type Channel struct {
+	ID         uint32 `json:"id"`
+	Type       string `json:"type"`
+	Reliable   bool   `json:"reliable"`
+	Unordered  bool   `json:"unordered"`
+	Partial    bool   `json:"partial"`
+	Serverside bool   `json:"serverside"`
+	Broken     bool   `json:"broken"`
+
+	SendSeq            uint32 `json:"send_seq"`
+	SendAckedSeq       uint32 `json:"send_acked_seq"`
+	ReceiveSeq         uint32 `json:"receive_seq"`
+	ReceiveSeenSeq     uint32 `json:"receive_seen_seq"`
+	ReceiveBufferedSeq uint32 `json:"receive_buffered_seq"`
+	ReceiveAckedSeq    uint32 `json:"receive_acked_seq"`
+
+	InFlight      int `json:"in_flight"`
+	Buffered      int `json:"buffered"`
+	BufferedBytes int `json:"buffered_bytes"`
+}
+
+// Collect takes a snapshot of e.
+func Collect(e *e3x.Endpoint) *Snapshot {
+	s := &Snapshot{
+		Hashname: string(e.LocalHashname()),
+		At:       time.Now(),
+	}
+
+	if t := e3x.TransportsFromEndpoint(e); t != nil {
+		index := map[string]int{}
+		for _, addr := range t.LocalAddresses() {
+			i, found := index[addr.Network()]
+			if !found {
+				i = len(s.Transports)
+				index[addr.Network()] = i
+				s.Transports = append(s.Transports, Transport{Network: addr.Network()})
+			}
+			s.Transports[i].Addresses = append(s.Transports[i].Addresses, addr.String())
+		}
+		sort.Slice(s.Transports, func(i, j int) bool { return s.Transports[i].Network < s.Transports[j].Network })
+	}
+
+	exchanges := e.GetExchanges()
+	sort.Slice(exchanges, func(i, j int) bool { return exchanges[i].RemoteHashname() < exchanges[j].RemoteHashname() })
+	for _, x := range exchanges {
+		s.Exchanges = append(s.Exchanges, collectExchange(x))
+	}
+
+	return s
+}
+
+func collectExchange(x *e3x.Exchange) Exchange {
+	var (
+		stats       = x.Stats()
+		localToken  = x.LocalToken()
+		remoteToken = x.RemoteToken()
+	)
+
+	xs := Exchange{
+		Remote:      string(x.RemoteHashname()),
+		State:       x.State().String(),
+		CSID:        fmt.Sprintf("%02x", x.CSID()),
+		LocalToken:  hex.EncodeToString(localToken[:]),
+		RemoteToken: hex.EncodeToString(remoteToken[:]),
+		RTT:         milliseconds(stats.SmoothedRTT),
+		Handshakes:  stats.Handshakes,
+	}
+
+	for _, p := range x.Paths() {
+		xs.Paths = append(xs.Paths, Path{
+			Network:   p.Addr.Network(),
+			Address:   p.Addr.String(),
+			Active:    p.Active,
+			Reachable: p.Reachable,
+			Verified:  p.Verified,
+			Backup:    p.Backup,
+			Relayed:   p.Relayed,
+			Latency:   milliseconds(p.Latency),
+			EWMA:      milliseconds(p.EWMA),
+			ExpireAt:  p.ExpireAt,
+		})
+	}
+
+	channels := x.Channels()
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Info().ID < channels[j].Info().ID })
+	for _, c := range channels {
+		var (
+			info  = c.Info()
+			stats = c.Stats()
+		)
+		xs.Channels = append(xs.Channels, Channel{
+			ID:                 info.ID,
+			Type:               info.Type,
+			Reliable:           info.Reliable,
+			Unordered:          info.Unordered,
+			Partial:            info.Partial,
+			Serverside:         info.Serverside,
+			Broken:             info.Broken,
+			SendSeq:            info.SendSeq,
+			SendAckedSeq:       info.SendAckedSeq,
+			ReceiveSeq:         info.ReceiveSeq,
+			ReceiveSeenSeq:     info.ReceiveSeenSeq,
+			ReceiveBufferedSeq: info.ReceiveBufferedSeq,
+			ReceiveAckedSeq:    info.ReceiveAckedSeq,
+			InFlight:           stats.InFlight,
+			Buffered:           stats.Buffered,
+			BufferedBytes:      stats.BufferedBytes,
+		})
+	}
+
+	return xs
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,94 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Log(nil), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(e3x.Log(nil), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	B.Mux().HandleFunc("echo", true, func(c *e3x.Channel) {
+		defer c.Close()
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(pkt)
+	})
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := A.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+
+	{ // JSON
+		w := httptest.NewRecorder()
+		Handler(A).ServeHTTP(w, httptest.NewRequest("GET", "/debug/telehash?format=json", nil))
+		assert.Equal("application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+		var s Snapshot
+		if assert.NoError(json.Unmarshal(w.Body.Bytes(), &s)) {
+			assert.Equal(string(A.LocalHashname()), s.Hashname)
+			if assert.Equal(1, len(s.Transports)) {
+				assert.Equal("udp4", s.Transports[0].Network)
+				assert.Equal(1, len(s.Transports[0].Addresses))
+			}
+
+			if assert.Equal(1, len(s.Exchanges)) {
+				x := s.Exchanges[0]
+				assert.Equal(string(B.LocalHashname()), x.Remote)
+				assert.Equal("active", x.State)
+				assert.Equal(32, len(x.LocalToken))
+				if assert.Equal(1, len(x.Paths)) {
+					assert.True(x.Paths[0].Active)
+					assert.True(x.Paths[0].Reachable)
+				}
+				if assert.Equal(1, len(x.Channels)) {
+					ch := x.Channels[0]
+					assert.Equal("echo", ch.Type)
+					assert.True(ch.Reliable)
+					assert.False(ch.Serverside)
+					assert.Equal(uint32(1), ch.SendSeq)
+					assert.Equal(uint32(1), ch.ReceiveSeq)
+				}
+			}
+		}
+	}
+
+	{ // HTML
+		w := httptest.NewRecorder()
+		Handler(A).ServeHTTP(w, httptest.NewRequest("GET", "/debug/telehash", nil))
+		assert.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(w.Body.String(), string(B.LocalHashname()))
+		assert.Contains(w.Body.String(), "<td>echo</td>")
+	}
+}
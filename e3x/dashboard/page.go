@@ -0,0 +1,61 @@
+package dashboard
+
+import (
+	"fmt"
+	"html/template"
+)
+
+var page = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ms": func(f float64) string { return fmt.Sprintf("%.1fms", f) },
+	"yes": func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>telehash {{.Hashname}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; font-family: monospace; }
+tr.active { font-weight: bold; }
+code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Endpoint <code>{{.Hashname}}</code></h1>
+<p><a href="?format=json">JSON</a> &middot; {{.At.Format "2006-01-02 15:04:05"}}</p>
+
+<h2>Transports</h2>
+<table>
+<tr><th>Network</th><th>Local addresses</th></tr>
+{{range .Transports}}<tr><td>{{.Network}}</td><td>{{range .Addresses}}<code>{{.}}</code> {{end}}</td></tr>
+{{end}}</table>
+
+<h2>Exchanges</h2>
+{{range .Exchanges}}
+<h3><code>{{.Remote}}</code></h3>
+<table>
+<tr><th>State</th><th>CSID</th><th>Local token</th><th>Remote token</th><th>RTT</th><th>Handshakes</th></tr>
+<tr><td>{{.State}}</td><td>{{.CSID}}</td><td><code>{{.LocalToken}}</code></td><td><code>{{.RemoteToken}}</code></td><td class="num">{{ms .RTT}}</td><td class="num">{{.Handshakes}}</td></tr>
+</table>
+
+<table>
+<tr><th>Path</th><th>Network</th><th>Active</th><th>Reachable</th><th>Verified</th><th>Backup</th><th>Relayed</th><th>Latency</th><th>EWMA</th></tr>
+{{range .Paths}}<tr{{if .Active}} class="active"{{end}}><td><code>{{.Address}}</code></td><td>{{.Network}}</td><td>{{yes .Active}}</td><td>{{yes .Reachable}}</td><td>{{yes .Verified}}</td><td>{{yes .Backup}}</td><td>{{yes .Relayed}}</td><td class="num">{{ms .Latency}}</td><td class="num">{{ms .EWMA}}</td></tr>
+{{end}}</table>
+
+{{if .Channels}}<table>
+<tr><th>Channel</th><th>Type</th><th>Reliable</th><th>Opened by</th><th>Send seq / acked</th><th>Receive seq / seen / buffered / acked</th><th>In flight</th><th>Buffered</th><th>Buffered bytes</th></tr>
+{{range .Channels}}<tr><td class="num">{{.ID}}</td><td>{{.Type}}{{if .Broken}} (broken){{end}}</td><td>{{yes .Reliable}}{{if .Unordered}}, unordered{{end}}{{if .Partial}}, partial{{end}}</td><td>{{if .Serverside}}remote{{else}}local{{end}}</td><td class="num">{{.SendSeq}} / {{.SendAckedSeq}}</td><td class="num">{{.ReceiveSeq}} / {{.ReceiveSeenSeq}} / {{.ReceiveBufferedSeq}} / {{.ReceiveAckedSeq}}</td><td class="num">{{.InFlight}}</td><td class="num">{{.Buffered}}</td><td class="num">{{.BufferedBytes}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
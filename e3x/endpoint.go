@@ -1,14 +1,19 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/trace"
 	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/logs"
@@ -19,11 +24,16 @@ import (
 	"github.com/telehash/gogotelehash/transports/udp"
 )
 
+// ErrShuttingDown is returned when a new exchange or channel is requested
+// while the endpoint is shutting down.
+var ErrShuttingDown = errors.New("e3x: endpoint is shutting down")
+
 type endpointState uint8
 
 const (
 	endpointStateUnknown endpointState = iota
 	endpointStateRunning
+	endpointStateDraining
 	endpointStateTerminated
 	endpointStateBroken
 )
@@ -39,9 +49,19 @@ type Endpoint struct {
 	hashname        hashname.H
 	keys            cipherset.Keys
 	log             *logs.Logger
+	tracer          *tracer.Tracer
 	transportConfig transports.Config
 	transport       transports.Transport
 	modules         map[interface{}]Module
+	dialStagger     time.Duration
+	dialAfter       func(time.Duration) <-chan time.Time // replaces the stagger timer in tests
+	cloakRounds     int
+	exchangePolicy  ExchangePolicy
+	quotas          Quotas
+	mux             *ServeMux
+	router          *ServeMux
+	multipath       MultipathMode
+	stats           *statCounters
 
 	endpointHooks EndpointHooks
 	exchangeHooks ExchangeHooks
@@ -60,6 +80,12 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 		modules:   make(map[interface{}]Module),
 		tokens:    make(map[cipherset.Token]*Exchange),
 		hashnames: make(map[hashname.H]*Exchange),
+
+		dialStagger:    cDefaultDialStagger,
+		exchangePolicy: DefaultExchangePolicy,
+		mux:            NewServeMux(),
+		stats:          &statCounters{},
+		tracer:         tracer.Default(),
 	}
 
 	e.listenerSet = newListenerSet()
@@ -122,13 +148,30 @@ func (e *Endpoint) DefaultExchangeHooks() *ExchangeHooks {
 	return &e.exchangeHooks
 }
 
+// Mux returns the ServeMux which handles the channels for which there is no
+// Listener. Each endpoint has its own Mux; modules register their handlers on
+// it.
+func (e *Endpoint) Mux() *ServeMux {
+	return e.mux
+}
+
+// Router passes the channels which are handled by neither a Listener nor the
+// Mux of the endpoint to mux. Unlike the Mux of an endpoint, mux may be shared
+// by multiple endpoints.
+func Router(mux *ServeMux) EndpointOption {
+	return func(e *Endpoint) error {
+		e.router = mux
+		return nil
+	}
+}
+
 func (e *Endpoint) DefaultChannelHooks() *ChannelHooks {
 	return &e.channelHooks
 }
 
 func (e *Endpoint) traceError(err error) error {
-	if tracer.Enabled && err != nil {
-		tracer.Emit("endpoint.error", tracer.Info{
+	if e.tracer.Enabled() && err != nil {
+		e.tracer.Emit("endpoint.error", 0, tracer.Info{
 			"endpoint_id": e.TID,
 			"error":       err.Error(),
 		})
@@ -137,8 +180,8 @@ func (e *Endpoint) traceError(err error) error {
 }
 
 func (e *Endpoint) traceNew() {
-	if tracer.Enabled {
-		tracer.Emit("endpoint.new", tracer.Info{
+	if e.tracer.Enabled() {
+		e.tracer.Emit("endpoint.new", 0, tracer.Info{
 			"endpoint_id": e.TID,
 			"hashname":    e.hashname.String(),
 		})
@@ -146,15 +189,15 @@ func (e *Endpoint) traceNew() {
 }
 
 func (e *Endpoint) traceStarted() {
-	if tracer.Enabled {
-		tracer.Emit("endpoint.started", tracer.Info{
+	if e.tracer.Enabled() {
+		e.tracer.Emit("endpoint.started", 0, tracer.Info{
 			"endpoint_id": e.TID,
 		})
 	}
 }
 
 func (e *Endpoint) traceReceivedPacket(msg message) {
-	if tracer.Enabled {
+	if e.tracer.Sampled(msg.TID) {
 		pkt := tracer.Info{
 			"msg": base64.StdEncoding.EncodeToString(msg.Data.Get(nil)),
 		}
@@ -163,7 +206,7 @@ func (e *Endpoint) traceReceivedPacket(msg message) {
 			pkt["src"] = msg.Pipe.raddr.String()
 		}
 
-		tracer.Emit("endpoint.rcv.packet", tracer.Info{
+		e.tracer.Emit("endpoint.rcv.packet", msg.TID, tracer.Info{
 			"endpoint_id": e.TID,
 			"packet_id":   msg.TID,
 			"packet":      pkt,
@@ -172,7 +215,8 @@ func (e *Endpoint) traceReceivedPacket(msg message) {
 }
 
 func (e *Endpoint) traceDroppedPacket(msg []byte, conn net.Conn, reason string) {
-	if tracer.Enabled {
+	packetID := tracer.NewID()
+	if e.tracer.Sampled(packetID) {
 		pkt := tracer.Info{
 			"msg": base64.StdEncoding.EncodeToString(msg),
 		}
@@ -182,9 +226,9 @@ func (e *Endpoint) traceDroppedPacket(msg []byte, conn net.Conn, reason string)
 			pkt["dst"] = conn.LocalAddr()
 		}
 
-		tracer.Emit("endpoint.drop.packet", tracer.Info{
+		e.tracer.Emit("endpoint.drop.packet", packetID, tracer.Info{
 			"endpoint_id": e.TID,
-			"packet_id":   tracer.NewID(),
+			"packet_id":   packetID,
 			"reason":      reason,
 			"packet":      pkt,
 		})
@@ -253,13 +297,25 @@ func defaultRandomKeys(e *Endpoint) error {
 	return Keys(keys)(e)
 }
 
+// Log writes all the records of the endpoint, including the debug records
+// (like opened and closed channels and path changes), to w (in the logfmt
+// style of slog.TextHandler). When w is nil the records are written to
+// os.Stderr. Use LogHandler to filter the records by level.
 func Log(w io.Writer) EndpointOption {
 	if w == nil {
 		w = os.Stderr
 	}
 
+	return LogHandler(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// LogHandler passes the log records of the endpoint to h. The handler decides
+// on the level and the format of the records.
+//
+//   e3x.LogHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+func LogHandler(h slog.Handler) EndpointOption {
 	return func(e *Endpoint) error {
-		e.log = logs.New(w).Module("e3x")
+		e.log = logs.New(h).Module("e3x")
 		if e.hashname != "" {
 			e.log = e.log.From(e.hashname)
 		}
@@ -267,6 +323,20 @@ func Log(w io.Writer) EndpointOption {
 	}
 }
 
+// Trace passes the trace events of the endpoint, its exchanges and its channels
+// to sink. Only a fraction rate (0 < rate <= 1) of the exchanges and of the
+// packets received by the endpoint is traced; the lifecycle events of the
+// endpoint itself are always passed on. A nil sink disables tracing.
+//
+// Without this option the endpoint writes its trace events to stdout when the
+// TH_TRACER environment variable is set to "on".
+func Trace(sink trace.Sink, rate float64) EndpointOption {
+	return func(e *Endpoint) error {
+		e.tracer = tracer.New(sink, rate)
+		return nil
+	}
+}
+
 func DisableLog() EndpointOption {
 	return func(e *Endpoint) error {
 		e.log = nil
@@ -285,6 +355,34 @@ func Transport(config transports.Config) EndpointOption {
 	}
 }
 
+// DialStagger sets the delay between the handshakes that are sent to the
+// different paths of a peer while dialing (happy eyeballs). Paths with a lower
+// latency are tried first and the next path is tried immediately when writing
+// to a path fails. Pending attempts are cancelled once the exchange is open.
+// A delay of 0 sends the handshakes to all the paths at once.
+func DialStagger(d time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		if d < 0 {
+			d = 0
+		}
+		e.dialStagger = d
+		return nil
+	}
+}
+
+// Cloaking makes the endpoint cloak all outgoing packets so they look like
+// random noise. Every round of cloaking adds 8 bytes to a packet. Cloaked
+// packets are always uncloaked, this option only affects outgoing packets.
+func Cloaking(rounds int) EndpointOption {
+	return func(e *Endpoint) error {
+		if rounds < 0 {
+			rounds = 0
+		}
+		e.cloakRounds = rounds
+		return nil
+	}
+}
+
 func defaultTransport(e *Endpoint) error {
 	if e.transportConfig != nil {
 		return nil
@@ -344,6 +442,10 @@ func (e *Endpoint) start() error {
 		}
 	}
 
+	// accept() reads the state while the transport is already running
+	e.mtx.Lock()
+	e.state = endpointStateRunning
+	e.mtx.Unlock()
 	return nil
 }
 
@@ -354,6 +456,64 @@ func (e *Endpoint) Close() error {
 	return e.close()
 }
 
+// Shutdown gracefully closes the endpoint. New exchanges and channels are
+// refused (with an err packet) and the open channels are given the chance to
+// flush their write buffers and to deliver an end packet. When ctx is done
+// before all the channels are flushed the remaining channels are closed with
+// an err packet and the exchanges are broken; ctx.Err() is returned.
+func (e *Endpoint) Shutdown(ctx context.Context) error {
+	e.mtx.Lock()
+	if e.state != endpointStateRunning {
+		err := e.err
+		e.mtx.Unlock()
+		return err
+	}
+	e.state = endpointStateDraining
+
+	exchanges := e.exchanges()
+	e.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, x := range exchanges {
+		wg.Add(1)
+		go func(x *Exchange) {
+			defer wg.Done()
+			x.shutdown(ctx)
+		}(x)
+	}
+	wg.Wait()
+
+	e.mtx.Lock()
+	err := e.close()
+	e.mtx.Unlock()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// exchanges returns all the exchanges of the endpoint. e.mtx must be held.
+func (e *Endpoint) exchanges() []*Exchange {
+	var (
+		seen      = make(map[*Exchange]bool)
+		exchanges []*Exchange
+	)
+	for _, x := range e.hashnames {
+		if !seen[x] {
+			seen[x] = true
+			exchanges = append(exchanges, x)
+		}
+	}
+	for _, x := range e.tokens {
+		if !seen[x] {
+			seen[x] = true
+			exchanges = append(exchanges, x)
+		}
+	}
+	return exchanges
+}
+
 func (e *Endpoint) close() error {
 	e.mtx.Unlock()
 
@@ -377,7 +537,7 @@ func (e *Endpoint) close() error {
 
 	e.transport.Close() //TODO handle err
 
-	if e.state == endpointStateRunning {
+	if e.state == endpointStateRunning || e.state == endpointStateDraining {
 		e.state = endpointStateTerminated
 	} else {
 		e.state = endpointStateBroken
@@ -415,6 +575,15 @@ func (e *Endpoint) accept(conn net.Conn) {
 	}
 	msg.SetLen(n)
 
+	err = uncloakBuffer(msg)
+	if err != nil {
+		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+			conn.Close()
+		}
+		msg.Free()
+		return // invalid cloaking
+	}
+
 	// msg is either a handshake or a channel packet
 	// when msg is a handshake decrypt it and pass it to the associated exchange
 	// when msg is a channel packet lookup the exchange and pass it the msg
@@ -514,6 +683,25 @@ func (e *Endpoint) accept(conn net.Conn) {
 		return
 	}
 
+	if e.state == endpointStateDraining {
+		if e.endpointHooks.DropPacket(msg.Get(nil), conn, ErrShuttingDown) != ErrStopPropagation {
+			conn.Close()
+		}
+		e.traceDroppedPacket(msg.Get(nil), conn, ErrShuttingDown.Error())
+		msg.Free()
+		return // drop
+	}
+
+	if e.quotas.MaxExchanges > 0 && len(e.hashnames) >= e.quotas.MaxExchanges {
+		statQuotaExchangeReject.Add(1)
+		if e.endpointHooks.DropPacket(msg.Get(nil), conn, ErrTooManyExchanges) != ErrStopPropagation {
+			conn.Close()
+		}
+		e.traceDroppedPacket(msg.Get(nil), conn, ErrTooManyExchanges.Error())
+		msg.Free()
+		return // drop
+	}
+
 	exchange, err = newExchange(localIdent, nil, handshake, e.log, registerEndpoint(e))
 	if err != nil {
 		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
@@ -527,7 +715,9 @@ func (e *Endpoint) accept(conn net.Conn) {
 	e.hashnames[hn] = exchange
 	e.tokens[exchange.LocalToken()] = exchange
 	e.tokens[exchange.RemoteToken()] = exchange
-	exchange.state = ExchangeDialing
+	exchange.mtx.Lock()
+	exchange.setState(ExchangeDialing)
+	exchange.mtx.Unlock()
 	exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
 }
 
@@ -731,8 +921,9 @@ func (e *Endpoint) GetExchanges() []*Exchange {
 
 // CreateExchange returns the exchange for identity. If the exchange already exists
 // it is simply returned otherwise a new exchange is created and registered.
-// Note that CreateExchange does not Dial.
-func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
+// Note that CreateExchange does not Dial. The options are only applied to new
+// exchanges.
+func (e *Endpoint) CreateExchange(identity *Identity, options ...ExchangeOption) (*Exchange, error) {
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
 
@@ -741,6 +932,15 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 		return x, nil
 	}
 
+	if e.state == endpointStateDraining {
+		return nil, ErrShuttingDown
+	}
+
+	if e.quotas.MaxExchanges > 0 && len(e.hashnames) >= e.quotas.MaxExchanges {
+		statQuotaExchangeReject.Add(1)
+		return nil, ErrTooManyExchanges
+	}
+
 	var (
 		localIdent *Identity
 		x          *Exchange
@@ -755,7 +955,8 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 	}
 
 	// Make a new exchange struct
-	x, err = newExchange(localIdent, identity, nil, e.log, registerEndpoint(e))
+	options = append([]ExchangeOption{registerEndpoint(e)}, options...)
+	x, err = newExchange(localIdent, identity, nil, e.log, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package e3x
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/telehash/gogotelehash/transports"
@@ -17,6 +18,7 @@ var (
 )
 
 type modNetwatch struct {
+	mtx       sync.Mutex
 	endpoint  *Endpoint
 	timer     *time.Timer
 	addresses []net.Addr
@@ -28,19 +30,24 @@ func (mod *modNetwatch) Init() error {
 
 func (mod *modNetwatch) Start() error {
 	mod.update()
+	mod.mtx.Lock()
 	mod.timer = time.AfterFunc(interval, mod.update)
+	mod.mtx.Unlock()
 	return nil
 }
 
 func (mod *modNetwatch) Stop() error {
+	mod.mtx.Lock()
 	if mod.timer != nil {
 		mod.timer.Stop()
 		mod.timer = nil
 	}
+	mod.mtx.Unlock()
 	return nil
 }
 
 func (mod *modNetwatch) update() {
+	mod.mtx.Lock()
 	if mod.timer != nil {
 		mod.timer.Reset(interval)
 	}
@@ -94,6 +101,7 @@ func (mod *modNetwatch) update() {
 	}
 
 	mod.addresses = update
+	mod.mtx.Unlock()
 
 	if len(newAddrs) > 0 || len(oldAddrs) > 0 {
 		mod.endpoint.Hooks().NetChanged(newAddrs, oldAddrs)
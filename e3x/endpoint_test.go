@@ -1,12 +1,18 @@
 package e3x
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/trace"
+	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/logs"
 	"github.com/telehash/gogotelehash/transports/inproc"
 	"github.com/telehash/gogotelehash/transports/mux"
@@ -66,3 +72,348 @@ func TestSimpleEndpoint(t *testing.T) {
 	err = eb.Close()
 	assert.NoError(err)
 }
+
+func TestShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	l := B.Listen("sink", true)
+	defer l.Close()
+
+	received := make(chan int, 1)
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			received <- -1
+			return
+		}
+
+		n := 0
+		for {
+			_, err := c.ReadPacket()
+			if err != nil {
+				break
+			}
+			if n == 0 {
+				c.WritePacket(lob.New([]byte("ok")))
+			}
+			n++
+		}
+		received <- n
+	}()
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := A.Open(ident, "sink", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = c.WritePacket(lob.New([]byte("hello")))
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = c.ReadPacket()
+	if !assert.NoError(err) {
+		return
+	}
+
+	for i := 1; i < 50; i++ {
+		err = c.WritePacket(lob.New([]byte("hello")))
+		if !assert.NoError(err) {
+			return
+		}
+	}
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	xB, err := B.CreateExchange(identA)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	assert.NoError(A.Shutdown(ctx))
+
+	select {
+	case n := <-received:
+		assert.Equal(50, n)
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote channel was not ended")
+	}
+
+	// the exchange of B is left to expire when it is idle
+	assert.NotEqual(ExchangeExpired, xB.State())
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	// A never reads from the channel, so it can't end it.
+	l := A.Listen("stuck", true)
+	defer l.Close()
+
+	ident, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.Open(ident, "stuck", true)
+	if !assert.NoError(err) {
+		return
+	}
+	err = c.WritePacket(lob.New([]byte("hello")))
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = l.AcceptChannel()
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = A.Shutdown(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.True(time.Since(start) < 5*time.Second)
+
+	// B's channel is broken as soon as A gives up
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.ReadPacket()
+	assert.Error(err)
+}
+
+func TestRefuseChannelWhileDraining(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	B.Mux().HandleFunc("ping", true, func(c *Channel) {
+		defer c.Close()
+		if _, err := c.ReadPacket(); err == nil {
+			c.WritePacket(lob.New([]byte("pong")))
+		}
+	})
+
+	c, err := A.Open(B.mustLocalIdentity(t), "ping", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+
+	xB := B.GetExchange(A.LocalHashname())
+	if !assert.NotNil(xB) {
+		return
+	}
+	xB.mtx.Lock()
+	xB.draining = true
+	xB.mtx.Unlock()
+
+	c, err = A.Open(B.mustLocalIdentity(t), "ping", true)
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+
+	// the opener is told right away instead of waiting for the open timeout
+	_, err = c.ReadPacket()
+	if cerr, ok := err.(*ChannelError); assert.True(ok, "err=%v", err) {
+		assert.Equal(ErrShuttingDown.Error(), cerr.Reason)
+	}
+}
+
+func TestTraceSpans(t *testing.T) {
+	assert := assert.New(t)
+
+	ring := trace.NewRing(4096)
+
+	A, err := Open(Log(nil), Trace(ring, 1), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Trace(nil, 1), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	B.Mux().HandleFunc("ping", true, func(c *Channel) {
+		defer c.Close()
+
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(pkt)
+	})
+
+	x, err := A.Dial(B.mustLocalIdentity(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := x.Open("ping", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+	assert.NoError(c.Close())
+	time.Sleep(100 * time.Millisecond)
+
+	var (
+		started = map[trace.ID]trace.Event{}
+		ended   = map[string]int{}
+	)
+	for _, e := range ring.Events() {
+		switch e.Phase {
+		case trace.PhaseStart:
+			started[e.Span] = e
+		case trace.PhaseEnd:
+			start, found := started[e.Span]
+			if assert.True(found, "span %d ended without start", e.Span) {
+				assert.Equal(start.Type, e.Type)
+			}
+			if e.Error == "" {
+				ended[e.Type]++
+			}
+
+			info := e.Info.(trace.Info)
+			assert.Equal(x.TID, info["exchange_id"], e.Type)
+			if e.Type == "channel.lifetime" {
+				assert.Equal(c.TID, info["channel_id"])
+			}
+		}
+	}
+
+	assert.Equal(1, ended["exchange.dial"])
+	assert.True(ended["exchange.handshake"] >= 1)
+	assert.Equal(1, ended["channel.lifetime"])
+}
+
+func TestTraceSamplesPackets(t *testing.T) {
+	assert := assert.New(t)
+
+	ring := trace.NewRing(4096)
+
+	// a rate this low samples none of the few IDs used by the test
+	A, err := Open(Log(nil), Trace(ring, 1e-12), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	_, err = A.Dial(B.mustLocalIdentity(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	var types = map[string]int{}
+	for _, e := range ring.Events() {
+		types[e.Type]++
+	}
+	assert.Equal(1, types["endpoint.started"])
+	assert.Equal(0, types["endpoint.rcv.packet"])
+	assert.Equal(0, types["exchange.rcv.packet"])
+}
+
+// lockedBuffer is a bytes.Buffer which can be written concurrently.
+type lockedBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func TestLogLevels(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		debugLog lockedBuffer
+		infoLog  lockedBuffer
+	)
+
+	A, err := Open(Log(&debugLog), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(
+		LogHandler(slog.NewTextHandler(&infoLog, &slog.HandlerOptions{Level: slog.LevelInfo})),
+		Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	_, err = A.Dial(B.mustLocalIdentity(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Log includes the debug records; path changes are not logged at info
+	assert.Contains(debugLog.String(), `msg="Discovered path"`)
+	assert.NotContains(infoLog.String(), "path")
+}
@@ -1,7 +1,7 @@
 package e3x
 
 import (
-	"encoding/base64"
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -20,6 +20,11 @@ import (
 
 var ErrInvalidHandshake = errors.New("e3x: invalid handshake")
 
+// errNoHandshakeResponse ends the trace span of an unanswered handshake.
+var errNoHandshakeResponse = errors.New("e3x: no handshake response")
+
+const cDefaultDialStagger = 250 * time.Millisecond
+
 type BrokenExchangeError hashname.H
 
 func (err BrokenExchangeError) Error() string {
@@ -82,18 +87,34 @@ type Exchange struct {
 	nextChannelID uint32
 	channels      *channelSet
 	addressBook   *addressBook
+	events        eventQueue
+	stats         *statCounters
+	channelStats  channelTypeCounters
+	draining      bool
 	err           error
 
 	endpoint      endpointI
 	listenerSet   *listenerSet
 	log           *logs.Logger
+	tracer        *tracer.Tracer
 	exchangeHooks ExchangeHooks
 	channelHooks  ChannelHooks
 
-	nextHandshake     int
+	dialStagger       time.Duration
+	dialAfter         func(time.Duration) <-chan time.Time
+	cloakRounds       int
+	policy            ExchangePolicy
+	quotas            Quotas
+	bufferQuota       *bufferQuota
+	mux               *ServeMux
+	router            *ServeMux
+	multipath         MultipathMode
+	cancelDialRace    chan struct{}
+	nextHandshake     time.Duration
 	tExpire           *time.Timer
 	tBreak            *time.Timer
 	tDeliverHandshake *time.Timer
+	handshakeSpan     *tracer.Span
 }
 
 type ExchangeOption func(e *Exchange) error
@@ -113,18 +134,14 @@ func newExchange(
 		localIdent:  localIdent,
 		remoteIdent: remoteIdent,
 		channels:    &channelSet{},
+		policy:      DefaultExchangePolicy,
+		stats:       &statCounters{},
 	}
-	x.traceNew()
-
 	x.cndState = sync.NewCond(&x.mtx)
 
-	x.tBreak = time.AfterFunc(2*60*time.Second, x.onBreak)
-	x.tExpire = time.AfterFunc(60*time.Second, x.onExpire)
-	x.tDeliverHandshake = time.AfterFunc(60*time.Second, x.onDeliverHandshake)
-	x.resetExpire()
-	x.rescheduleHandshake()
-
 	x.setOptions(options...)
+	x.traceNew()
+
 	x.channelHooks.Register(ChannelHook{OnClosed: x.unregisterChannel})
 
 	if localIdent == nil {
@@ -145,7 +162,7 @@ func newExchange(
 			return nil, x.traceError(err)
 		}
 
-		x.addressBook = newAddressBook(x.log)
+		x.addressBook = newAddressBook(x.log, x.policy.PathExpiry, x)
 		x.cipher = cipher
 		x.csid = csid
 
@@ -175,9 +192,16 @@ func newExchange(
 		x.log = log.To(hn)
 		x.cipher = cipher
 		x.csid = csid
-		x.addressBook = newAddressBook(x.log)
+		x.addressBook = newAddressBook(x.log, x.policy.PathExpiry, x)
 	}
 
+	// the timers are started last as they use the cipher and the address book
+	x.tBreak = time.AfterFunc(x.policy.BreakTimeout, x.onBreak)
+	x.tExpire = time.AfterFunc(x.policy.DialTimeout, x.onExpire)
+	x.tDeliverHandshake = time.AfterFunc(x.policy.KeepaliveInterval, x.onDeliverHandshake)
+	x.resetExpire()
+	x.rescheduleHandshake()
+
 	return x, nil
 }
 
@@ -194,6 +218,17 @@ func registerEndpoint(e *Endpoint) ExchangeOption {
 	return func(x *Exchange) error {
 		x.endpoint = e
 		x.listenerSet = e.listenerSet.Inherit()
+		x.dialStagger = e.dialStagger
+		x.dialAfter = e.dialAfter
+		x.cloakRounds = e.cloakRounds
+		x.policy = e.exchangePolicy
+		x.quotas = e.quotas
+		x.bufferQuota = newBufferQuota(e.quotas.MaxBufferedBytes)
+		x.mux = e.mux
+		x.router = e.router
+		x.multipath = e.multipath
+		x.tracer = e.tracer
+		x.stats.parent = e.stats
 		x.exchangeHooks = e.exchangeHooks
 		x.channelHooks = e.channelHooks
 		x.exchangeHooks.exchange = x
@@ -217,9 +252,16 @@ func (x *Exchange) getTID() tracer.ID {
 	return x.TID
 }
 
+func (x *Exchange) getEndpointTID() tracer.ID {
+	if x.endpoint == nil {
+		return 0
+	}
+	return x.endpoint.getTID()
+}
+
 func (x *Exchange) traceError(err error) error {
-	if tracer.Enabled && err != nil {
-		tracer.Emit("exchange.error", tracer.Info{
+	if x.tracer.Sampled(x.TID) && err != nil {
+		x.tracer.Emit("exchange.error", x.TID, tracer.Info{
 			"exchange_id": x.TID,
 			"error":       err.Error(),
 		})
@@ -228,17 +270,17 @@ func (x *Exchange) traceError(err error) error {
 }
 
 func (x *Exchange) traceNew() {
-	if tracer.Enabled {
-		tracer.Emit("exchange.new", tracer.Info{
+	if x.tracer.Sampled(x.TID) {
+		x.tracer.Emit("exchange.new", x.TID, tracer.Info{
 			"exchange_id": x.TID,
-			"endpoint_id": x.endpoint.getTID(),
+			"endpoint_id": x.getEndpointTID(),
 		})
 	}
 }
 
 func (x *Exchange) traceStarted() {
-	if tracer.Enabled {
-		tracer.Emit("exchange.started", tracer.Info{
+	if x.tracer.Sampled(x.TID) {
+		x.tracer.Emit("exchange.started", x.TID, tracer.Info{
 			"exchange_id": x.TID,
 			"peer":        x.remoteIdent.Hashname().String(),
 		})
@@ -246,15 +288,15 @@ func (x *Exchange) traceStarted() {
 }
 
 func (x *Exchange) traceStopped() {
-	if tracer.Enabled {
-		tracer.Emit("exchange.stopped", tracer.Info{
+	if x.tracer.Sampled(x.TID) {
+		x.tracer.Emit("exchange.stopped", x.TID, tracer.Info{
 			"exchange_id": x.TID,
 		})
 	}
 }
 
 func (x *Exchange) traceDroppedHandshake(msg message, handshake cipherset.Handshake, reason string) {
-	if tracer.Enabled {
+	if x.tracer.Sampled(x.TID) {
 		info := tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
@@ -270,13 +312,30 @@ func (x *Exchange) traceDroppedHandshake(msg message, handshake cipherset.Handsh
 			}
 		}
 
-		tracer.Emit("exchange.drop.handshake", info)
+		x.tracer.Emit("exchange.drop.handshake", x.TID, info)
 	}
 }
 
+// traceHandshakeSent starts the span of a handshake round. A round which
+// didn't get a response before the next round started is ended with
+// errNoHandshakeResponse. x.mtx must be held.
+func (x *Exchange) traceHandshakeSent() {
+	x.handshakeSpan.End(errNoHandshakeResponse)
+	x.handshakeSpan = x.tracer.Start("exchange.handshake", x.TID, tracer.Info{
+		"exchange_id": x.TID,
+	})
+}
+
+// traceHandshakeAnswered ends the span of the current handshake round.
+// x.mtx must be held.
+func (x *Exchange) traceHandshakeAnswered(err error) {
+	x.handshakeSpan.End(err)
+	x.handshakeSpan = nil
+}
+
 func (x *Exchange) traceReceivedHandshake(msg message, handshake cipherset.Handshake) {
-	if tracer.Enabled {
-		tracer.Emit("exchange.rcv.handshake", tracer.Info{
+	if x.tracer.Sampled(x.TID) {
+		x.tracer.Emit("exchange.rcv.handshake", x.TID, tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
 			"handshake": tracer.Info{
@@ -290,7 +349,7 @@ func (x *Exchange) traceReceivedHandshake(msg message, handshake cipherset.Hands
 }
 
 func (x *Exchange) traceDroppedPacket(msg message, pkt *lob.Packet, reason string) {
-	if tracer.Enabled {
+	if x.tracer.Sampled(x.TID) {
 		info := tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
@@ -298,36 +357,38 @@ func (x *Exchange) traceDroppedPacket(msg message, pkt *lob.Packet, reason strin
 		}
 
 		if pkt != nil {
-			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
-				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
-			}
+			info["packet"] = tracePacket(pkt)
 		}
 
-		tracer.Emit("exchange.rcv.packet", info)
+		x.tracer.Emit("exchange.drop.packet", x.TID, info)
 	}
 }
 
 func (x *Exchange) traceReceivedPacket(msg message, pkt *lob.Packet) {
-	if tracer.Enabled {
-		tracer.Emit("exchange.rcv.packet", tracer.Info{
+	if x.tracer.Sampled(x.TID) {
+		x.tracer.Emit("exchange.rcv.packet", x.TID, tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
-			"packet": tracer.Info{
-				"header": pkt.Header(),
-				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
-			},
+			"packet":      tracePacket(pkt),
 		})
 	}
 }
 
-// Dial exchanges the initial handshakes. It will timeout after 2 minutes.
+// Dial exchanges the initial handshakes. It will timeout after the
+// DialTimeout of the exchange policy.
 func (x *Exchange) Dial() error {
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
+	var span *tracer.Span
+
 	if x.state == 0 {
-		x.state = ExchangeDialing
+		span = x.tracer.Start("exchange.dial", x.TID, tracer.Info{
+			"exchange_id": x.TID,
+			"peer":        x.remoteIdent.Hashname().String(),
+		})
+
+		x.setState(ExchangeDialing)
 		x.deliverHandshake()
 		x.rescheduleHandshake()
 	}
@@ -337,12 +398,20 @@ func (x *Exchange) Dial() error {
 	}
 
 	if !x.state.IsOpen() {
-		return BrokenExchangeError(x.remoteIdent.Hashname())
+		err := BrokenExchangeError(x.remoteIdent.Hashname())
+		span.End(err)
+		return err
 	}
 
+	span.End(nil)
 	return nil
 }
 
+// Channels returns the open channels of the exchange.
+func (x *Exchange) Channels() []*Channel {
+	return x.channels.All()
+}
+
 // RemoteHashname returns the hashname of the remote peer.
 func (x *Exchange) RemoteHashname() hashname.H {
 	hn := x.remoteIdent.Hashname()
@@ -359,7 +428,11 @@ func (x *Exchange) RemoteIdentity() *Identity {
 
 // ActivePath returns the path that is currently used for channel packets.
 func (x *Exchange) ActivePath() net.Addr {
-	return x.addressBook.ActiveConnection().RemoteAddr()
+	p := x.addressBook.ActiveConnection()
+	if p == nil {
+		return nil
+	}
+	return p.RemoteAddr()
 }
 
 // ActivePipe returns the pipe that is currently used for channel packets.
@@ -381,7 +454,17 @@ func (x *Exchange) dialDialerAddr(addr dialerAddr) (net.Conn, error) {
 	return addr.Dial(x.endpoint.(*Endpoint), x)
 }
 
+func (x *Exchange) cloaking() int {
+	return x.cloakRounds
+}
+
 func (x *Exchange) received(msg message) {
+	if err := msg.uncloak(); err != nil {
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
+		msg.Data.Free()
+		return // drop
+	}
+
 	if msg.IsHandshake {
 		x.receivedHandshake(msg)
 	} else {
@@ -412,7 +495,17 @@ func (x *Exchange) deliverHandshake() error {
 		return err
 	}
 
-	for _, pipe := range x.addressBook.HandshakePipes() {
+	pipes := x.addressBook.HandshakePipes()
+	x.traceHandshakeSent()
+
+	if x.state == ExchangeDialing && x.dialStagger > 0 && len(pipes) > 1 {
+		x.stopDialRace()
+		x.cancelDialRace = make(chan struct{})
+		go x.raceHandshake(pktData, pipes, x.cancelDialRace)
+		return nil
+	}
+
+	for _, pipe := range pipes {
 		_, err := pipe.Write(pktData)
 		if err == nil {
 			x.addressBook.SentHandshake(pipe)
@@ -422,22 +515,139 @@ func (x *Exchange) deliverHandshake() error {
 	return nil
 }
 
+// pathFeedback is called by the reliable channels. A loss (a resend or a miss
+// list) makes the exchange probe the backup paths. An ack clears the suspicion
+// of the active path.
+func (x *Exchange) pathFeedback(loss bool) {
+	if !loss {
+		x.addressBook.ReportAck()
+		return
+	}
+
+	probe, failover := x.addressBook.ReportLoss()
+	if failover {
+		// called while a channel is locked
+		go x.resendChannels()
+	}
+	if probe {
+		go x.probePaths()
+	}
+}
+
+// resendTimeout returns the time a reliable channel waits for an ack before
+// it resends its last packet. It is derived from the latency of the active
+// path.
+func (x *Exchange) resendTimeout() time.Duration {
+	rto := 2 * x.addressBook.ActiveLatency()
+	if rto <= 0 || rto > cMaxResendTimeout {
+		return cMaxResendTimeout
+	}
+	if rto < cMinResendTimeout {
+		return cMinResendTimeout
+	}
+	return rto
+}
+
+// probePaths sends a handshake over all the backup paths without starting a
+// new handshake epoch.
+func (x *Exchange) probePaths() {
+	x.mtx.Lock()
+	if !x.state.IsOpen() {
+		x.mtx.Unlock()
+		return
+	}
+	pktData, err := x.generateHandshake(0)
+	x.mtx.Unlock()
+	if err != nil {
+		return
+	}
+
+	for _, pipe := range x.addressBook.HandshakePipes() {
+		_, err := pipe.Write(pktData)
+		if err == nil {
+			x.addressBook.SentHandshake(pipe)
+		}
+	}
+
+	pktData.Free()
+}
+
+// resendChannels resends the last unacknowledged packet of all the channels over
+// the (new) active path.
+func (x *Exchange) resendChannels() {
+	for _, c := range x.channels.All() {
+		c.resendNow()
+	}
+}
+
+// raceHandshake sends the handshake to pipes (ordered by latency) with
+// staggered starts. The next pipe is tried immediately when a write fails.
+// The remaining pipes are skipped when cancel is closed.
+func (x *Exchange) raceHandshake(pktData *bufpool.Buffer, pipes []*Pipe, cancel <-chan struct{}) {
+	for _, pipe := range pipes {
+		select {
+		case <-cancel:
+			return
+		default:
+		}
+
+		failed := make(chan struct{})
+		go func(pipe *Pipe) {
+			_, err := pipe.Write(pktData)
+			if err == nil {
+				x.addressBook.SentHandshake(pipe)
+			} else {
+				close(failed)
+			}
+		}(pipe)
+
+		wait, stop := x.staggerTimer()
+		select {
+		case <-cancel:
+			stop()
+			return
+		case <-failed:
+			stop()
+		case <-wait:
+		}
+	}
+}
+
+// staggerTimer starts the delay before the next pipe of the dial race is
+// tried. The returned func stops the timer.
+func (x *Exchange) staggerTimer() (<-chan time.Time, func() bool) {
+	if x.dialAfter != nil {
+		return x.dialAfter(x.dialStagger), func() bool { return false }
+	}
+	timer := time.NewTimer(x.dialStagger)
+	return timer.C, timer.Stop
+}
+
+// stopDialRace cancels the pending handshakes of the dial race.
+// x.mtx must be held.
+func (x *Exchange) stopDialRace() {
+	if x.cancelDialRace != nil {
+		close(x.cancelDialRace)
+		x.cancelDialRace = nil
+	}
+}
+
 func (x *Exchange) rescheduleHandshake() {
 	if x.nextHandshake <= 0 {
-		x.nextHandshake = 4
+		x.nextHandshake = x.policy.HandshakeRetry
 	} else {
 		x.nextHandshake = x.nextHandshake * 2
 	}
 
-	if x.nextHandshake > 60 {
-		x.nextHandshake = 60
+	if x.nextHandshake > x.policy.KeepaliveInterval {
+		x.nextHandshake = x.policy.KeepaliveInterval
 	}
 
 	if n := x.nextHandshake / 3; n > 0 {
-		x.nextHandshake -= rand.Intn(n)
+		x.nextHandshake -= time.Duration(rand.Int63n(int64(n)))
 	}
 
-	var d = time.Duration(x.nextHandshake) * time.Second
+	var d = x.nextHandshake
 	x.tDeliverHandshake.Reset(d)
 }
 
@@ -448,6 +658,7 @@ func (x *Exchange) receivedPacket(msg message) {
 		dropMissingChannelID      = "missing channel id header"
 		dropMissingChannelType    = "missing channel type header"
 		dropMissingChannelHandler = "missing channel handler"
+		dropExchangeIsDraining    = "exchange is draining"
 	)
 
 	{
@@ -477,6 +688,8 @@ func (x *Exchange) receivedPacket(msg message) {
 		return // drop
 	}
 	pkt2.TID = msg.TID
+	x.exchangeHooks.ReceivePacket(pkt2, msg.Pipe)
+
 	var (
 		hdr          = pkt2.Header()
 		cid, hasC    = hdr.C, hdr.HasC
@@ -503,14 +716,43 @@ func (x *Exchange) receivedPacket(msg message) {
 				return // drop (missing typ)
 			}
 
-			listener := x.listenerSet.Get(typ)
+			x.mtx.Lock()
+			draining := x.draining
+			x.mtx.Unlock()
+			if draining {
+				addPromise.Cancel()
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrShuttingDown)
+				x.traceDroppedPacket(msg, pkt2, dropExchangeIsDraining)
+				x.deliverChannelError(cid, ErrShuttingDown)
+				return // drop (draining)
+			}
+
+			var (
+				listener = x.listenerSet.Get(typ)
+				handler  Handler
+				reliable bool
+			)
 			if listener == nil {
+				handler, reliable = x.mux.Handler(typ)
+			}
+			if listener == nil && handler == nil {
+				handler, reliable = x.router.Handler(typ)
+			}
+			if listener == nil && (handler == nil || reliable != hasSeq) {
 				addPromise.Cancel()
 				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
 				x.traceDroppedPacket(msg, pkt2, dropMissingChannelHandler)
 				return // drop (no handler)
 			}
 
+			if err := x.quotas.checkChannel(typ, addPromise.Count); err != nil {
+				addPromise.Cancel()
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
+				x.traceDroppedPacket(msg, pkt2, err.Error())
+				x.deliverChannelError(cid, err)
+				return // drop (quota)
+			}
+
 			c = newChannel(
 				x.remoteIdent.Hashname(),
 				typ,
@@ -518,6 +760,7 @@ func (x *Exchange) receivedPacket(msg message) {
 				true,
 				x,
 				registerExchange(x),
+				acceptModes(hdr),
 			)
 			c.id = cid
 			addPromise.Add(c)
@@ -526,15 +769,20 @@ func (x *Exchange) receivedPacket(msg message) {
 			x.resetExpire()
 			x.mtx.Unlock()
 
-			x.log.Printf("\x1B[32mOpened channel\x1B[0m %q %d", typ, cid)
+			c.traceNew()
+			x.log.Debug("Opened channel", "type", typ, "channel", cid)
 			c.channelHooks.Opened()
 
-			listener.handle(c)
+			if listener != nil {
+				listener.handle(c)
+			} else {
+				go handler.ServeChannel(c)
+			}
 		}
 	}
 
 	x.traceReceivedPacket(msg, pkt2)
-	c.receivedPacket(pkt2)
+	c.receivedPacket(pkt2, msg.Pipe)
 }
 
 func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
@@ -543,14 +791,20 @@ func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
 		x.cndState.Wait()
 	}
 	if !x.state.IsOpen() {
+		x.mtx.Unlock()
 		return BrokenExchangeError(x.remoteIdent.Hashname())
 	}
+	mode := x.multipath
 	x.mtx.Unlock()
 
+	var pipes = []*Pipe{p}
 	if p == nil {
-		p = x.addressBook.ActiveConnection()
+		pipes = x.selectPipes(mode, pkt)
+		p = pipes[0]
 	}
 
+	x.exchangeHooks.SendPacket(pkt, p)
+
 	pkt2, err := x.cipher.EncryptPacket(pkt)
 	if err != nil {
 		return err
@@ -562,7 +816,12 @@ func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
 		return err
 	}
 
-	_, err = p.Write(msg)
+	for i, p := range pipes {
+		_, perr := p.Write(msg)
+		if i == 0 || err != nil {
+			err = perr
+		}
+	}
 	msg.Free()
 
 	return err
@@ -576,18 +835,20 @@ func (x *Exchange) expire(err error) {
 	}
 
 	if err == nil {
-		x.state = ExchangeExpired
+		x.setState(ExchangeExpired)
 	} else {
 		if x.err != nil {
 			x.err = err
 		}
-		x.state = ExchangeBroken
+		x.setState(ExchangeBroken)
 	}
 	x.cndState.Broadcast()
+	x.stopDialRace()
 
 	x.tBreak.Stop()
 	x.tExpire.Stop()
 	x.tDeliverHandshake.Stop()
+	x.traceHandshakeAnswered(errNoHandshakeResponse)
 
 	x.mtx.Unlock()
 
@@ -664,16 +925,16 @@ func (x *Exchange) resetExpire() {
 		x.tExpire.Stop()
 	} else {
 		if x.state.IsOpen() {
-			x.tExpire.Reset(2 * 60 * time.Second)
+			x.tExpire.Reset(x.policy.IdleExpiry)
 		}
 	}
 
 	if x.state.IsOpen() {
 		old := x.state
 		if active {
-			x.state = ExchangeActive
+			x.setState(ExchangeActive)
 		} else {
-			x.state = ExchangeIdle
+			x.setState(ExchangeIdle)
 		}
 		if x.state != old {
 			x.cndState.Broadcast()
@@ -682,16 +943,19 @@ func (x *Exchange) resetExpire() {
 }
 
 func (x *Exchange) resetBreak() {
-	x.tBreak.Reset(2 * 60 * time.Second)
+	x.tBreak.Reset(x.policy.BreakTimeout)
 }
 
 func (x *Exchange) unregisterChannel(_ *Endpoint, _ *Exchange, c *Channel) error {
+	c.releaseBuffered()
+
 	if x.channels.Remove(c.id) {
 		x.mtx.Lock()
 		x.resetExpire()
 		x.mtx.Unlock()
 
-		x.log.Printf("\x1B[31mClosed channel\x1B[0m %q %d", c.typ, c.id)
+		x.log.Debug("Closed channel", "type", c.typ, "channel", c.id)
+		c.traceClosed()
 	}
 
 	return nil
@@ -729,8 +993,9 @@ func (x *Exchange) waitDone() {
 	x.mtx.Unlock()
 }
 
-// Open a channel.
-func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
+// Open a channel. The options select the mode of reliable channels (see
+// Unordered and PartiallyReliable).
+func (x *Exchange) Open(typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
 	var (
 		c *Channel
 	)
@@ -743,14 +1008,27 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 		x,
 		registerExchange(x),
 	)
+	if err := c.setOptions(options...); err != nil {
+		c.unsetTimers()
+		return nil, err
+	}
 
 	x.mtx.Lock()
 	for x.state == ExchangeDialing {
 		x.cndState.Wait()
 	}
+	var err error
 	if !x.state.IsOpen() {
+		err = BrokenExchangeError(x.remoteIdent.Hashname())
+	} else if x.draining {
+		err = ErrShuttingDown
+	} else {
+		err = x.quotas.checkChannel(typ, x.channels.Count)
+	}
+	if err != nil {
 		x.mtx.Unlock()
-		return nil, BrokenExchangeError(x.remoteIdent.Hashname())
+		c.unsetTimers()
+		return nil, err
 	}
 
 	c.id = x.getNextChannelID()
@@ -758,11 +1036,55 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 	x.resetExpire()
 	x.mtx.Unlock()
 
-	x.log.Printf("\x1B[32mOpened channel\x1B[0m %q %d", typ, c.id)
+	c.traceNew()
+	x.log.Debug("Opened channel", "type", typ, "channel", c.id)
 	c.channelHooks.Opened()
 	return c, nil
 }
 
+// shutdown stops the exchange from accepting new channels and lets the open
+// channels flush their write buffers and deliver their end packets. When ctx
+// is done before all channels are flushed the remaining channels are closed
+// with an err packet, the exchange is broken and ctx.Err() is returned.
+func (x *Exchange) shutdown(ctx context.Context) error {
+	x.mtx.Lock()
+	x.draining = true
+	x.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range x.channels.All() {
+		wg.Add(1)
+		go func(c *Channel) {
+			defer wg.Done()
+			c.flush(ctx)
+		}(c)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		// tell the remote endpoint about the channels that were not flushed
+		for _, c := range x.channels.All() {
+			x.deliverChannelError(c.id, ErrShuttingDown)
+		}
+		x.onBreak()
+		return err
+	}
+
+	x.onExpire()
+	return nil
+}
+
+// deliverChannelError tells the opener of channel id why it was refused.
+func (x *Exchange) deliverChannelError(id uint32, err error) {
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.C, hdr.HasC = id, true
+	hdr.SetString("err", err.Error())
+
+	x.deliverPacket(pkt, nil)
+	pkt.Free()
+}
+
 // LocalToken returns the token identifying the local side of the exchange.
 func (x *Exchange) LocalToken() cipherset.Token {
 	return x.cipher.LocalToken()
@@ -785,6 +1107,33 @@ func (x *Exchange) AddPathCandidate(addr net.Addr) {
 	}
 }
 
+// ProbePath sends a handshake to addr. Unlike AddPathCandidate the path is
+// only used after the remote endpoint responded over it. This is used to
+// establish direct paths through NATs.
+func (x *Exchange) ProbePath(addr net.Addr) error {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	p := x.addressBook.PipeToAddr(addr)
+	if p == nil {
+		p = newPipe(x.endpoint.getTransport(), nil, addr, x)
+		x.addressBook.AddProbe(p)
+	}
+
+	pktData, err := x.generateHandshake(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.Write(pktData)
+	if err != nil {
+		return err
+	}
+
+	x.addressBook.SentHandshake(p)
+	return nil
+}
+
 // GenerateHandshake can be used to generate a new handshake packet.
 // This is useful when the exchange doesn't know where to send the handshakes yet.
 func (x *Exchange) GenerateHandshake() (*bufpool.Buffer, error) {
@@ -817,7 +1166,8 @@ func (x *Exchange) generateHandshake(seq uint32) (*bufpool.Buffer, error) {
 		return nil, err
 	}
 
-	if x.lastLocalSeq < seq {
+	// responses reuse the remote seq
+	if x.isLocalSeq(seq) && x.lastLocalSeq < seq {
 		x.lastLocalSeq = seq
 	}
 
@@ -863,8 +1213,10 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 	}
 
 	seq = handshake.At()
-	if seq < x.lastRemoteSeq {
+	if seq < x.lastRemoteSeq && seq != x.lastLocalSeq {
 		// drop; a newer packet has already been processed
+		// (the answer to our latest handshake is accepted as both peers might
+		// have probed their paths at the same time)
 		return nil, false
 	}
 
@@ -892,11 +1244,16 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 	}
 
 	if x.isLocalSeq(seq) {
+		x.traceHandshakeAnswered(nil)
 		x.resetBreak()
-		x.addressBook.ReceivedHandshake(pipe)
+		if x.addressBook.ReceivedHandshake(pipe) {
+			go x.resendChannels()
+		}
+		x.addressBook.Verified(pipe)
 
 	} else {
 		x.addressBook.AddPipe(pipe)
+		x.addressBook.Verified(pipe)
 
 		response, err = x.generateHandshake(seq)
 		if err != nil {
@@ -908,7 +1265,8 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 	if x.state == ExchangeDialing || x.state == ExchangeInitialising {
 		x.traceStarted()
 
-		x.state = ExchangeIdle
+		x.setState(ExchangeIdle)
+		x.stopDialRace()
 		x.resetExpire()
 		x.cndState.Broadcast()
 
@@ -965,12 +1323,16 @@ func (x *Exchange) receivedHandshake(msg message) bool {
 		return false
 	}
 
-	x.lastRemoteSeq = handshake.At()
+	if x.lastRemoteSeq < handshake.At() {
+		x.lastRemoteSeq = handshake.At()
+	}
 
 	if resp != nil {
 		msg.Pipe.Write(resp)
 	}
 
+	x.handshakeCompleted(msg.Pipe)
+
 	x.traceReceivedHandshake(msg, handshake)
 	return true
 }
@@ -1,6 +1,7 @@
 package e3x
 
 import (
+	"math/rand"
 	"net"
 	"sort"
 	"sync"
@@ -13,15 +14,31 @@ import (
 const (
 	cMaxAddressBookEntries = 16
 	cNumBackupAddresses    = 3
+
+	// cFailoverLosses is the number of loss signals after which the active path
+	// is replaced by a backup path.
+	cFailoverLosses = 2
+
+	// cMinResendTimeout and cMaxResendTimeout bound the resend timeout of the
+	// reliable channels (twice the EWMA latency of the active path).
+	cMinResendTimeout = 20 * time.Millisecond
+	cMaxResendTimeout = 1 * time.Second
+
+	// cMinProbeInterval is the minimum interval between two probes of the
+	// backup paths.
+	cMinProbeInterval = 100 * time.Millisecond
 )
 
 type addressBook struct {
-	log *logs.Logger
+	log        *logs.Logger
+	pathExpiry time.Duration
+	observer   pathObserver
 
 	mtx         sync.RWMutex
 	active      *addressBookEntry
 	known       []*addressBookEntry
 	unsupported []string
+	lastProbe   time.Time
 }
 
 const (
@@ -37,13 +54,16 @@ type addressBookEntry struct {
 	ExpireAt            time.Time
 	Reachable           bool
 	IsBackup            bool
+	Verified            bool
+	Relayed             bool
 
 	latency time.Duration
 	ewma    time.Duration
+	losses  int // loss signals since the last ack
 }
 
-func newAddressBook(log *logs.Logger) *addressBook {
-	return &addressBook{log: log.Module("addrbook")}
+func newAddressBook(log *logs.Logger, pathExpiry time.Duration, observer pathObserver) *addressBook {
+	return &addressBook{log: log.Module("addrbook"), pathExpiry: pathExpiry, observer: observer}
 }
 
 func (book *addressBook) ActiveConnection() *Pipe {
@@ -58,6 +78,17 @@ func (book *addressBook) ActiveConnection() *Pipe {
 	return e.Pipe
 }
 
+// ActiveLatency returns the EWMA latency of the active path.
+func (book *addressBook) ActiveLatency() time.Duration {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	if book.active == nil {
+		return 0
+	}
+	return book.active.ewma
+}
+
 func (book *addressBook) KnownAddresses() []net.Addr {
 	book.mtx.RLock()
 	defer book.mtx.RUnlock()
@@ -97,6 +128,80 @@ func (book *addressBook) HandshakePipes() []*Pipe {
 	return s
 }
 
+// BestPipes returns up to n reachable backup pipes. The active pipe is always
+// the first.
+func (book *addressBook) BestPipes(n int) []*Pipe {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	if book.active == nil || n <= 0 {
+		return nil
+	}
+
+	s := make([]*Pipe, 1, n)
+	s[0] = book.active.Pipe
+	for _, e := range book.known {
+		if len(s) == n {
+			break
+		}
+		if e == book.active || !e.IsBackup || !e.Reachable {
+			continue
+		}
+		s = append(s, e.Pipe)
+	}
+
+	return s
+}
+
+// StripePipe picks one of the reachable backup pipes at random. The chance a
+// pipe is picked is inversely proportional to its EWMA latency.
+func (book *addressBook) StripePipe() *Pipe {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	var (
+		total   float64
+		weights [cNumBackupAddresses]float64
+		entries [cNumBackupAddresses]*addressBookEntry
+		n       int
+	)
+
+	for _, e := range book.known {
+		if n == len(entries) {
+			break
+		}
+		if !e.IsBackup || !e.Reachable {
+			continue
+		}
+
+		ewma := e.ewma
+		if ewma < time.Millisecond {
+			ewma = time.Millisecond
+		}
+
+		entries[n] = e
+		weights[n] = 1 / ewma.Seconds()
+		total += weights[n]
+		n++
+	}
+
+	if n == 0 {
+		if book.active == nil {
+			return nil
+		}
+		return book.active.Pipe
+	}
+
+	r := rand.Float64() * total
+	for i := 0; i < n; i++ {
+		r -= weights[i]
+		if r < 0 {
+			return entries[i].Pipe
+		}
+	}
+	return entries[n-1].Pipe
+}
+
 func (book *addressBook) NextHandshakeEpoch() {
 	book.mtx.Lock()
 	defer book.mtx.Unlock()
@@ -117,22 +222,30 @@ func (book *addressBook) NextHandshakeEpoch() {
 			if !e.ReceivedHandshakeAt.IsZero() {
 				// successful handshake: update latency
 				e.AddLatencySample(e.ReceivedHandshakeAt.Sub(e.SendHandshakeAt))
-				e.ExpireAt = e.ReceivedHandshakeAt.Add(2 * time.Minute)
+				e.ExpireAt = e.ReceivedHandshakeAt.Add(book.pathExpiry)
+				if !e.Reachable {
+					book.notifyAdded(e)
+				}
 				e.Reachable = true
-				book.log.Printf("\x1B[34mUpdated path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
+				e.Verified = true
+				book.log.Debug("Updated path", "path", e.String(), "latency", e.latency, "ewma", e.ewma)
 
 			} else {
 				// no response
 				if e.ExpireAt.Before(now) {
 					// reached deadline
+					if e.Reachable {
+						book.notifyLost(e)
+					}
 					e.Reachable = false
+					e.Verified = false
 					e.latency = 125 * time.Millisecond
 					e.ewma = 125 * time.Millisecond
-					book.log.Printf("\x1B[31mDetected broken path\x1B[0m %s", e)
+					book.log.Warn("Detected broken path", "path", e.String())
 
 				} else {
 					e.AddLatencySample(now.Sub(e.SendHandshakeAt))
-					book.log.Printf("\x1B[34mUpdated path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
+					book.log.Debug("Updated path", "path", e.String(), "latency", e.latency, "ewma", e.ewma)
 
 				}
 			}
@@ -160,7 +273,8 @@ func (book *addressBook) NextHandshakeEpoch() {
 		book.active = nil
 	}
 	if book.active != oldActive {
-		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", oldActive, book.active)
+		book.log.Debug("Changed path", "from_path", oldActive.String(), "to_path", book.active.String())
+		book.notifyChanged(oldActive, book.active)
 	}
 
 	// update fallbacks
@@ -196,6 +310,11 @@ func (book *addressBook) AddPipe(p *Pipe) {
 	book.mtx.Lock()
 	defer book.mtx.Unlock()
 
+	book.addPipe(p)
+}
+
+// addPipe adds p to the book. book.mtx must be held.
+func (book *addressBook) addPipe(p *Pipe) {
 	var (
 		now = time.Now()
 		idx = book.indexOfPipe(p)
@@ -206,19 +325,61 @@ func (book *addressBook) AddPipe(p *Pipe) {
 		return
 	}
 
-	e = &addressBookEntry{Address: p.raddr, Pipe: p}
-	e.Added = now
-	e.ExpireAt = now.Add(2 * time.Minute)
+	e = newAddressBookEntry(p, now, book.pathExpiry)
 	e.Reachable = true
 	e.IsBackup = true
-	e.InitSamples()
 
 	book.known = append(book.known, e)
-	book.log.Printf("\x1B[32mDiscovered path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
+	book.log.Debug("Discovered path", "path", e.String(), "latency", e.latency, "ewma", e.ewma)
+	book.notifyAdded(e)
 
 	if book.active == nil {
 		book.active = e
-		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", (*addressBookEntry)(nil), book.active)
+		book.log.Debug("Changed path", "to_path", book.active.String())
+		book.notifyChanged(nil, e)
+	}
+}
+
+// AddProbe adds a pipe which is not yet known to be reachable. The pipe is only
+// used once a handshake was received over it.
+func (book *addressBook) AddProbe(p *Pipe) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	if book.indexOfPipe(p) >= 0 {
+		return
+	}
+
+	e := newAddressBookEntry(p, time.Now(), book.pathExpiry)
+	book.known = append(book.known, e)
+	book.log.Debug("Probing path", "path", e.String())
+}
+
+// Verified marks the path of p as working. A verified direct path immediately
+// replaces a relayed active path.
+func (book *addressBook) Verified(p *Pipe) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	idx := book.indexOfPipe(p)
+	if idx < 0 {
+		return
+	}
+
+	e := book.known[idx]
+	e.Verified = true
+	if !e.Reachable {
+		e.Reachable = true
+		e.IsBackup = true
+		e.ExpireAt = time.Now().Add(book.pathExpiry)
+		book.notifyAdded(e)
+	}
+
+	var oldActive = book.active
+	if oldActive == nil || (oldActive.Relayed && !e.Relayed) {
+		book.active = e
+		book.log.Debug("Changed path", "from_path", oldActive.String(), "to_path", book.active.String())
+		book.notifyChanged(oldActive, e)
 	}
 }
 
@@ -238,7 +399,8 @@ func (book *addressBook) SentHandshake(pipe *Pipe) {
 	e.SendHandshakeAt = time.Now()
 }
 
-func (book *addressBook) ReceivedHandshake(p *Pipe) {
+// ReceivedHandshake returns true when the active path was replaced by p.
+func (book *addressBook) ReceivedHandshake(p *Pipe) (failover bool) {
 	book.mtx.Lock()
 	defer book.mtx.Unlock()
 
@@ -248,7 +410,7 @@ func (book *addressBook) ReceivedHandshake(p *Pipe) {
 	)
 
 	if idx < 0 {
-		book.AddPipe(p)
+		book.addPipe(p)
 		return
 	}
 
@@ -256,6 +418,101 @@ func (book *addressBook) ReceivedHandshake(p *Pipe) {
 	if !e.SendHandshakeAt.IsZero() {
 		e.ReceivedHandshakeAt = time.Now()
 	}
+
+	// a handshake received over the active path doesn't clear its losses as
+	// it doesn't prove that packets sent over that path arrive.
+	if e != book.active && book.active != nil && book.active.losses > 0 && e.Reachable {
+		// the first backup to answer a probe replaces the suspect active path
+		failover = book.failover(e)
+	}
+
+	return failover
+}
+
+// ReportLoss records a loss signal (a resend or a miss list) for the active
+// path. After cFailoverLosses signals the best backup path replaces the active
+// path. ReportLoss returns probe=true when the backup paths must be probed and
+// failover=true when the active path was replaced.
+func (book *addressBook) ReportLoss() (probe, failover bool) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	if book.active == nil {
+		return false, false
+	}
+
+	book.active.losses++
+	if book.active.losses >= cFailoverLosses {
+		failover = book.failover(nil)
+	}
+
+	if now := time.Now(); now.Sub(book.lastProbe) >= cMinProbeInterval {
+		book.lastProbe = now
+		probe = true
+	}
+
+	return probe, failover
+}
+
+// ReportAck records a successful delivery over the active path.
+func (book *addressBook) ReportAck() {
+	book.mtx.RLock()
+	suspect := book.active != nil && book.active.losses > 0
+	book.mtx.RUnlock()
+
+	if !suspect {
+		return
+	}
+
+	book.mtx.Lock()
+	if book.active != nil {
+		book.active.losses = 0
+	}
+	book.mtx.Unlock()
+}
+
+// failover replaces the active path with to. When to is nil the first
+// reachable path (other than the active path) is used. The old path is
+// marked as broken at the next handshake epoch unless it answers a handshake.
+func (book *addressBook) failover(to *addressBookEntry) bool {
+	old := book.active
+
+	if to == nil {
+		for _, e := range book.known {
+			if e != old && e.Reachable {
+				to = e
+				break
+			}
+		}
+	}
+	if to == nil {
+		return false
+	}
+
+	old.losses = 0
+	old.ExpireAt = time.Now()
+	book.active = to
+	book.log.Warn("Failover path", "from_path", old.String(), "to_path", to.String())
+	book.notifyChanged(old, to)
+	return true
+}
+
+func (book *addressBook) notifyAdded(e *addressBookEntry) {
+	if book.observer != nil {
+		book.observer.pathAdded(e.Pipe)
+	}
+}
+
+func (book *addressBook) notifyLost(e *addressBookEntry) {
+	if book.observer != nil {
+		book.observer.pathLost(e.Pipe)
+	}
+}
+
+func (book *addressBook) notifyChanged(from, to *addressBookEntry) {
+	if book.observer != nil {
+		book.observer.pathChanged(from.pipe(), to.pipe())
+	}
 }
 
 func (book *addressBook) indexOf(addr net.Addr) int {
@@ -276,6 +533,17 @@ func (book *addressBook) indexOfPipe(pipe *Pipe) int {
 	return -1
 }
 
+func newAddressBookEntry(p *Pipe, now time.Time, expiry time.Duration) *addressBookEntry {
+	_, relayed := p.raddr.(dialerAddr)
+
+	e := &addressBookEntry{Address: p.raddr, Pipe: p}
+	e.Added = now
+	e.ExpireAt = now.Add(expiry)
+	e.Relayed = relayed
+	e.InitSamples()
+	return e
+}
+
 func (a *addressBookEntry) String() string {
 	if a == nil {
 		return "<nil>"
@@ -283,6 +551,13 @@ func (a *addressBookEntry) String() string {
 	return a.Address.String()
 }
 
+func (a *addressBookEntry) pipe() *Pipe {
+	if a == nil {
+		return nil
+	}
+	return a.Pipe
+}
+
 func (a *addressBookEntry) AddLatencySample(d time.Duration) {
 	a.latency = d
 	a.ewma = time.Duration(ewma_α*float64(d) + (1.0-ewma_α)*float64(a.ewma))
@@ -306,5 +581,15 @@ func (s sortedAddressBookEntries) Less(i, j int) bool {
 		return false
 	}
 
+	// verified direct paths are preferred over relayed paths
+	if s[i].Reachable && s[j].Reachable && s[i].Relayed != s[j].Relayed {
+		if !s[i].Relayed && s[i].Verified {
+			return true
+		}
+		if !s[j].Relayed && s[j].Verified {
+			return false
+		}
+	}
+
 	return s[i].ewma < s[j].ewma
 }
@@ -0,0 +1,81 @@
+package e3x
+
+import (
+	"sync"
+)
+
+// pathObserver is notified by the address book when paths are added or lost
+// and when the active path changes. The methods are called while the address
+// book is locked and must not block.
+type pathObserver interface {
+	pathAdded(p *Pipe)
+	pathLost(p *Pipe)
+	pathChanged(from, to *Pipe)
+}
+
+// eventQueue runs the exchange hooks for state and path events in the order
+// the events happened. The hooks run on a separate goroutine so they never run
+// while the exchange or its address book is locked.
+type eventQueue struct {
+	mtx     sync.Mutex
+	queue   []func()
+	running bool
+}
+
+func (q *eventQueue) push(f func()) {
+	q.mtx.Lock()
+	q.queue = append(q.queue, f)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	q.mtx.Unlock()
+}
+
+func (q *eventQueue) run() {
+	for {
+		q.mtx.Lock()
+		if len(q.queue) == 0 {
+			q.running = false
+			q.mtx.Unlock()
+			return
+		}
+		f := q.queue[0]
+		q.queue[0] = nil
+		q.queue = q.queue[1:]
+		q.mtx.Unlock()
+
+		f()
+	}
+}
+
+// setState moves the exchange to state s. x.mtx must be held.
+func (x *Exchange) setState(s ExchangeState) {
+	from := x.state
+	if from == s {
+		return
+	}
+
+	x.state = s
+	x.events.push(func() { x.exchangeHooks.StateChanged(from, s) })
+}
+
+func (x *Exchange) pathAdded(p *Pipe) {
+	x.events.push(func() { x.exchangeHooks.PathAdded(p) })
+}
+
+func (x *Exchange) pathLost(p *Pipe) {
+	x.events.push(func() { x.exchangeHooks.PathLost(p) })
+}
+
+func (x *Exchange) pathChanged(from, to *Pipe) {
+	if from != nil && to != nil {
+		x.stats.countPathSwitch()
+	}
+	x.events.push(func() { x.exchangeHooks.PathChanged(from, to) })
+}
+
+func (x *Exchange) handshakeCompleted(p *Pipe) {
+	x.stats.countHandshake()
+	x.events.push(func() { x.exchangeHooks.Handshake(p) })
+}
@@ -0,0 +1,81 @@
+package e3x
+
+import (
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// MultipathMode controls how an exchange uses its reachable paths.
+type MultipathMode uint8
+
+const (
+	// MultipathOff sends all channel packets over the active path.
+	MultipathOff MultipathMode = iota
+
+	// MultipathStripe spreads the channel packets over the reachable backup
+	// paths. Faster paths (by EWMA latency) carry more packets. Reliable
+	// channels put the packets back in order.
+	MultipathStripe
+
+	// MultipathDuplicate sends critical packets (channel opens and ends of
+	// reliable channels and ad-hoc acks) over the two best paths. All other
+	// packets use the active path.
+	MultipathDuplicate
+)
+
+func (m MultipathMode) String() string {
+	switch m {
+	case MultipathOff:
+		return "off"
+	case MultipathStripe:
+		return "stripe"
+	case MultipathDuplicate:
+		return "duplicate"
+	default:
+		return "unknown"
+	}
+}
+
+// Multipath sets the multipath mode of all the exchanges of the endpoint.
+func Multipath(mode MultipathMode) EndpointOption {
+	return func(e *Endpoint) error {
+		e.multipath = mode
+		return nil
+	}
+}
+
+// WithMultipath sets the multipath mode of a single exchange.
+func WithMultipath(mode MultipathMode) ExchangeOption {
+	return func(x *Exchange) error {
+		x.multipath = mode
+		return nil
+	}
+}
+
+// selectPipes returns the pipes pkt must be sent over.
+func (x *Exchange) selectPipes(mode MultipathMode, pkt *lob.Packet) []*Pipe {
+	switch mode {
+	case MultipathStripe:
+		if p := x.addressBook.StripePipe(); p != nil {
+			return []*Pipe{p}
+		}
+
+	case MultipathDuplicate:
+		if isCriticalPacket(pkt.Header()) {
+			if pipes := x.addressBook.BestPipes(2); len(pipes) > 0 {
+				return pipes
+			}
+		}
+	}
+
+	return []*Pipe{x.addressBook.ActiveConnection()}
+}
+
+// isCriticalPacket returns true for packets that are worth sending twice.
+// Packets of unreliable channels are never duplicated as the receiver can't
+// detect the duplicates.
+func isCriticalPacket(hdr *lob.Header) bool {
+	if hdr.HasSeq {
+		return hdr.HasType || (hdr.HasEnd && hdr.End)
+	}
+	return hdr.HasAck
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"sync"
 
+	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/tracer"
 	"github.com/telehash/gogotelehash/transports"
@@ -30,6 +31,7 @@ type message struct {
 type pipeDelegate interface {
 	received(msg message)
 	dialDialerAddr(dialerAddr) (net.Conn, error)
+	cloaking() int
 }
 
 type dialerAddr interface {
@@ -37,14 +39,43 @@ type dialerAddr interface {
 }
 
 func newMessage(msg *bufpool.Buffer, p *Pipe) message {
-	raw := msg.RawBytes()
+	return message{tracer.NewID(), msg, p, isHandshake(msg.RawBytes())}
+}
+
+func isHandshake(raw []byte) bool {
+	return len(raw) >= 3 && raw[0] == 0 && raw[1] == 1
+}
+
+// uncloak removes the cloaking from msg.Data.
+func (msg *message) uncloak() error {
+	if raw := msg.Data.RawBytes(); len(raw) == 0 || raw[0] == 0 {
+		return nil
+	}
 
-	isHandshake := false
-	if len(raw) >= 3 && raw[0] == 0 && raw[1] == 1 {
-		isHandshake = true
+	err := uncloakBuffer(msg.Data)
+	if err != nil {
+		return err
 	}
 
-	return message{tracer.NewID(), msg, p, isHandshake}
+	msg.IsHandshake = isHandshake(msg.Data.RawBytes())
+	return nil
+}
+
+// uncloakBuffer removes the cloaking from buf (in place).
+func uncloakBuffer(buf *bufpool.Buffer) error {
+	raw := buf.RawBytes()
+	if len(raw) == 0 || raw[0] == 0 {
+		return nil
+	}
+
+	pkt, err := lob.Uncloak(raw)
+	if err != nil {
+		return err
+	}
+
+	n := copy(raw, pkt)
+	buf.SetLen(n)
+	return nil
 }
 
 func newPipe(t transports.Transport, conn net.Conn, addr net.Addr, delegate pipeDelegate) *Pipe {
@@ -126,6 +157,14 @@ func (p *Pipe) Write(b *bufpool.Buffer) (int, error) {
 		return 0, err
 	}
 
+	if rounds := p.delegate.cloaking(); rounds > 0 {
+		data, err := lob.Cloak(b.RawBytes(), rounds)
+		if err != nil {
+			return 0, err
+		}
+		return conn.Write(data)
+	}
+
 	return conn.Write(b.RawBytes())
 }
 
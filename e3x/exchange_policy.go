@@ -0,0 +1,84 @@
+package e3x
+
+import (
+	"time"
+)
+
+// ExchangePolicy controls the timeouts and the keepalive behaviour of an
+// exchange. Zero fields are replaced with the values from
+// DefaultExchangePolicy.
+type ExchangePolicy struct {
+	// DialTimeout is how long a new exchange may take to open.
+	DialTimeout time.Duration
+
+	// IdleExpiry is how long an open exchange without channels is kept.
+	IdleExpiry time.Duration
+
+	// BreakTimeout is how long an exchange is kept when the remote endpoint
+	// stops responding to handshakes.
+	BreakTimeout time.Duration
+
+	// HandshakeRetry is the initial interval between handshakes. The interval
+	// is doubled after each handshake until it reaches KeepaliveInterval.
+	HandshakeRetry time.Duration
+
+	// KeepaliveInterval is the maximum interval between handshakes.
+	KeepaliveInterval time.Duration
+
+	// PathExpiry is how long a path may go without responding to handshakes
+	// before it is considered broken.
+	PathExpiry time.Duration
+}
+
+// DefaultExchangePolicy is the policy used when no other policy is configured.
+var DefaultExchangePolicy = ExchangePolicy{
+	DialTimeout:       60 * time.Second,
+	IdleExpiry:        2 * time.Minute,
+	BreakTimeout:      2 * time.Minute,
+	HandshakeRetry:    4 * time.Second,
+	KeepaliveInterval: 60 * time.Second,
+	PathExpiry:        2 * time.Minute,
+}
+
+func (p ExchangePolicy) withDefaults() ExchangePolicy {
+	d := DefaultExchangePolicy
+
+	if p.DialTimeout <= 0 {
+		p.DialTimeout = d.DialTimeout
+	}
+	if p.IdleExpiry <= 0 {
+		p.IdleExpiry = d.IdleExpiry
+	}
+	if p.BreakTimeout <= 0 {
+		p.BreakTimeout = d.BreakTimeout
+	}
+	if p.HandshakeRetry <= 0 {
+		p.HandshakeRetry = d.HandshakeRetry
+	}
+	if p.KeepaliveInterval <= 0 {
+		p.KeepaliveInterval = d.KeepaliveInterval
+	}
+	if p.PathExpiry <= 0 {
+		p.PathExpiry = d.PathExpiry
+	}
+
+	return p
+}
+
+// Policy sets the policy of all the exchanges of the endpoint.
+func Policy(p ExchangePolicy) EndpointOption {
+	return func(e *Endpoint) error {
+		e.exchangePolicy = p.withDefaults()
+		return nil
+	}
+}
+
+// WithPolicy sets the policy of a single exchange.
+//
+//   x, err := e.CreateExchange(ident, e3x.WithPolicy(policy))
+func WithPolicy(p ExchangePolicy) ExchangeOption {
+	return func(x *Exchange) error {
+		x.policy = p.withDefaults()
+		return nil
+	}
+}
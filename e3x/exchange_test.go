@@ -0,0 +1,659 @@
+package e3x
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+	"github.com/telehash/gogotelehash/transports/mux"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestDialRace(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		stagger = make(chan chan time.Time, 16)
+		counter = &writeCounter{writes: make(map[string]int)}
+	)
+
+	// the stagger delays only elapse when the test releases them
+	manualStagger := func(e *Endpoint) error {
+		e.dialAfter = func(time.Duration) <-chan time.Time {
+			c := make(chan time.Time)
+			stagger <- c
+			return c
+		}
+		return nil
+	}
+
+	A, err := Open(Log(nil), DialStagger(time.Hour), manualStagger, Transport(countingConfig{udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}, counter}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	C, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer C.Close()
+
+	blackhole, err := transports.ResolveAddr("udp4", "127.0.0.1:9")
+	if !assert.NoError(err) {
+		return
+	}
+
+	{ // the fast path is tried first; the other path is cancelled
+		ident, err := B.LocalIdentity()
+		assert.NoError(err)
+		ident, err = NewIdentity(ident.Keys(), hashname.PartsFromKeys(ident.Keys()), append(ident.Addresses(), blackhole))
+		assert.NoError(err)
+
+		_, err = A.Dial(ident)
+		assert.NoError(err)
+
+		// releasing the pending delay must not reach the cancelled path
+		close(<-stagger)
+		assert.Equal(0, counter.Writes(blackhole))
+	}
+
+	{ // the broken path is tried first; the next path is tried after the stagger delay
+		ident, err := C.LocalIdentity()
+		assert.NoError(err)
+		ident, err = NewIdentity(ident.Keys(), hashname.PartsFromKeys(ident.Keys()), append([]net.Addr{blackhole}, ident.Addresses()...))
+		assert.NoError(err)
+
+		dialed := make(chan error, 1)
+		go func() {
+			_, err := A.Dial(ident)
+			dialed <- err
+		}()
+
+		delay := <-stagger
+		select {
+		case err := <-dialed:
+			t.Fatalf("dialed before the stagger delay: %v", err)
+		default:
+		}
+		for _, addr := range ident.Addresses()[1:] {
+			assert.Equal(0, counter.Writes(addr))
+		}
+
+		close(delay)
+		assert.NoError(<-dialed)
+		assert.Equal(1, counter.Writes(blackhole))
+	}
+}
+
+func TestCloaking(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		counterA = &writeCounter{writes: make(map[string]int)}
+		counterB = &writeCounter{writes: make(map[string]int)}
+	)
+
+	A, err := Open(Log(nil), Cloaking(2), Transport(countingConfig{udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}, counterA}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(countingConfig{udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}, counterB}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	l := B.Listen("echo", true)
+	defer l.Close()
+
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(pkt)
+	}()
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := A.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal("hello", string(pkt.Body(nil)))
+	}
+
+	counterA.mtx.Lock()
+	assert.Equal(0, counterA.plain, "expected all the packets of A to be cloaked")
+	counterA.mtx.Unlock()
+
+	counterB.mtx.Lock()
+	assert.NotEqual(0, counterB.plain, "expected the packets of B not to be cloaked")
+	counterB.mtx.Unlock()
+}
+
+func TestPolicyDialTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	// B is unreachable
+	B.Close()
+
+	x, err := A.CreateExchange(ident, WithPolicy(ExchangePolicy{
+		DialTimeout:    500 * time.Millisecond,
+		HandshakeRetry: 100 * time.Millisecond,
+	}))
+	if !assert.NoError(err) {
+		return
+	}
+
+	start := time.Now()
+	err = x.Dial()
+	assert.Error(err)
+	assert.True(time.Since(start) < 5*time.Second, "dial took %s", time.Since(start))
+}
+
+func TestPolicyIdleExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := ExchangePolicy{IdleExpiry: 500 * time.Millisecond}
+
+	A, err := Open(Log(nil), Policy(policy), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	x, err := A.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(ExchangeIdle, x.State())
+
+	time.Sleep(time.Second)
+	assert.Equal(ExchangeExpired, x.State())
+}
+
+func TestPolicyDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	p := ExchangePolicy{BreakTimeout: time.Second}.withDefaults()
+	assert.Equal(time.Second, p.BreakTimeout)
+	assert.Equal(DefaultExchangePolicy.IdleExpiry, p.IdleExpiry)
+	assert.Equal(DefaultExchangePolicy.PathExpiry, p.PathExpiry)
+	assert.Equal(DefaultExchangePolicy.HandshakeRetry, p.HandshakeRetry)
+}
+
+func TestDeliverOnBrokenExchange(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	x, err := A.Dial(B.mustLocalIdentity(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	x.onBreak()
+	assert.Equal(BrokenExchangeError(x.RemoteHashname()), x.deliverPacket(lob.New(nil), nil))
+
+	// the exchange lock must be released by the failed delivery
+	done := make(chan ExchangeState)
+	go func() { done <- x.State() }()
+	select {
+	case state := <-done:
+		assert.Equal(ExchangeBroken, state)
+	case <-time.After(5 * time.Second):
+		t.Fatal("exchange lock was not released")
+	}
+}
+
+func TestMultipathStripe(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B, x, counter := openMultipathPair(t, MultipathStripe, fastPolicy)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	l := B.Listen("sink", false)
+	defer l.Close()
+
+	for i := 0; i < 50; i++ {
+		c, err := x.Open("sink", false)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+		c.Kill()
+	}
+
+	total := 0
+	for _, addr := range x.KnownPaths() {
+		n := counter.Packets(addr)
+		assert.True(n > 0, "no packets on %s", addr)
+		total += n
+	}
+	assert.Equal(50, total)
+}
+
+func TestMultipathDuplicate(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B, x, counter := openMultipathPair(t, MultipathDuplicate, fastPolicy)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	l := B.Listen("sink", true)
+	defer l.Close()
+
+	c, err := x.Open("sink", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	// the channel open is sent on both paths
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+	for _, addr := range x.KnownPaths() {
+		assert.Equal(1, counter.Packets(addr), "path %s", addr)
+	}
+
+	// the receiver drops the duplicate
+	rc, err := l.AcceptChannel()
+	if assert.NoError(err) {
+		pkt, err := rc.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal([]byte("hello"), pkt.Body(nil))
+		}
+		time.Sleep(50 * time.Millisecond)
+		rc.mtx.Lock()
+		assert.Equal(0, len(rc.readBuffer))
+		rc.mtx.Unlock()
+	}
+}
+
+func TestIsCriticalPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isCriticalPacket(&lob.Header{HasSeq: true, HasType: true}))
+	assert.True(isCriticalPacket(&lob.Header{HasSeq: true, HasEnd: true, End: true}))
+	assert.True(isCriticalPacket(&lob.Header{HasAck: true}))
+	assert.False(isCriticalPacket(&lob.Header{HasSeq: true}))
+	assert.False(isCriticalPacket(&lob.Header{HasType: true}))
+	assert.False(isCriticalPacket(&lob.Header{}))
+}
+
+func TestFailoverOnLoss(t *testing.T) {
+	assert := assert.New(t)
+
+	// slow handshake epochs, so only the loss signals can move the active path
+	policy := ExchangePolicy{HandshakeRetry: 5 * time.Millisecond, KeepaliveInterval: time.Minute}
+
+	A, B, x, counter := openMultipathPair(t, MultipathOff, policy)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	B.Mux().HandleFunc("echo", true, func(c *Channel) {
+		defer c.Close()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			if err = c.WritePacket(pkt); err != nil {
+				return
+			}
+		}
+	})
+
+	c, err := x.Open("echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	echo := func(body string) {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(c.WritePacket(lob.New([]byte(body))))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal([]byte(body), pkt.Body(nil))
+		}
+	}
+
+	// wait for the handshake epochs to settle on the (faster) inproc path
+	deadline := time.Now().Add(10 * time.Second)
+	for x.ActivePath().Network() != "inproc" {
+		if time.Now().After(deadline) {
+			t.Error("expected the inproc path to become active")
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	echo("before")
+
+	// the active path silently drops all packets sent by A
+	dead := x.ActivePath()
+	counter.Block(dead)
+
+	// the first resend (after one resend timeout) probes the backup paths and
+	// the first backup to answer replaces the active path.
+	var (
+		rtt   = x.addressBook.ActiveLatency()
+		rto   = x.resendTimeout()
+		start = time.Now()
+	)
+	echo("after")
+	elapsed := time.Since(start)
+	assert.NotEqual(dead.String(), x.ActivePath().String())
+	t.Logf("rtt=%s rto=%s failover took %s", rtt, rto, elapsed)
+	assert.True(elapsed < rto+4*rtt+50*time.Millisecond, "failover took %s (rtt=%s rto=%s)", elapsed, rtt, rto)
+}
+
+// openMultipathPair opens two endpoints which are connected over two paths
+// (udp and inproc).
+func openMultipathPair(t *testing.T, mode MultipathMode, policy ExchangePolicy) (A, B *Endpoint, x *Exchange, counter *writeCounter) {
+	var err error
+
+	counter = &writeCounter{writes: make(map[string]int)}
+
+	A, err = Open(Log(nil), Policy(policy), Multipath(mode),
+		Transport(countingConfig{mux.Config{udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}, inproc.Config{}}, counter}))
+	if err != nil {
+		t.Error(err)
+		return nil, nil, nil, nil
+	}
+
+	B, err = Open(Log(nil), Policy(policy),
+		Transport(mux.Config{udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}, inproc.Config{}}))
+	if err != nil {
+		A.Close()
+		t.Error(err)
+		return nil, nil, nil, nil
+	}
+
+	x, err = A.Dial(B.mustLocalIdentity(t))
+	if err != nil {
+		A.Close()
+		B.Close()
+		t.Error(err)
+		return nil, nil, nil, nil
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(x.addressBook.BestPipes(2)) < 2 {
+		if time.Now().After(deadline) {
+			A.Close()
+			B.Close()
+			t.Error("expected two reachable paths")
+			return nil, nil, nil, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return A, B, x, counter
+}
+
+var fastPolicy = ExchangePolicy{HandshakeRetry: 20 * time.Millisecond, KeepaliveInterval: 50 * time.Millisecond}
+
+type countingConfig struct {
+	config  transports.Config
+	counter *writeCounter
+}
+
+type countingTransport struct {
+	transports.Transport
+	counter *writeCounter
+}
+
+type countingConn struct {
+	net.Conn
+	counter *writeCounter
+}
+
+type writeCounter struct {
+	mtx     sync.Mutex
+	writes  map[string]int
+	packets map[string]int // writes that are not handshakes
+	plain   int            // writes that start with a 0x00 byte (not cloaked)
+	blocked map[string]bool
+}
+
+func (c countingConfig) Open() (transports.Transport, error) {
+	t, err := c.config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &countingTransport{t, c.counter}, nil
+}
+
+func (t *countingTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, t.counter}, nil
+}
+
+func (t *countingTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, t.counter}, nil
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.counter.mtx.Lock()
+	c.counter.writes[c.RemoteAddr().String()]++
+	if len(b) > 0 && b[0] == 0 {
+		c.counter.plain++
+	}
+	if !isHandshake(b) {
+		if c.counter.packets == nil {
+			c.counter.packets = make(map[string]int)
+		}
+		c.counter.packets[c.RemoteAddr().String()]++
+	}
+	blocked := c.counter.blocked[c.RemoteAddr().String()]
+	c.counter.mtx.Unlock()
+	if blocked {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// Block makes all writes to addr disappear.
+func (c *writeCounter) Block(addr net.Addr) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.blocked == nil {
+		c.blocked = make(map[string]bool)
+	}
+	c.blocked[addr.String()] = true
+}
+
+func (c *writeCounter) Writes(addr net.Addr) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.writes[addr.String()]
+}
+
+func (c *writeCounter) Packets(addr net.Addr) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.packets[addr.String()]
+}
+
+func TestExchangeEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer B.Close()
+
+	var (
+		mtx        sync.Mutex
+		states     []ExchangeState
+		accepted   []ExchangeState
+		added      int
+		changed    int
+		handshakes int
+	)
+
+	B.DefaultExchangeHooks().Register(ExchangeHook{
+		OnStateChanged: func(e *Endpoint, x *Exchange, from, to ExchangeState) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if len(accepted) == 0 {
+				accepted = append(accepted, from)
+			}
+			accepted = append(accepted, to)
+			return nil
+		},
+	})
+
+	A.DefaultExchangeHooks().Register(ExchangeHook{
+		OnStateChanged: func(e *Endpoint, x *Exchange, from, to ExchangeState) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if len(states) == 0 {
+				states = append(states, from)
+			}
+			states = append(states, to)
+			return nil
+		},
+		OnPathAdded: func(e *Endpoint, x *Exchange, pipe *Pipe) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			added++
+			return nil
+		},
+		OnPathChanged: func(e *Endpoint, x *Exchange, from, to *Pipe) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if from == nil && to != nil {
+				changed++
+			}
+			return nil
+		},
+		OnHandshake: func(e *Endpoint, x *Exchange, pipe *Pipe) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			handshakes++
+			return nil
+		},
+	})
+
+	_, err := A.Dial(B.mustLocalIdentity(t))
+	if !assert.NoError(err) {
+		A.Close()
+		return
+	}
+
+	// closing the endpoint breaks its exchanges
+	assert.NoError(A.Close())
+
+	// the hooks run asynchronously
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mtx.Lock()
+		n := len(states)
+		mtx.Unlock()
+		if n >= 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	expected := []ExchangeState{ExchangeInitialising, ExchangeDialing, ExchangeIdle, ExchangeBroken}
+	assert.True(reflect.DeepEqual(expected, states), "states=%v", states)
+	expected = []ExchangeState{ExchangeInitialising, ExchangeDialing, ExchangeIdle}
+	assert.True(len(accepted) >= 3 && reflect.DeepEqual(expected, accepted[:3]), "accepted=%v", accepted)
+	assert.Equal(1, added)
+	assert.Equal(1, changed)
+	assert.True(handshakes > 0)
+}
@@ -13,6 +13,9 @@ var (
 	statChannelSndPkt       *expvar.Int
 	statChannelSndAckInline *expvar.Int
 	statChannelSndAckAdHoc  *expvar.Int
+	statQuotaExchangeReject *expvar.Int
+	statQuotaChannelReject  *expvar.Int
+	statQuotaBufferReject   *expvar.Int
 )
 
 func init() {
@@ -29,6 +32,9 @@ func resetStats() {
 	statChannelSndPkt = new(expvar.Int)
 	statChannelSndAckInline = new(expvar.Int)
 	statChannelSndAckAdHoc = new(expvar.Int)
+	statQuotaExchangeReject = new(expvar.Int)
+	statQuotaChannelReject = new(expvar.Int)
+	statQuotaBufferReject = new(expvar.Int)
 
 	statsMap.Set("channel.rcv.pkt", statChannelRcvPkt)
 	statsMap.Set("channel.rcv.pkt.drop", statChannelRcvPktDrop)
@@ -37,4 +43,7 @@ func resetStats() {
 	statsMap.Set("channel.snd.pkt", statChannelSndPkt)
 	statsMap.Set("channel.snd.ack.inline", statChannelSndAckInline)
 	statsMap.Set("channel.snd.ack.ad-hoc", statChannelSndAckAdHoc)
+	statsMap.Set("quota.exchange.reject", statQuotaExchangeReject)
+	statsMap.Set("quota.channel.reject", statQuotaChannelReject)
+	statsMap.Set("quota.buffer.reject", statQuotaBufferReject)
 }
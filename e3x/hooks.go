@@ -3,6 +3,8 @@ package e3x
 import (
 	"errors"
 	"net"
+
+	"github.com/telehash/gogotelehash/internal/lob"
 )
 
 var ErrStopPropagation = errors.New("observer: stop propagation")
@@ -32,11 +34,59 @@ type ExchangeHook struct {
 	OnOpened     func(*Endpoint, *Exchange) error
 	OnClosed     func(*Endpoint, *Exchange, error) error
 	OnDropPacket func(e *Endpoint, x *Exchange, msg []byte, pipe *Pipe, reason error) error
+
+	// OnSendPacket is called with the plaintext packet before it is encrypted.
+	// The packet must not be modified.
+	OnSendPacket func(e *Endpoint, x *Exchange, pkt *lob.Packet, pipe *Pipe) error
+
+	// OnReceivePacket is called with the packet after it was decrypted.
+	// The packet must not be modified.
+	OnReceivePacket func(e *Endpoint, x *Exchange, pkt *lob.Packet, pipe *Pipe) error
+
+	// OnStateChanged is called after the exchange moved from one state to
+	// another.
+	OnStateChanged func(e *Endpoint, x *Exchange, from, to ExchangeState) error
+
+	// OnPathChanged is called after the active path changed. from and to may
+	// be nil.
+	OnPathChanged func(e *Endpoint, x *Exchange, from, to *Pipe) error
+
+	// OnPathAdded is called when a path becomes reachable.
+	OnPathAdded func(e *Endpoint, x *Exchange, pipe *Pipe) error
+
+	// OnPathLost is called when a path stopped answering handshakes.
+	OnPathLost func(e *Endpoint, x *Exchange, pipe *Pipe) error
+
+	// OnHandshake is called after a handshake was accepted over pipe.
+	OnHandshake func(e *Endpoint, x *Exchange, pipe *Pipe) error
 }
 
+// ChannelHook observes channels. The packet hooks are called while the
+// channel is locked; they must not call methods of the channel.
 type ChannelHook struct {
 	OnOpened func(*Endpoint, *Exchange, *Channel) error
 	OnClosed func(*Endpoint, *Exchange, *Channel) error
+
+	// OnSendPacket is called before a packet is sent for the first time. The
+	// hook may modify the packet but must not change the c and seq headers.
+	// A non-nil error vetoes the packet and is returned by WritePacket. The
+	// headers of a vetoed packet are restored and the channel state is left
+	// unchanged.
+	OnSendPacket func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error
+
+	// OnReceivePacket is called before a received packet is processed. The
+	// hook may modify the packet. A non-nil error drops the packet.
+	OnReceivePacket func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error
+
+	// OnResendPacket is called before a packet is retransmitted. A non-nil
+	// error skips the retransmission.
+	OnResendPacket func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error
+
+	// OnAckPacket is called when the remote endpoint acknowledged a packet.
+	OnAckPacket func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet) error
+
+	// OnDropPacket is called when a received packet is dropped.
+	OnDropPacket func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet, reason error) error
 }
 
 func (h *EndpointHooks) Register(hook EndpointHook) {
@@ -135,6 +185,69 @@ func (s *ExchangeHooks) DropPacket(msg []byte, pipe *Pipe, reason error) error {
 	})
 }
 
+func (s *ExchangeHooks) SendPacket(pkt *lob.Packet, pipe *Pipe) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnSendPacket == nil {
+			return nil
+		}
+		return o.OnSendPacket(s.endpoint, s.exchange, pkt, pipe)
+	})
+}
+
+func (s *ExchangeHooks) ReceivePacket(pkt *lob.Packet, pipe *Pipe) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnReceivePacket == nil {
+			return nil
+		}
+		return o.OnReceivePacket(s.endpoint, s.exchange, pkt, pipe)
+	})
+}
+
+func (s *ExchangeHooks) StateChanged(from, to ExchangeState) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnStateChanged == nil {
+			return nil
+		}
+		return o.OnStateChanged(s.endpoint, s.exchange, from, to)
+	})
+}
+
+func (s *ExchangeHooks) PathChanged(from, to *Pipe) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnPathChanged == nil {
+			return nil
+		}
+		return o.OnPathChanged(s.endpoint, s.exchange, from, to)
+	})
+}
+
+func (s *ExchangeHooks) PathAdded(pipe *Pipe) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnPathAdded == nil {
+			return nil
+		}
+		return o.OnPathAdded(s.endpoint, s.exchange, pipe)
+	})
+}
+
+func (s *ExchangeHooks) PathLost(pipe *Pipe) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnPathLost == nil {
+			return nil
+		}
+		return o.OnPathLost(s.endpoint, s.exchange, pipe)
+	})
+}
+
+func (s *ExchangeHooks) Handshake(pipe *Pipe) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnHandshake == nil {
+			return nil
+		}
+		return o.OnHandshake(s.endpoint, s.exchange, pipe)
+	})
+}
+
 func (s *ChannelHooks) Opened() error {
 	return s.trigger(func(o ChannelHook) error {
 		if o.OnOpened == nil {
@@ -152,3 +265,48 @@ func (s *ChannelHooks) Closed() error {
 		return o.OnClosed(s.endpoint, s.exchange, s.channel)
 	})
 }
+
+func (s *ChannelHooks) SendPacket(pkt *lob.Packet) error {
+	return s.trigger(func(o ChannelHook) error {
+		if o.OnSendPacket == nil {
+			return nil
+		}
+		return o.OnSendPacket(s.endpoint, s.exchange, s.channel, pkt)
+	})
+}
+
+func (s *ChannelHooks) ReceivePacket(pkt *lob.Packet) error {
+	return s.trigger(func(o ChannelHook) error {
+		if o.OnReceivePacket == nil {
+			return nil
+		}
+		return o.OnReceivePacket(s.endpoint, s.exchange, s.channel, pkt)
+	})
+}
+
+func (s *ChannelHooks) ResendPacket(pkt *lob.Packet) error {
+	return s.trigger(func(o ChannelHook) error {
+		if o.OnResendPacket == nil {
+			return nil
+		}
+		return o.OnResendPacket(s.endpoint, s.exchange, s.channel, pkt)
+	})
+}
+
+func (s *ChannelHooks) AckPacket(pkt *lob.Packet) error {
+	return s.trigger(func(o ChannelHook) error {
+		if o.OnAckPacket == nil {
+			return nil
+		}
+		return o.OnAckPacket(s.endpoint, s.exchange, s.channel, pkt)
+	})
+}
+
+func (s *ChannelHooks) DropPacket(pkt *lob.Packet, reason error) error {
+	return s.trigger(func(o ChannelHook) error {
+		if o.OnDropPacket == nil {
+			return nil
+		}
+		return o.OnDropPacket(s.endpoint, s.exchange, s.channel, pkt, reason)
+	})
+}
@@ -0,0 +1,104 @@
+package e3x
+
+import (
+	"net"
+	"time"
+)
+
+// PathInfo is a snapshot of an entry in the address book of an exchange.
+type PathInfo struct {
+	Addr      net.Addr
+	Active    bool // used for channel packets
+	Reachable bool
+	Verified  bool
+	Backup    bool
+	Relayed   bool
+	Latency   time.Duration // last latency sample
+	EWMA      time.Duration // smoothed latency
+	Added     time.Time
+	ExpireAt  time.Time
+}
+
+// ChannelInfo is a snapshot of the sequence counters of a channel.
+type ChannelInfo struct {
+	ID         uint32
+	Type       string
+	Reliable   bool
+	Unordered  bool
+	Partial    bool // partially reliable
+	Serverside bool // opened by the remote endpoint
+	Broken     bool
+
+	SendSeq      uint32 // highest sent seq
+	SendAckedSeq uint32 // highest seq acked by the remote endpoint
+	SendSkipSeq  uint32 // highest abandoned seq
+
+	ReceiveSeq         uint32 // highest seq read in order
+	ReceiveSeenSeq     uint32 // highest seq seen
+	ReceiveBufferedSeq uint32 // highest buffered seq
+	ReceiveAckedSeq    uint32 // highest seq acked to the remote endpoint
+}
+
+// CSID returns the id of the cipher set used by the exchange.
+func (x *Exchange) CSID() uint8 {
+	x.mtx.Lock()
+	csid := x.csid
+	x.mtx.Unlock()
+	return csid
+}
+
+// Paths returns a snapshot of the address book of the exchange.
+func (x *Exchange) Paths() []PathInfo {
+	if x.addressBook == nil {
+		return nil
+	}
+	return x.addressBook.Paths()
+}
+
+func (book *addressBook) Paths() []PathInfo {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	s := make([]PathInfo, len(book.known))
+	for i, e := range book.known {
+		s[i] = PathInfo{
+			Addr:      e.Address,
+			Active:    e == book.active,
+			Reachable: e.Reachable,
+			Verified:  e.Verified,
+			Backup:    e.IsBackup,
+			Relayed:   e.Relayed,
+			Latency:   e.latency,
+			EWMA:      e.ewma,
+			Added:     e.Added,
+			ExpireAt:  e.ExpireAt,
+		}
+	}
+
+	return s
+}
+
+// Info returns a snapshot of the sequence counters of the channel.
+func (c *Channel) Info() ChannelInfo {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return ChannelInfo{
+		ID:         c.id,
+		Type:       c.typ,
+		Reliable:   c.reliable,
+		Unordered:  c.unordered,
+		Partial:    c.partial,
+		Serverside: c.serverside,
+		Broken:     c.broken,
+
+		SendSeq:      c.oSeq,
+		SendAckedSeq: c.oAckedSeq,
+		SendSkipSeq:  c.oSkipSeq,
+
+		ReceiveSeq:         c.iSeq,
+		ReceiveSeenSeq:     c.iSeenSeq,
+		ReceiveBufferedSeq: c.iBufferedSeq,
+		ReceiveAckedSeq:    c.iAckedSeq,
+	}
+}
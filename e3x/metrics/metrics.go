@@ -0,0 +1,269 @@
+// Package metrics exports the statistics of an endpoint, its exchanges, its
+// transports and its channels for Prometheus compatible scrapers.
+//
+//   http.Handle("/metrics", metrics.Handler(e))
+//
+// The handler serves the Prometheus text exposition format (version 0.0.4) or,
+// when the scraper asks for it, the OpenMetrics text format.
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/telehash/gogotelehash/e3x"
+)
+
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Handler returns a http.Handler which serves the metrics of e.
+func Handler(e *e3x.Endpoint) http.Handler {
+	return &handler{e}
+}
+
+type handler struct {
+	e *e3x.Endpoint
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypeText)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range Collect(h.e) {
+		f.write(bw, openMetrics)
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	bw.Flush()
+}
+
+// Type is the type of a metric family.
+type Type string
+
+const (
+	Counter Type = "counter"
+	Gauge   Type = "gauge"
+)
+
+// A Family is a set of metrics with the same name.
+type Family struct {
+	Name    string // without the _total suffix of counters
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// A Sample is a single value of a family.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// A Label is a name/value pair.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Collect gathers the metrics of e.
+func Collect(e *e3x.Endpoint) []*Family {
+	c := newCollector()
+	c.collectEndpoint(e)
+	return c.families
+}
+
+type collector struct {
+	families []*Family
+	index    map[string]*Family
+}
+
+func newCollector() *collector {
+	return &collector{index: make(map[string]*Family)}
+}
+
+func (c *collector) add(name string, typ Type, help string, v float64, labels ...Label) {
+	f := c.index[name]
+	if f == nil {
+		f = &Family{Name: name, Help: help, Type: typ}
+		c.index[name] = f
+		c.families = append(c.families, f)
+	}
+	f.Samples = append(f.Samples, Sample{labels, v})
+}
+
+func (c *collector) addTraffic(prefix string, s e3x.TrafficStats, labels ...Label) {
+	c.add(prefix+"_packets_sent", Counter, "Channel packets sent.", float64(s.PacketsSent), labels...)
+	c.add(prefix+"_packets_received", Counter, "Channel packets received.", float64(s.PacketsReceived), labels...)
+	c.add(prefix+"_bytes_sent", Counter, "Bytes in the bodies of the sent channel packets.", float64(s.BytesSent), labels...)
+	c.add(prefix+"_bytes_received", Counter, "Bytes in the bodies of the received channel packets.", float64(s.BytesReceived), labels...)
+	c.add(prefix+"_retransmissions", Counter, "Retransmitted channel packets.", float64(s.Retransmissions), labels...)
+	c.add(prefix+"_packets_dropped", Counter, "Received channel packets that were dropped.", float64(s.PacketsDropped), labels...)
+}
+
+func (c *collector) collectEndpoint(e *e3x.Endpoint) {
+	es := e.Stats()
+
+	c.add("telehash_endpoint_exchanges", Gauge, "Open exchanges.", float64(es.Exchanges))
+	c.add("telehash_endpoint_channels", Gauge, "Open channels.", float64(es.Channels))
+	c.add("telehash_endpoint_handshakes", Counter, "Accepted handshakes.", float64(es.Handshakes))
+	c.add("telehash_endpoint_path_switches", Counter, "Changes of the active path of exchanges.", float64(es.PathSwitches))
+	c.addTraffic("telehash_endpoint", es.TrafficStats)
+
+	var (
+		exchanges = e.GetExchanges()
+		networks  = map[string]int{}
+	)
+
+	sort.Sort(byHashname(exchanges))
+
+	if t := e3x.TransportsFromEndpoint(e); t != nil {
+		for _, addr := range t.LocalAddresses() {
+			networks[addr.Network()] = 0
+			c.add("telehash_transport_local_addresses", Gauge, "Local addresses of the transports.", 1,
+				Label{"network", addr.Network()}, Label{"address", addr.String()})
+		}
+	}
+
+	for _, x := range exchanges {
+		if addr := x.ActivePath(); addr != nil {
+			networks[addr.Network()]++
+		}
+	}
+	for _, network := range sortedKeys(networks) {
+		c.add("telehash_transport_active_exchanges", Gauge, "Exchanges with an active path on the transport network.", float64(networks[network]),
+			Label{"network", network})
+	}
+
+	for _, x := range exchanges {
+		c.collectExchange(x)
+	}
+}
+
+func (c *collector) collectExchange(x *e3x.Exchange) {
+	var (
+		remote = Label{"remote", string(x.RemoteHashname())}
+		xs     = x.Stats()
+	)
+
+	c.add("telehash_exchange_handshakes", Counter, "Accepted handshakes.", float64(xs.Handshakes), remote)
+	c.add("telehash_exchange_path_switches", Counter, "Changes of the active path.", float64(xs.PathSwitches), remote)
+	c.add("telehash_exchange_rtt_seconds", Gauge, "Smoothed round trip time of the active path.", xs.SmoothedRTT.Seconds(), remote)
+	c.add("telehash_exchange_channels", Gauge, "Open channels.", float64(xs.Channels), remote)
+	c.add("telehash_exchange_in_flight_packets", Gauge, "Sent but unacknowledged packets.", float64(xs.InFlight), remote)
+	c.add("telehash_exchange_buffered_packets", Gauge, "Received but unread packets.", float64(xs.Buffered), remote)
+	c.addTraffic("telehash_exchange", xs.TrafficStats, remote)
+
+	paths := map[string]int{}
+	for _, addr := range x.KnownPaths() {
+		paths[addr.Network()]++
+	}
+	for _, network := range sortedKeys(paths) {
+		c.add("telehash_exchange_paths", Gauge, "Known paths per transport network.", float64(paths[network]),
+			remote, Label{"network", network})
+	}
+
+	// channels are aggregated by type; the traffic counters include the
+	// closed channels
+	var (
+		traffic = x.ChannelTraffic()
+		types   = map[string]*e3x.ChannelStats{}
+		open    = map[string]int{}
+	)
+	for typ := range traffic {
+		types[typ] = &e3x.ChannelStats{}
+		open[typ] = 0
+	}
+	for _, ch := range x.Channels() {
+		cs := ch.Stats()
+		sum := types[ch.Type()]
+		if sum == nil {
+			sum = &e3x.ChannelStats{}
+			types[ch.Type()] = sum
+		}
+		open[ch.Type()]++
+		sum.InFlight += cs.InFlight
+		sum.Buffered += cs.Buffered
+		sum.BufferedBytes += cs.BufferedBytes
+	}
+	for _, typ := range sortedKeys(open) {
+		var (
+			sum    = types[typ]
+			labels = []Label{remote, {"type", typ}}
+		)
+		c.add("telehash_channel_open", Gauge, "Open channels.", float64(open[typ]), labels...)
+		c.add("telehash_channel_in_flight_packets", Gauge, "Sent but unacknowledged packets.", float64(sum.InFlight), labels...)
+		c.add("telehash_channel_buffered_packets", Gauge, "Received but unread packets.", float64(sum.Buffered), labels...)
+		c.add("telehash_channel_buffered_bytes", Gauge, "Bytes in the received but unread packets.", float64(sum.BufferedBytes), labels...)
+		c.addTraffic("telehash_channel", traffic[typ], labels...)
+	}
+}
+
+func (f *Family) write(w *bufio.Writer, openMetrics bool) {
+	name := f.Name
+	if f.Type == Counter && !openMetrics {
+		name += "_total"
+	}
+
+	w.WriteString("# HELP " + name + " " + escape(f.Help, false) + "\n")
+	w.WriteString("# TYPE " + name + " " + string(f.Type) + "\n")
+
+	for _, s := range f.Samples {
+		w.WriteString(f.Name)
+		if f.Type == Counter {
+			w.WriteString("_total")
+		}
+		if len(s.Labels) > 0 {
+			w.WriteByte('{')
+			for i, l := range s.Labels {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				w.WriteString(l.Name + "=\"" + escape(l.Value, true) + "\"")
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatFloat(s.Value, 'g', -1, 64))
+		w.WriteByte('\n')
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escape(s string, quoted bool) string {
+	if quoted {
+		return valueEscaper.Replace(s)
+	}
+	return helpEscaper.Replace(s)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type byHashname []*e3x.Exchange
+
+func (s byHashname) Len() int           { return len(s) }
+func (s byHashname) Less(i, j int) bool { return s[i].RemoteHashname() < s[j].RemoteHashname() }
+func (s byHashname) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Log(nil), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(e3x.Log(nil), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	B.Mux().HandleFunc("echo", true, func(c *e3x.Channel) {
+		defer c.Close()
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(pkt)
+	})
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := A.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+
+	remote := `remote="` + string(B.LocalHashname()) + `"`
+
+	{ // Prometheus text format
+		w := httptest.NewRecorder()
+		Handler(A).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		body := w.Body.String()
+
+		assert.Equal(contentTypeText, w.Header().Get("Content-Type"))
+		assert.Contains(body, "# TYPE telehash_endpoint_packets_sent_total counter\n")
+		assert.Contains(body, "telehash_endpoint_packets_sent_total 1\n")
+		assert.Contains(body, "telehash_endpoint_exchanges 1\n")
+		assert.Contains(body, "telehash_exchange_bytes_sent_total{"+remote+"} 5\n")
+		assert.Contains(body, "telehash_channel_open{"+remote+`,type="echo"} 1`+"\n")
+		assert.Contains(body, "telehash_exchange_paths{"+remote+`,network="udp4"} 1`+"\n")
+		assert.Contains(body, `telehash_transport_active_exchanges{network="udp4"} 1`+"\n")
+		assert.False(strings.Contains(body, "# EOF"))
+	}
+
+	{ // OpenMetrics text format
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		w := httptest.NewRecorder()
+		Handler(A).ServeHTTP(w, r)
+		body := w.Body.String()
+
+		assert.Equal(contentTypeOpenMetrics, w.Header().Get("Content-Type"))
+		assert.Contains(body, "# TYPE telehash_endpoint_packets_sent counter\n")
+		assert.Contains(body, "telehash_endpoint_packets_sent_total 1\n")
+		assert.True(strings.HasSuffix(body, "# EOF\n"))
+	}
+
+	{ // the channel counters keep the traffic of closed channels
+		c.Kill()
+
+		w := httptest.NewRecorder()
+		Handler(A).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		body := w.Body.String()
+
+		assert.Contains(body, "telehash_channel_open{"+remote+`,type="echo"} 0`+"\n")
+		assert.Contains(body, "telehash_channel_bytes_sent_total{"+remote+`,type="echo"} 5`+"\n")
+	}
+}
+
+func TestEscape(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`a\\b\n\"c\"`, escape("a\\b\n\"c\"", true))
+	assert.Equal(`a\\b\n"c"`, escape("a\\b\n\"c\"", false))
+}
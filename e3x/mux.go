@@ -0,0 +1,167 @@
+package e3x
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Handler handles the channels opened by remote endpoints. ServeChannel is
+// called on its own goroutine and owns the channel.
+type Handler interface {
+	ServeChannel(c *Channel)
+}
+
+// HandlerFunc is an adapter which allows the use of ordinary functions as
+// channel handlers.
+type HandlerFunc func(c *Channel)
+
+// ServeChannel calls f(c).
+func (f HandlerFunc) ServeChannel(c *Channel) {
+	f(c)
+}
+
+// Middleware wraps a Handler.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the middleware. The first middleware is the outermost one.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// ServeMux dispatches incoming channels to the handler that is registered for
+// their channel type. Patterns name fixed channel types, like "path", or
+// prefixes of channel types when they end in a "*", like "_thtp*". Fixed
+// patterns take precedence over prefixes and longer prefixes take precedence
+// over shorter ones.
+//
+// Channels of a type that has a Listener are never passed to the ServeMux.
+//
+//   mux := e.Mux()
+//   mux.Use(e3x.Recover())
+//   mux.HandleFunc("echo", true, func(c *e3x.Channel) {
+//     ...
+//   })
+type ServeMux struct {
+	mtx        sync.RWMutex
+	exact      map[string]muxEntry
+	prefixes   []muxEntry // sorted by descending length
+	middleware []Middleware
+}
+
+type muxEntry struct {
+	pattern  string
+	reliable bool
+	h        Handler
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{exact: make(map[string]muxEntry)}
+}
+
+// Use appends middleware to the middleware that wraps all the handlers of mux.
+func (mux *ServeMux) Use(middleware ...Middleware) {
+	mux.mtx.Lock()
+	mux.middleware = append(mux.middleware, middleware...)
+	mux.mtx.Unlock()
+}
+
+// Handle registers h for pattern. Channels that don't match reliable are
+// rejected. Handle panics when pattern is already registered.
+func (mux *ServeMux) Handle(pattern string, reliable bool, h Handler) {
+	if pattern == "" || pattern == "*" {
+		panic("e3x: invalid pattern " + pattern)
+	}
+	if h == nil {
+		panic("e3x: nil handler")
+	}
+
+	mux.mtx.Lock()
+	defer mux.mtx.Unlock()
+
+	entry := muxEntry{pattern, reliable, h}
+
+	if strings.HasSuffix(pattern, "*") {
+		for _, e := range mux.prefixes {
+			if e.pattern == pattern {
+				panic("e3x: multiple registrations for " + pattern)
+			}
+		}
+		mux.prefixes = append(mux.prefixes, entry)
+		sort.Sort(byPatternLength(mux.prefixes))
+		return
+	}
+
+	if _, found := mux.exact[pattern]; found {
+		panic("e3x: multiple registrations for " + pattern)
+	}
+	mux.exact[pattern] = entry
+}
+
+// HandleFunc registers the handler function f for pattern.
+func (mux *ServeMux) HandleFunc(pattern string, reliable bool, f func(c *Channel)) {
+	mux.Handle(pattern, reliable, HandlerFunc(f))
+}
+
+// Remove removes the handler that is registered for pattern.
+func (mux *ServeMux) Remove(pattern string) {
+	mux.mtx.Lock()
+	defer mux.mtx.Unlock()
+
+	delete(mux.exact, pattern)
+
+	for i, e := range mux.prefixes {
+		if e.pattern == pattern {
+			mux.prefixes = append(mux.prefixes[:i], mux.prefixes[i+1:]...)
+			break
+		}
+	}
+}
+
+// Handler returns the handler (wrapped in the middleware) for channels of type
+// typ. Handler returns nil when no handler matches typ.
+func (mux *ServeMux) Handler(typ string) (h Handler, reliable bool) {
+	if mux == nil {
+		return nil, false
+	}
+
+	mux.mtx.RLock()
+	defer mux.mtx.RUnlock()
+
+	entry, found := mux.exact[typ]
+	if !found {
+		for _, e := range mux.prefixes {
+			if strings.HasPrefix(typ, e.pattern[:len(e.pattern)-1]) {
+				entry, found = e, true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	return Chain(entry.h, mux.middleware...), entry.reliable
+}
+
+// ServeChannel dispatches c to the handler for its channel type. Channels
+// without a matching handler are killed.
+func (mux *ServeMux) ServeChannel(c *Channel) {
+	h, reliable := mux.Handler(c.typ)
+	if h == nil || reliable != c.reliable {
+		c.Kill()
+		return
+	}
+
+	h.ServeChannel(c)
+}
+
+type byPatternLength []muxEntry
+
+func (s byPatternLength) Len() int           { return len(s) }
+func (s byPatternLength) Less(i, j int) bool { return len(s[i].pattern) > len(s[j].pattern) }
+func (s byPatternLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
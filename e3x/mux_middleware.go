@@ -0,0 +1,102 @@
+package e3x
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+var (
+	ErrUnauthorized = errors.New("e3x: unauthorized")
+	ErrHandlerPanic = errors.New("e3x: internal error")
+)
+
+// Authorize rejects the channels of remote endpoints for which allow returns
+// false. The opener receives ErrUnauthorized.
+func Authorize(allow func(hn hashname.H) bool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(c *Channel) {
+			if !allow(c.RemoteHashname()) {
+				c.reject(ErrUnauthorized)
+				return
+			}
+			next.ServeChannel(c)
+		})
+	}
+}
+
+// AllowHashnames only allows channels from the hashnames in hns.
+func AllowHashnames(hns ...hashname.H) Middleware {
+	allowed := make(map[hashname.H]bool, len(hns))
+	for _, hn := range hns {
+		allowed[hn] = true
+	}
+	return Authorize(func(hn hashname.H) bool { return allowed[hn] })
+}
+
+// LogChannels logs when a handler starts and stops serving a channel.
+func LogChannels() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(c *Channel) {
+			x := c.Exchange()
+			if x == nil || !x.log.Enabled(slog.LevelInfo) {
+				next.ServeChannel(c)
+				return
+			}
+
+			start := time.Now()
+			x.log.Info("Serving channel", "type", c.typ, "channel", c.id)
+			defer func() {
+				x.log.Info("Served channel", "type", c.typ, "channel", c.id, "duration", time.Since(start))
+			}()
+
+			next.ServeChannel(c)
+		})
+	}
+}
+
+// Recover recovers from panics in handlers. The channel is rejected with
+// ErrHandlerPanic.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(c *Channel) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				if x := c.Exchange(); x != nil {
+					x.log.Error("Handler panicked", "type", c.typ, "channel", c.id, "panic", fmt.Sprint(r))
+				}
+				c.reject(ErrHandlerPanic)
+			}()
+
+			next.ServeChannel(c)
+		})
+	}
+}
+
+// MaxConcurrent limits the number of channels that are served at the same
+// time. Channels over the limit are rejected with ErrTooManyChannels.
+func MaxConcurrent(n int) Middleware {
+	sem := make(chan struct{}, n)
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(c *Channel) {
+			select {
+			case sem <- struct{}{}:
+			default:
+				statQuotaChannelReject.Add(1)
+				c.reject(ErrTooManyChannels)
+				return
+			}
+			defer func() { <-sem }()
+
+			next.ServeChannel(c)
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package e3x
+
+import (
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestServeMuxMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mux    = NewServeMux()
+		served string
+	)
+
+	handler := func(name string) HandlerFunc {
+		return func(c *Channel) { served = name }
+	}
+
+	mux.Handle("path", false, handler("path"))
+	mux.Handle("_thtp*", true, handler("thtp"))
+	mux.Handle("_th*", true, handler("th"))
+
+	for typ, expected := range map[string]string{
+		"path":       "path",
+		"_thtp":      "thtp",
+		"_thtp-post": "thtp",
+		"_thx":       "th",
+	} {
+		h, reliable := mux.Handler(typ)
+		if assert.NotNil(h, typ) {
+			served = ""
+			h.ServeChannel(nil)
+			assert.Equal(expected, served, typ)
+			assert.Equal(typ != "path", reliable, typ)
+		}
+	}
+
+	h, _ := mux.Handler("paths")
+	assert.Nil(h)
+
+	mux.Remove("_thtp*")
+	h, _ = mux.Handler("_thtp")
+	if assert.NotNil(h) {
+		h.ServeChannel(nil)
+		assert.Equal("th", served)
+	}
+
+	assert.Panics(func() { mux.Handle("path", false, handler("path")) })
+}
+
+func TestChain(t *testing.T) {
+	assert := assert.New(t)
+
+	var order []string
+
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(c *Channel) {
+				order = append(order, name)
+				next.ServeChannel(c)
+			})
+		}
+	}
+
+	h := Chain(HandlerFunc(func(c *Channel) { order = append(order, "handler") }), mw("a"), mw("b"))
+	h.ServeChannel(nil)
+	assert.Equal([]string{"a", "b", "handler"}, order)
+}
+
+func TestServeMuxDispatch(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	mux := B.Mux()
+	mux.Use(Recover(), LogChannels())
+	mux.HandleFunc("echo*", true, func(c *Channel) {
+		defer c.Close()
+
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(pkt)
+	})
+	mux.HandleFunc("panic", true, func(c *Channel) {
+		panic("oops")
+	})
+
+	ident := B.mustLocalIdentity(t)
+
+	{ // prefix match
+		c, err := A.Open(ident, "echo-1", true)
+		if assert.NoError(err) {
+			assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err) {
+				assert.Equal([]byte("hello"), pkt.Body(nil))
+			}
+			c.Close()
+		}
+	}
+
+	{ // panics are recovered
+		c, err := A.Open(ident, "panic", true)
+		if assert.NoError(err) {
+			assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+			_, err := c.ReadPacket()
+			if cerr, ok := err.(*ChannelError); assert.True(ok, "err=%v", err) {
+				assert.Equal(ErrHandlerPanic.Error(), cerr.Reason)
+			}
+		}
+	}
+}
+
+// pingModule registers a handler which answers with the hashname of its own
+// endpoint.
+type pingModule struct{ e *Endpoint }
+
+func (mod *pingModule) Init() error { return nil }
+func (mod *pingModule) Stop() error { return nil }
+
+func (mod *pingModule) Start() error {
+	mod.e.Mux().HandleFunc("ping", true, func(c *Channel) {
+		defer c.Close()
+		if _, err := c.ReadPacket(); err == nil {
+			c.WritePacket(lob.New([]byte(mod.e.LocalHashname())))
+		}
+	})
+	return nil
+}
+
+func TestSharedRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	shared := NewServeMux()
+	shared.HandleFunc("echo", true, func(c *Channel) {
+		defer c.Close()
+		if pkt, err := c.ReadPacket(); err == nil {
+			c.WritePacket(pkt)
+		}
+	})
+
+	open := func() *Endpoint {
+		e, err := Open(Log(nil), Router(shared),
+			func(e *Endpoint) error { return RegisterModule("ping", &pingModule{e})(e) },
+			Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+		if !assert.NoError(err) {
+			return nil
+		}
+		return e
+	}
+
+	// the modules of both endpoints register their own handlers
+	A := open()
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	B := open()
+	if B == nil {
+		return
+	}
+	defer B.Close()
+
+	ident := B.mustLocalIdentity(t)
+	for _, typ := range []string{"ping", "echo"} {
+		c, err := A.Open(ident, typ, true)
+		if !assert.NoError(err) {
+			continue
+		}
+		assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			if typ == "ping" {
+				assert.Equal(string(B.LocalHashname()), string(pkt.Body(nil)))
+			} else {
+				assert.Equal("hello", string(pkt.Body(nil)))
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	B.Mux().Handle("private", true, Chain(
+		HandlerFunc(func(c *Channel) { c.Close() }),
+		AllowHashnames(hashname.H("not-a")),
+	))
+
+	c, err := A.Open(B.mustLocalIdentity(t), "private", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	_, err = c.ReadPacket()
+	if cerr, ok := err.(*ChannelError); assert.True(ok, "err=%v", err) {
+		assert.Equal(ErrUnauthorized.Error(), cerr.Reason)
+	}
+}
+
+func openMuxPair(t *testing.T) (A, B *Endpoint) {
+	A, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+
+	B, err = Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if err != nil {
+		A.Close()
+		t.Error(err)
+		return nil, nil
+	}
+
+	return A, B
+}
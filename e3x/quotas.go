@@ -0,0 +1,96 @@
+package e3x
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	ErrTooManyExchanges = errors.New("e3x: too many exchanges")
+	ErrTooManyChannels  = errors.New("e3x: too many channels")
+)
+
+// Quotas limit the resources an endpoint and its peers may claim. Zero values
+// mean no limit.
+type Quotas struct {
+	// MaxExchanges is the maximum number of exchanges of the endpoint.
+	MaxExchanges int
+
+	// MaxChannels is the maximum number of open channels per exchange.
+	MaxChannels int
+
+	// MaxChannelsPerType is the maximum number of open channels of a type per
+	// exchange.
+	MaxChannelsPerType map[string]int
+
+	// MaxBufferedBytes is the maximum number of received but unread bytes per
+	// exchange. Packets that don't fit are dropped (and resent later by
+	// reliable channels).
+	MaxBufferedBytes int
+}
+
+// Limits sets the resource quotas of the endpoint.
+//
+//   e3x.Open(e3x.Limits(e3x.Quotas{
+//     MaxExchanges:       1024,
+//     MaxChannels:        64,
+//     MaxChannelsPerType: map[string]int{"stream": 8},
+//   }))
+func Limits(q Quotas) EndpointOption {
+	return func(e *Endpoint) error {
+		e.quotas = q
+		return nil
+	}
+}
+
+// checkChannel returns an error when opening a channel of type typ would exceed
+// the quotas. count returns the number of open channels and the number of
+// open channels of type typ.
+func (q *Quotas) checkChannel(typ string, count func(typ string) (n, nType int)) error {
+	maxType := q.MaxChannelsPerType[typ]
+	if q.MaxChannels <= 0 && maxType <= 0 {
+		return nil
+	}
+
+	n, nType := count(typ)
+	if (q.MaxChannels > 0 && n >= q.MaxChannels) || (maxType > 0 && nType >= maxType) {
+		statQuotaChannelReject.Add(1)
+		return ErrTooManyChannels
+	}
+
+	return nil
+}
+
+// bufferQuota is shared by all the channels of an exchange.
+type bufferQuota struct {
+	max  int64
+	used int64
+}
+
+func newBufferQuota(max int) *bufferQuota {
+	if max <= 0 {
+		return nil
+	}
+	return &bufferQuota{max: int64(max)}
+}
+
+func (q *bufferQuota) reserve(n int) bool {
+	if q == nil {
+		return true
+	}
+
+	if atomic.AddInt64(&q.used, int64(n)) > q.max {
+		atomic.AddInt64(&q.used, -int64(n))
+		statQuotaBufferReject.Add(1)
+		return false
+	}
+
+	return true
+}
+
+func (q *bufferQuota) release(n int) {
+	if q == nil || n == 0 {
+		return
+	}
+	atomic.AddInt64(&q.used, -int64(n))
+}
@@ -0,0 +1,133 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestQuotaChannels(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Limits(Quotas{MaxChannels: 1}), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	l := B.Listen("echo", true)
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					pkt, err := c.ReadPacket()
+					if err != nil {
+						return
+					}
+					c.WritePacket(pkt)
+				}
+			}()
+		}
+	}()
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c1, err := A.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c1.WritePacket(lob.New([]byte("hello"))))
+	_, err = c1.ReadPacket()
+	assert.NoError(err)
+
+	c2, err := A.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c2.WritePacket(lob.New([]byte("hello"))))
+	_, err = c2.ReadPacket()
+	if cerr, ok := err.(*ChannelError); assert.True(ok, "err=%v", err) {
+		assert.Equal(ErrTooManyChannels.Error(), cerr.Reason)
+	}
+
+	// the local side enforces the quota too
+	x, err := B.CreateExchange(A.mustLocalIdentity(t))
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = x.Open("echo", true)
+	assert.Equal(ErrTooManyChannels, err)
+}
+
+func TestQuotaExchanges(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Open(Log(nil), Limits(Quotas{MaxExchanges: 1}), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Log(nil), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	C, err := Open(Log(nil), Policy(ExchangePolicy{DialTimeout: 500 * time.Millisecond}), Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer C.Close()
+
+	_, err = A.Dial(B.mustLocalIdentity(t))
+	assert.NoError(err)
+
+	// the handshakes of C are dropped
+	_, err = C.Dial(A.mustLocalIdentity(t))
+	assert.Error(err)
+
+	_, err = A.Dial(C.mustLocalIdentity(t))
+	assert.Equal(ErrTooManyExchanges, err)
+}
+
+func TestBufferQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	var q *bufferQuota
+	assert.True(q.reserve(1 << 20))
+	q.release(1 << 20)
+
+	q = newBufferQuota(100)
+	assert.True(q.reserve(60))
+	assert.False(q.reserve(60))
+	q.release(60)
+	assert.True(q.reserve(100))
+}
+
+func (e *Endpoint) mustLocalIdentity(t *testing.T) *Identity {
+	ident, err := e.LocalIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ident
+}
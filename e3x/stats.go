@@ -0,0 +1,214 @@
+package e3x
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrafficStats count the packets written to and read from channels and the
+// bytes in their bodies. Acks and handshakes are not included.
+type TrafficStats struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+	Retransmissions uint64
+	PacketsDropped  uint64 // received packets that were dropped
+}
+
+// ChannelStats are the statistics of a single channel.
+type ChannelStats struct {
+	TrafficStats
+
+	InFlight      int // sent but unacknowledged packets
+	Buffered      int // received but unread packets
+	BufferedBytes int // bytes in the received but unread packets
+}
+
+// ExchangeStats are the statistics of an exchange. The traffic counters
+// include the channels that are already closed.
+type ExchangeStats struct {
+	TrafficStats
+
+	Handshakes   uint64        // accepted handshakes
+	PathSwitches uint64        // changes of the active path
+	SmoothedRTT  time.Duration // EWMA latency of the active path
+
+	Channels int // open channels
+	InFlight int
+	Buffered int
+}
+
+// EndpointStats are the statistics of an endpoint. The counters include the
+// exchanges that are already closed.
+type EndpointStats struct {
+	TrafficStats
+
+	Handshakes   uint64
+	PathSwitches uint64
+
+	Exchanges int // open exchanges
+	Channels  int
+	InFlight  int
+	Buffered  int
+}
+
+// statCounters are updated atomically. Every update is also applied to the
+// parent counters (channel -> exchange -> endpoint).
+type statCounters struct {
+	packetsSent     uint64
+	packetsReceived uint64
+	bytesSent       uint64
+	bytesReceived   uint64
+	retransmissions uint64
+	packetsDropped  uint64
+	handshakes      uint64
+	pathSwitches    uint64
+
+	parent *statCounters
+}
+
+func (s *statCounters) countSent(n int) {
+	for ; s != nil; s = s.parent {
+		atomic.AddUint64(&s.packetsSent, 1)
+		atomic.AddUint64(&s.bytesSent, uint64(n))
+	}
+}
+
+func (s *statCounters) countReceived(n int) {
+	for ; s != nil; s = s.parent {
+		atomic.AddUint64(&s.packetsReceived, 1)
+		atomic.AddUint64(&s.bytesReceived, uint64(n))
+	}
+}
+
+func (s *statCounters) countRetransmission() {
+	for ; s != nil; s = s.parent {
+		atomic.AddUint64(&s.retransmissions, 1)
+	}
+}
+
+func (s *statCounters) countDropped() {
+	for ; s != nil; s = s.parent {
+		atomic.AddUint64(&s.packetsDropped, 1)
+	}
+}
+
+func (s *statCounters) countHandshake() {
+	for ; s != nil; s = s.parent {
+		atomic.AddUint64(&s.handshakes, 1)
+	}
+}
+
+func (s *statCounters) countPathSwitch() {
+	for ; s != nil; s = s.parent {
+		atomic.AddUint64(&s.pathSwitches, 1)
+	}
+}
+
+func (s *statCounters) traffic() TrafficStats {
+	return TrafficStats{
+		PacketsSent:     atomic.LoadUint64(&s.packetsSent),
+		PacketsReceived: atomic.LoadUint64(&s.packetsReceived),
+		BytesSent:       atomic.LoadUint64(&s.bytesSent),
+		BytesReceived:   atomic.LoadUint64(&s.bytesReceived),
+		Retransmissions: atomic.LoadUint64(&s.retransmissions),
+		PacketsDropped:  atomic.LoadUint64(&s.packetsDropped),
+	}
+}
+
+// channelTypeCounters keep the traffic of the channels of an exchange per
+// channel type. They sit between the channel and the exchange counters so they
+// include the channels that are already closed.
+type channelTypeCounters struct {
+	mtx   sync.Mutex
+	types map[string]*statCounters
+}
+
+func (t *channelTypeCounters) get(typ string, parent *statCounters) *statCounters {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s := t.types[typ]
+	if s == nil {
+		if t.types == nil {
+			t.types = make(map[string]*statCounters)
+		}
+		s = &statCounters{parent: parent}
+		t.types[typ] = s
+	}
+	return s
+}
+
+func (t *channelTypeCounters) traffic() map[string]TrafficStats {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	m := make(map[string]TrafficStats, len(t.types))
+	for typ, s := range t.types {
+		m[typ] = s.traffic()
+	}
+	return m
+}
+
+// Stats returns the statistics of the channel.
+func (c *Channel) Stats() ChannelStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return ChannelStats{
+		TrafficStats:  c.stats.traffic(),
+		InFlight:      len(c.writeBuffer),
+		Buffered:      len(c.readBuffer),
+		BufferedBytes: c.iBufferedBytes,
+	}
+}
+
+// Stats returns the statistics of the exchange.
+func (x *Exchange) Stats() ExchangeStats {
+	s := ExchangeStats{
+		TrafficStats: x.stats.traffic(),
+		Handshakes:   atomic.LoadUint64(&x.stats.handshakes),
+		PathSwitches: atomic.LoadUint64(&x.stats.pathSwitches),
+		SmoothedRTT:  x.addressBook.ActiveLatency(),
+	}
+
+	for _, c := range x.channels.All() {
+		cs := c.Stats()
+		s.Channels++
+		s.InFlight += cs.InFlight
+		s.Buffered += cs.Buffered
+	}
+
+	return s
+}
+
+// ChannelTraffic returns the traffic of the channels of the exchange per channel
+// type. The counters include the channels that are already closed.
+func (x *Exchange) ChannelTraffic() map[string]TrafficStats {
+	return x.channelStats.traffic()
+}
+
+// Stats returns the statistics of the endpoint.
+func (e *Endpoint) Stats() EndpointStats {
+	e.mtx.Lock()
+	exchanges := e.exchanges()
+	e.mtx.Unlock()
+
+	s := EndpointStats{
+		TrafficStats: e.stats.traffic(),
+		Handshakes:   atomic.LoadUint64(&e.stats.handshakes),
+		PathSwitches: atomic.LoadUint64(&e.stats.pathSwitches),
+	}
+
+	for _, x := range exchanges {
+		xs := x.Stats()
+		s.Exchanges++
+		s.Channels += xs.Channels
+		s.InFlight += xs.InFlight
+		s.Buffered += xs.Buffered
+	}
+
+	return s
+}
@@ -0,0 +1,68 @@
+package e3x
+
+import (
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	A, B := openMuxPair(t)
+	if A == nil {
+		return
+	}
+	defer A.Close()
+	defer B.Close()
+
+	B.Mux().HandleFunc("echo", true, func(c *Channel) {
+		defer c.Close()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			if err = c.WritePacket(pkt); err != nil {
+				return
+			}
+		}
+	})
+
+	c, err := A.Open(B.mustLocalIdentity(t), "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	for i := 0; i < 3; i++ {
+		if !assert.NoError(c.WritePacket(lob.New([]byte("hello")))) {
+			return
+		}
+		if _, err := c.ReadPacket(); !assert.NoError(err) {
+			return
+		}
+	}
+
+	cs := c.Stats()
+	assert.Equal(uint64(3), cs.PacketsSent)
+	assert.Equal(uint64(15), cs.BytesSent)
+	assert.Equal(uint64(3), cs.PacketsReceived)
+	assert.Equal(uint64(15), cs.BytesReceived)
+	assert.Equal(0, cs.Buffered)
+
+	xs := c.Exchange().Stats()
+	assert.Equal(cs.TrafficStats, xs.TrafficStats)
+	assert.Equal(cs.TrafficStats, c.Exchange().ChannelTraffic()["echo"])
+	assert.Equal(1, xs.Channels)
+	assert.True(xs.Handshakes > 0)
+	assert.True(xs.SmoothedRTT > 0)
+
+	es := A.Stats()
+	assert.Equal(xs.TrafficStats, es.TrafficStats)
+	assert.Equal(1, es.Exchanges)
+	assert.Equal(1, es.Channels)
+	assert.Equal(xs.Handshakes, es.Handshakes)
+}
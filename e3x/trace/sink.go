@@ -0,0 +1,135 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink receives the trace events. Emit may be called concurrently and must not
+// block.
+type Sink interface {
+	Emit(e Event)
+}
+
+// SinkFunc calls a function for each event.
+type SinkFunc func(e Event)
+
+func (f SinkFunc) Emit(e Event) { f(e) }
+
+// WriterSink writes the events as JSON lines to w. Once encoding or writing
+// an event fails all the following events are dropped.
+func WriterSink(w io.Writer) Sink {
+	return &writerSink{enc: json.NewEncoder(w)}
+}
+
+type writerSink struct {
+	mtx    sync.Mutex
+	enc    *json.Encoder
+	err    error
+	closed bool
+}
+
+func (s *writerSink) Emit(e Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.err != nil || s.closed {
+		return // drop
+	}
+
+	s.err = s.enc.Encode(&e)
+}
+
+// Err returns the error which made the sink drop its events.
+func (s *writerSink) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// FileSink writes the events as JSON lines to a file. Events emitted after
+// Close are dropped.
+type FileSink struct {
+	writerSink
+	f *os.File
+}
+
+// CreateFile creates (or truncates) the file name and returns a sink which
+// writes to it.
+func CreateFile(name string) (*FileSink, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{writerSink{enc: json.NewEncoder(f)}, f}, nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.f.Close()
+}
+
+// Ring keeps the most recent events in memory.
+type Ring struct {
+	mtx    sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewRing returns a ring which keeps the last n events.
+func NewRing(n int) *Ring {
+	if n <= 0 {
+		panic("ring size must be positive")
+	}
+	return &Ring{events: make([]Event, n)}
+}
+
+func (r *Ring) Emit(e Event) {
+	r.mtx.Lock()
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+	r.mtx.Unlock()
+}
+
+// Events returns the kept events, oldest first.
+func (r *Ring) Events() []Event {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if !r.full {
+		return append([]Event(nil), r.events[:r.next]...)
+	}
+
+	events := make([]Event, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	events = append(events, r.events[:r.next]...)
+	return events
+}
+
+// ChanSink sends the events to c. Events are dropped when c is full.
+func ChanSink(c chan<- Event) Sink {
+	return chanSink(c)
+}
+
+type chanSink chan<- Event
+
+func (c chanSink) Emit(e Event) {
+	select {
+	case c <- e:
+	default:
+	}
+}
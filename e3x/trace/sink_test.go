@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	assert := assert.New(t)
+
+	var ring = NewRing(3)
+	for i := 1; i <= 5; i++ {
+		ring.Emit(Event{ID: ID(i)})
+	}
+
+	var ids []ID
+	for _, e := range ring.Events() {
+		ids = append(ids, e.ID)
+	}
+	assert.Equal(3, len(ids))
+	assert.True(ids[0] == 3 && ids[1] == 4 && ids[2] == 5, "ids=%v", ids)
+}
+
+func TestWriterSink(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	WriterSink(&buf).Emit(Event{ID: 1, Type: "endpoint.new", Info: Info{"hashname": "abcd"}})
+
+	var e map[string]interface{}
+	if assert.NoError(json.Unmarshal(buf.Bytes(), &e)) {
+		assert.Equal("endpoint.new", e["ty"])
+		assert.Equal(map[string]interface{}{"hashname": "abcd"}, e["in"])
+	}
+}
+
+func TestWriterSinkErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &failingWriter{}
+	sink := WriterSink(w)
+	sink.Emit(Event{Type: "a"})
+	sink.Emit(Event{Type: "b"}) // dropped
+
+	assert.Equal(1, w.writes)
+	assert.Equal(errDiskFull, sink.(*writerSink).Err())
+}
+
+func TestFileSinkClose(t *testing.T) {
+	assert := assert.New(t)
+
+	sink, err := CreateFile(filepath.Join(t.TempDir(), "trace.json"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	sink.Emit(Event{Type: "a"})
+	assert.NoError(sink.Close())
+	assert.NoError(sink.Close())
+
+	sink.Emit(Event{Type: "b"}) // dropped
+	assert.NoError(sink.Err())
+}
+
+var errDiskFull = errors.New("disk full")
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errDiskFull
+}
+
+func TestChanSink(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		c    = make(chan Event, 1)
+		sink = ChanSink(c)
+	)
+
+	sink.Emit(Event{Type: "a"})
+	sink.Emit(Event{Type: "b"}) // dropped; the channel is full
+
+	assert.Equal("a", (<-c).Type)
+	select {
+	case e := <-c:
+		t.Errorf("unexpected event %q", e.Type)
+	default:
+	}
+}
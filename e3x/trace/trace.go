@@ -0,0 +1,41 @@
+// Package trace defines the trace events of endpoints, exchanges, channels and
+// packets and the sinks which receive them.
+//
+//   ring := trace.NewRing(4096)
+//   e, err := e3x.Open(e3x.Trace(ring, 0.1))
+//
+// Events are correlated by the IDs in their info (endpoint_id, exchange_id,
+// channel_id, packet_id). Spans (handshakes, dials, channel lifetimes) emit a
+// start and an end event with the same span ID.
+package trace
+
+import (
+	"time"
+)
+
+// ID identifies an endpoint, an exchange, a channel, a packet, a span or an
+// event.
+type ID uint64
+
+// Info is the payload of an event.
+type Info map[string]interface{}
+
+// Phase marks the start and end events of a span.
+type Phase string
+
+const (
+	PhaseStart Phase = "start"
+	PhaseEnd   Phase = "end"
+)
+
+// Event is a single trace event.
+type Event struct {
+	ID       ID            `json:"id"`
+	Type     string        `json:"ty"`
+	Time     time.Time     `json:"at"`
+	Span     ID            `json:"sp,omitempty"`
+	Phase    Phase         `json:"ph,omitempty"`
+	Duration time.Duration `json:"du,omitempty"` // only on end events
+	Error    string        `json:"er,omitempty"` // only on end events
+	Info     interface{}   `json:"in,omitempty"`
+}
@@ -2,6 +2,7 @@ package e3x
 
 import (
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
 
@@ -23,6 +24,12 @@ func (m *MockExchange) deliverPacket(pkt *lob.Packet, dst *Pipe) error {
 	return args.Error(0)
 }
 
+func (m *MockExchange) pathFeedback(loss bool) {}
+
+func (m *MockExchange) resendTimeout() time.Duration {
+	return time.Second
+}
+
 func (m *MockExchange) RemoteIdentity() *Identity {
 	args := m.Called()
 	return args.Get(0).(*Identity)
@@ -0,0 +1,82 @@
+package lob
+
+import (
+	"crypto/rand"
+	"io"
+
+	"github.com/telehash/gogotelehash/internal/util/chacha20"
+)
+
+// Cloaking
+//
+// https://github.com/telehash/telehash.org/blob/v3/v3/lob/cloaking.md
+
+// cloakKey is the SHA-256 digest of "telehash".
+var cloakKey = [chacha20.KeySize]byte{
+	0xd7, 0xf0, 0xe5, 0x55, 0x54, 0x62, 0x41, 0xb2,
+	0xa9, 0x44, 0xec, 0xd6, 0xd0, 0xde, 0x66, 0x85,
+	0x6a, 0xc5, 0x0b, 0x0b, 0xab, 0xa7, 0x6a, 0x6f,
+	0x5a, 0x47, 0x82, 0x95, 0x6c, 0xa9, 0x45, 0x9a,
+}
+
+// CloakOverhead is the number of bytes added by each round of cloaking.
+const CloakOverhead = chacha20.NonceSize
+
+// Cloak cloaks the encoded packet p rounds times and returns the result in a
+// new slice. Each round prefixes the packet with a random nonce (which never
+// starts with a 0x00 byte) and masks it with ChaCha20.
+func Cloak(p []byte, rounds int) ([]byte, error) {
+	if rounds <= 0 {
+		return p, nil
+	}
+
+	var (
+		out   = make([]byte, len(p)+rounds*CloakOverhead)
+		nonce [chacha20.NonceSize]byte
+		n     = len(p)
+	)
+
+	copy(out[len(out)-n:], p)
+
+	for i := 0; i < rounds; i++ {
+		var (
+			start = len(out) - n
+			body  = out[start:]
+		)
+
+		for {
+			_, err := io.ReadFull(rand.Reader, nonce[:])
+			if err != nil {
+				return nil, err
+			}
+			if nonce[0] != 0 {
+				break
+			}
+		}
+
+		chacha20.XORKeyStream(body, body, &nonce, &cloakKey)
+		copy(out[start-CloakOverhead:], nonce[:])
+		n += CloakOverhead
+	}
+
+	return out, nil
+}
+
+// Uncloak removes all the rounds of cloaking from p. p is modified in place
+// and the uncloaked packet is returned. Packets that are not cloaked are
+// returned as-is.
+func Uncloak(p []byte) ([]byte, error) {
+	var nonce [chacha20.NonceSize]byte
+
+	for len(p) > 0 && p[0] != 0 {
+		if len(p) < CloakOverhead+2 {
+			return nil, ErrInvalidPacket
+		}
+
+		copy(nonce[:], p)
+		p = p[CloakOverhead:]
+		chacha20.XORKeyStream(p, p, &nonce, &cloakKey)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,45 @@
+package lob
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestCloak(t *testing.T) {
+	assert := assert.New(t)
+
+	var pkt = append([]byte{0x00, 0x01, 0x3a}, bytes.Repeat([]byte{'x'}, 100)...)
+
+	for rounds := 0; rounds < 4; rounds++ {
+		for i := 0; i < 64; i++ {
+			cloaked, err := Cloak(pkt, rounds)
+			if !assert.NoError(err) {
+				return
+			}
+
+			assert.Equal(len(pkt)+rounds*CloakOverhead, len(cloaked))
+			if rounds > 0 {
+				assert.NotEqual(byte(0), cloaked[0])
+				assert.False(bytes.Contains(cloaked, pkt[:16]))
+			}
+
+			uncloaked, err := Uncloak(cloaked)
+			if assert.NoError(err) {
+				assert.Equal(pkt, uncloaked)
+			}
+		}
+	}
+}
+
+func TestUncloakInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Uncloak([]byte{0x01, 0x02, 0x03})
+	assert.Equal(ErrInvalidPacket, err)
+
+	p, err := Uncloak(nil)
+	assert.NoError(err)
+	assert.Empty(p)
+}
@@ -1,7 +1,7 @@
 package bridge
 
 import (
-	"io"
+	"encoding/hex"
 	"sync"
 	"time"
 
@@ -16,6 +16,9 @@ type Config struct {
 	DisableRouter bool
 	AllowPeer     func(from, to hashname.H) bool
 	AllowConnect  func(from, via hashname.H) bool
+
+	// DisablePunching disables the upgrade of relayed exchanges to direct paths.
+	DisablePunching bool
 }
 
 type Bridge interface {
@@ -24,15 +27,13 @@ type Bridge interface {
 }
 
 type module struct {
-	mtx             sync.RWMutex
-	e               *e3x.Endpoint
-	config          Config
-	peerListener    *e3x.Listener
-	connectListener *e3x.Listener
-	pending         map[hashname.H]*pendingIntroduction
-	packetRoutes    map[cipherset.Token]*e3x.Exchange
-	connections     map[*e3x.Exchange]map[cipherset.Token]*connection
-	log             *logs.Logger
+	mtx          sync.RWMutex
+	e            *e3x.Endpoint
+	config       Config
+	pending      map[hashname.H]*pendingIntroduction
+	packetRoutes map[cipherset.Token]*e3x.Exchange
+	connections  map[*e3x.Exchange]map[cipherset.Token]*connection
+	log          *logs.Logger
 }
 
 type pendingIntroduction struct {
@@ -74,9 +75,10 @@ func newBridge(e *e3x.Endpoint, config Config) *module {
 }
 
 func (mod *module) Init() error {
-	mod.log = logs.Module("bridge").From(mod.e.LocalHashname())
+	mod.log = mod.e.Log().Module("bridge")
 
 	mod.e.DefaultExchangeHooks().Register(e3x.ExchangeHook{
+		OnOpened:     mod.on_exchange_opened,
 		OnClosed:     mod.on_exchange_closed,
 		OnDropPacket: mod.on_dropped_packet,
 	})
@@ -85,18 +87,19 @@ func (mod *module) Init() error {
 }
 
 func (mod *module) Start() error {
-	mod.peerListener = mod.e.Listen("peer", false)
-	mod.connectListener = mod.e.Listen("connect", false)
-
-	go mod.acceptPeerChannels()
-	go mod.acceptConnectChannels()
+	mux := mod.e.Mux()
+	mux.HandleFunc("peer", false, mod.handle_peer)
+	mux.HandleFunc("connect", false, mod.handle_connect)
+	mux.HandleFunc("punch", true, mod.handle_punch)
 
 	return nil
 }
 
 func (mod *module) Stop() error {
-	mod.peerListener.Close()
-	mod.connectListener.Close()
+	mux := mod.e.Mux()
+	mux.Remove("peer")
+	mux.Remove("connect")
+	mux.Remove("punch")
 
 	return nil
 }
@@ -172,32 +175,6 @@ func (i *pendingIntroduction) resolve(x *e3x.Exchange, err error) {
 	i.mod.mtx.Unlock()
 }
 
-func (mod *module) acceptPeerChannels() {
-	for {
-		c, err := mod.peerListener.AcceptChannel()
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			continue
-		}
-		go mod.handle_peer(c)
-	}
-}
-
-func (mod *module) acceptConnectChannels() {
-	for {
-		c, err := mod.connectListener.AcceptChannel()
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			continue
-		}
-		go mod.handle_connect(c)
-	}
-}
-
 func (mod *module) RouteToken(token cipherset.Token, source *e3x.Exchange) {
 	mod.mtx.Lock()
 	mod.packetRoutes[token] = source
@@ -347,10 +324,10 @@ func (mod *module) forwardMessage(e *e3x.Endpoint, x *e3x.Exchange, msg []byte,
 	_, err := dst.Write(buf)
 	buf.Free()
 	if err != nil {
-		mod.log.To(ex.RemoteHashname()).Printf("\x1B[35mFWD %x %s error=%s\x1B[0m", token, dst.RemoteAddr(), err)
+		mod.log.To(ex.RemoteHashname()).Warn("Failed to forward packet", "token", hex.EncodeToString(token[:]), "addr", dst.RemoteAddr().String(), "err", err)
 		return nil
 	} else {
-		mod.log.To(ex.RemoteHashname()).Printf("\x1B[35mFWD %x %s\x1B[0m", token, dst.RemoteAddr())
+		mod.log.To(ex.RemoteHashname()).Debug("Forwarded packet", "token", hex.EncodeToString(token[:]), "addr", dst.RemoteAddr().String())
 		return e3x.ErrStopPropagation
 	}
 }
@@ -8,14 +8,11 @@ import (
 
 	"github.com/telehash/gogotelehash/e3x"
 	"github.com/telehash/gogotelehash/internal/lob"
-	"github.com/telehash/gogotelehash/internal/util/logs"
 	"github.com/telehash/gogotelehash/transports"
 	"github.com/telehash/gogotelehash/transports/fw"
 	"github.com/telehash/gogotelehash/transports/udp"
 )
 
-var log = logs.Module("test")
-
 func TestBridge(t *testing.T) {
 	// given:
 	// A <-> B exchange
@@ -115,13 +112,9 @@ func TestBridge(t *testing.T) {
 		Bident = Bident.AddPathCandiate(addr)
 	}
 
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
-
 	// blacklist A
 	blacklist = append(blacklist, Aident.Addresses()...)
-	log.Println("\x1B[32mblacklist:\x1B[0m", blacklist)
-
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
+	t.Logf("blacklist: %v", blacklist)
 
 	_, err = R.Dial(Bident)
 	assert.NoError(err)
@@ -130,10 +123,8 @@ func TestBridge(t *testing.T) {
 	ABex, err := A.Dial(Bident)
 	assert.NoError(err)
 
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
-	log.Printf("ab-local-token  = %x", ABex.LocalToken())
-	log.Printf("ab-remote-token = %x", ABex.RemoteToken())
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
+	t.Logf("ab-local-token  = %x", ABex.LocalToken())
+	t.Logf("ab-remote-token = %x", ABex.RemoteToken())
 
 	{
 		ch, err := B.Open(Aident, "ping", true)
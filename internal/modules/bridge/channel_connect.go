@@ -8,11 +8,8 @@ import (
 	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
-	"github.com/telehash/gogotelehash/internal/util/logs"
 )
 
-var mainLog = logs.Module("peers")
-
 func (mod *module) connect(ex *e3x.Exchange, inner *bufpool.Buffer) error {
 	ch, err := ex.Open("connect", false)
 	if err != nil {
@@ -64,38 +64,38 @@ func (mod *module) handle_peer(ch *e3x.Channel) {
 
 	// MUST allow router role
 	if mod.config.DisableRouter {
-		log.Println("drop: router disabled")
+		log.Debug("Dropped peer request", "reason", "router disabled")
 		return
 	}
 
 	pkt, err := ch.ReadPacket()
 	if err != nil {
-		log.Printf("drop: failed to read packet: %s", err)
+		log.Debug("Dropped peer request", "reason", "failed to read packet", "err", err)
 		return
 	}
 
 	peerStr, ok := pkt.Header().GetString("peer")
 	if !ok {
-		log.Printf("drop: no peer in packet")
+		log.Debug("Dropped peer request", "reason", "no peer in packet")
 		return
 	}
 	peer := hashname.H(peerStr)
 
 	// MUST have link to either endpoint
 	if mod.e.GetExchange(ch.RemoteHashname()) == nil && mod.e.GetExchange(peer) == nil {
-		log.Printf("drop: no link to either peer")
+		log.Debug("Dropped peer request", "reason", "no link to either peer")
 		return
 	}
 
 	// MUST pass firewall
 	if mod.config.AllowPeer != nil && !mod.config.AllowPeer(ch.RemoteHashname(), peer) {
-		log.Printf("drop: blocked by firewall")
+		log.Debug("Dropped peer request", "reason", "blocked by firewall")
 		return
 	}
 
 	ex := mod.e.GetExchange(peer)
 	if ex == nil {
-		log.Printf("drop: no exchange to target")
+		log.Debug("Dropped peer request", "reason", "no exchange to target")
 		// resolve?
 		return
 	}
@@ -0,0 +1,189 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+const (
+	cPunchBurstSize     = 10
+	cPunchBurstInterval = 50 * time.Millisecond
+)
+
+// on_exchange_opened starts hole punching for exchanges that were opened
+// through a router. The endpoint with the lowest hashname coordinates.
+func (mod *module) on_exchange_opened(e *e3x.Endpoint, x *e3x.Exchange) error {
+	if mod.config.DisablePunching {
+		return nil
+	}
+
+	if !isRelayedPipe(x.ActivePipe()) {
+		return nil
+	}
+
+	if mod.e.LocalHashname() > x.RemoteHashname() {
+		return nil
+	}
+
+	go mod.punch(x)
+	return nil
+}
+
+// punch coordinates the hole punching with the remote endpoint:
+//
+//  1. send our paths
+//  2. receive the remote paths (measuring the rtt)
+//  3. send sync and start probing after rtt/2
+//
+// The remote endpoint starts probing when it receives the sync packet.
+func (mod *module) punch(x *e3x.Exchange) {
+	log := mod.log.To(x.RemoteHashname())
+
+	c, err := x.Open("punch", true)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+
+	start := time.Now()
+
+	pkt := &lob.Packet{}
+	pkt.Header().Set("paths", mod.punchablePaths())
+	err = c.WritePacket(pkt)
+	if err != nil {
+		log.Warn("Punch failed to send paths", "err", err)
+		return
+	}
+
+	pkt, err = c.ReadPacket()
+	if err != nil {
+		log.Warn("Punch failed to receive paths", "err", err)
+		return
+	}
+
+	rtt := time.Since(start)
+
+	paths := decodePunchPaths(pkt)
+	if len(paths) == 0 {
+		return
+	}
+
+	pkt = &lob.Packet{}
+	pkt.Header().SetBool("sync", true)
+	err = c.WritePacket(pkt)
+	if err != nil {
+		log.Warn("Punch failed to send sync", "err", err)
+		return
+	}
+
+	time.Sleep(rtt / 2)
+	mod.burst(x, paths)
+}
+
+func (mod *module) handle_punch(c *e3x.Channel) {
+	defer c.Close()
+
+	log := mod.log.From(c.RemoteHashname())
+
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		log.Warn("Punch failed to receive paths", "err", err)
+		return
+	}
+
+	paths := decodePunchPaths(pkt)
+
+	pkt = &lob.Packet{}
+	pkt.Header().Set("paths", mod.punchablePaths())
+	err = c.WritePacket(pkt)
+	if err != nil {
+		log.Warn("Punch failed to send paths", "err", err)
+		return
+	}
+
+	pkt, err = c.ReadPacket()
+	if err != nil {
+		log.Warn("Punch failed to receive sync", "err", err)
+		return
+	}
+
+	if sync, _ := pkt.Header().GetBool("sync"); !sync || len(paths) == 0 {
+		return
+	}
+
+	mod.burst(c.Exchange(), paths)
+}
+
+// burst sends handshakes to all the remote paths until a direct path is used.
+func (mod *module) burst(x *e3x.Exchange, paths []net.Addr) {
+	log := mod.log.To(x.RemoteHashname())
+
+	for i := 0; i < cPunchBurstSize; i++ {
+		if pipe := x.ActivePipe(); pipe != nil && !isRelayedPipe(pipe) {
+			log.Debug("Punching path", "addr", pipe.RemoteAddr().String())
+			return
+		}
+
+		for _, addr := range paths {
+			x.ProbePath(addr)
+		}
+
+		time.Sleep(cPunchBurstInterval)
+	}
+}
+
+func (mod *module) punchablePaths() []net.Addr {
+	var paths []net.Addr
+
+	for _, addr := range e3x.TransportsFromEndpoint(mod.e).LocalAddresses() {
+		if _, ok := addr.(*peerAddr); ok {
+			continue
+		}
+		paths = append(paths, addr)
+	}
+
+	return paths
+}
+
+func decodePunchPaths(pkt *lob.Packet) []net.Addr {
+	v, _ := pkt.Header().Get("paths")
+	s, _ := v.([]interface{})
+
+	var paths []net.Addr
+	for _, desc := range s {
+		data, err := json.Marshal(desc)
+		if err != nil {
+			continue
+		}
+
+		addr, err := transports.DecodeAddr(data)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := addr.(*peerAddr); ok {
+			continue
+		}
+
+		paths = append(paths, addr)
+	}
+
+	return paths
+}
+
+func isRelayedPipe(pipe *e3x.Pipe) bool {
+	if pipe == nil {
+		return false
+	}
+	_, ok := pipe.RemoteAddr().(*peerAddr)
+	return ok
+}
@@ -0,0 +1,288 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/dgram"
+)
+
+func init() {
+	transports.RegisterAddr(&simAddr{})
+}
+
+func TestHolePunching(t *testing.T) {
+	// given:
+	// A and B are behind address restricted NATs
+	// R is publicly reachable
+	// A <-> R and B <-> R exchanges
+	//
+	// when:
+	// A dials B through R
+	//
+	// then:
+	// A and B should upgrade to a direct path.
+
+	assert := assert.New(t)
+
+	var (
+		sim   = newSimNetwork()
+		hostA = sim.Host("203.0.113.1:40001", true)
+		hostB = sim.Host("203.0.113.2:40002", true)
+		hostR = sim.Host("198.51.100.1:42424", false)
+	)
+
+	A, err := e3x.Open(e3x.Log(nil), e3x.Transport(simConfig{hostA}), Module(Config{}))
+	assert.NoError(err)
+	B, err := e3x.Open(e3x.Log(nil), e3x.Transport(simConfig{hostB}), Module(Config{}))
+	assert.NoError(err)
+	R, err := e3x.Open(e3x.Log(nil), e3x.Transport(simConfig{hostR}), Module(Config{}))
+	assert.NoError(err)
+
+	Rident, err := R.LocalIdentity()
+	assert.NoError(err)
+	Bident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	// B is only reachable through R
+	{
+		addr, err := transports.ResolveAddr("peer", string(R.LocalHashname()))
+		assert.NoError(err)
+		Bident, err = e3x.NewIdentity(Bident.Keys(), hashname.PartsFromKeys(Bident.Keys()), []net.Addr{addr})
+		assert.NoError(err)
+	}
+
+	_, err = A.Dial(Rident)
+	assert.NoError(err)
+	_, err = B.Dial(Rident)
+	assert.NoError(err)
+
+	ABex, err := A.Dial(Bident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var BAex *e3x.Exchange
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		BAex = B.GetExchange(A.LocalHashname())
+		if BAex != nil && !isRelayedPipe(ABex.ActivePipe()) && !isRelayedPipe(BAex.ActivePipe()) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if assert.NotNil(BAex) {
+		assert.Equal(hostB.public.String(), ABex.ActivePath().String())
+		assert.Equal(hostA.public.String(), BAex.ActivePath().String())
+	}
+
+	{
+		ch, err := A.Open(Bident, "ping", true)
+		assert.NoError(err)
+		if ch != nil {
+			ch.Kill()
+		}
+	}
+
+	assert.True(sim.Filtered() > 0, "expected the NATs to filter unsolicited packets")
+
+	assert.NoError(A.Close())
+	assert.NoError(B.Close())
+	assert.NoError(R.Close())
+}
+
+// simNetwork is a minimal network simulator. Hosts marked as natted emulate an
+// address restricted cone NAT: packets are only accepted from IPs the host
+// has sent packets to before.
+type simNetwork struct {
+	mtx      sync.Mutex
+	hosts    map[string]*simHost
+	filtered int
+}
+
+type simHost struct {
+	net    *simNetwork
+	public *simAddr
+	natted bool
+
+	mtx       sync.Mutex
+	permitted map[string]bool
+	queue     chan simPacket
+	closed    bool
+}
+
+type simPacket struct {
+	src  *simAddr
+	data []byte
+}
+
+type simConfig struct {
+	host *simHost
+}
+
+type simAddr struct {
+	IP   string
+	Port int
+}
+
+func newSimNetwork() *simNetwork {
+	return &simNetwork{hosts: make(map[string]*simHost)}
+}
+
+func (n *simNetwork) Host(public string, natted bool) *simHost {
+	host, port, err := net.SplitHostPort(public)
+	if err != nil {
+		panic(err)
+	}
+
+	var p int
+	fmt.Sscanf(port, "%d", &p)
+
+	h := &simHost{
+		net:       n,
+		public:    &simAddr{IP: host, Port: p},
+		natted:    natted,
+		permitted: make(map[string]bool),
+		queue:     make(chan simPacket, 1024),
+	}
+
+	n.mtx.Lock()
+	n.hosts[h.public.String()] = h
+	n.mtx.Unlock()
+
+	return h
+}
+
+func (n *simNetwork) Filtered() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.filtered
+}
+
+func (n *simNetwork) route(src, dst *simAddr, data []byte) {
+	n.mtx.Lock()
+	h := n.hosts[dst.String()]
+	n.mtx.Unlock()
+
+	if h == nil {
+		return
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	if h.natted && !h.permitted[src.IP] {
+		n.mtx.Lock()
+		n.filtered++
+		n.mtx.Unlock()
+		return
+	}
+
+	select {
+	case h.queue <- simPacket{src, data}:
+	default:
+	}
+}
+
+func (c simConfig) Open() (transports.Transport, error) {
+	return dgram.Wrap(c.host)
+}
+
+func (h *simHost) Addrs() []net.Addr {
+	return []net.Addr{h.public}
+}
+
+func (h *simHost) NormalizeAddr(addr net.Addr) (dgram.Addr, error) {
+	if a, ok := addr.(*simAddr); ok {
+		return a, nil
+	}
+	return nil, transports.ErrInvalidAddr
+}
+
+func (h *simHost) Read(b []byte) (int, dgram.Addr, error) {
+	pkt, ok := <-h.queue
+	if !ok {
+		return 0, nil, io.EOF
+	}
+	n := copy(b, pkt.data)
+	return n, pkt.src, nil
+}
+
+func (h *simHost) Write(b []byte, addr dgram.Addr) (int, error) {
+	dst, ok := addr.(*simAddr)
+	if !ok {
+		return 0, transports.ErrInvalidAddr
+	}
+
+	h.mtx.Lock()
+	if h.closed {
+		h.mtx.Unlock()
+		return 0, io.EOF
+	}
+	h.permitted[dst.IP] = true
+	h.mtx.Unlock()
+
+	data := make([]byte, len(b))
+	copy(data, b)
+	h.net.route(h.public, dst, data)
+
+	return len(b), nil
+}
+
+func (h *simHost) Close() error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	return nil
+}
+
+func (a *simAddr) Network() string { return "sim" }
+func (a *simAddr) String() string  { return net.JoinHostPort(a.IP, fmt.Sprint(a.Port)) }
+func (a *simAddr) Key() interface{} { return a.String() }
+
+func (a *simAddr) MarshalJSON() ([]byte, error) {
+	var desc = struct {
+		Type string `json:"type"`
+		IP   string `json:"ip"`
+		Port int    `json:"port"`
+	}{
+		Type: a.Network(),
+		IP:   a.IP,
+		Port: a.Port,
+	}
+	return json.Marshal(&desc)
+}
+
+func (a *simAddr) UnmarshalJSON(p []byte) error {
+	var desc struct {
+		Type string `json:"type"`
+		IP   string `json:"ip"`
+		Port int    `json:"port"`
+	}
+	err := json.Unmarshal(p, &desc)
+	if err != nil {
+		return err
+	}
+	a.IP = desc.IP
+	a.Port = desc.Port
+	return nil
+}
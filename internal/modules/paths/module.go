@@ -16,7 +16,6 @@ const moduleKey = "paths"
 
 type module struct {
 	endpoint *e3x.Endpoint
-	listener *e3x.Listener
 }
 
 func Module() e3x.EndpointOption {
@@ -33,17 +32,16 @@ func (mod *module) Init() error {
 		OnOpened: mod.onNewLink,
 	})
 
-	mod.listener = mod.endpoint.Listen("path", false)
 	return nil
 }
 
 func (mod *module) Start() error {
-	go mod.handlePathRequests()
+	mod.endpoint.Mux().HandleFunc("path", false, mod.handlePathRequest)
 	return nil
 }
 
 func (mod *module) Stop() error {
-	mod.listener.Close()
+	mod.endpoint.Mux().Remove("path")
 	return nil
 }
 
@@ -64,19 +62,6 @@ func (mod *module) onNewLink(e *e3x.Endpoint, x *e3x.Exchange) error {
 	return nil
 }
 
-func (mod *module) handlePathRequests() {
-	for {
-		c, err := mod.listener.AcceptChannel()
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			continue
-		}
-		go mod.handlePathRequest(c)
-	}
-}
-
 func (mod *module) negotiatePaths(x *e3x.Exchange) {
 	addrs := e3x.TransportsFromEndpoint(mod.endpoint).LocalAddresses()
 
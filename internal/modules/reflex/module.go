@@ -0,0 +1,232 @@
+// Package reflex discovers the addresses at which peers observe the local endpoint.
+//
+// Peers report the source address they see for us (much like STUN) over the
+// "reflex" channel. Addresses which are reported consistently by multiple peers
+// are added to the addresses of the endpoint transport. This makes them
+// available through e3x.Transports.LocalAddresses() and the paths module.
+package reflex
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/nat"
+)
+
+// Config for the reflex module. Typically the zero value is sufficient.
+type Config struct {
+	// MinObservers is the number of distinct peers that must report an address
+	// before it is used. Defaults to 2.
+	MinObservers int
+
+	// TTL is the duration for which an observation remains valid.
+	// Defaults to 10 minutes.
+	TTL time.Duration
+
+	// Interval is the time between two observation requests to the same peer.
+	// Defaults to 5 minutes.
+	Interval time.Duration
+}
+
+// Reflex exposes the discovered addresses.
+type Reflex interface {
+	// Addrs returns the addresses that were consistently observed by peers.
+	Addrs() []net.Addr
+}
+
+type moduleKeyType string
+
+const moduleKey = moduleKeyType("reflex")
+
+const channelType = "reflex"
+
+type module struct {
+	e      *e3x.Endpoint
+	config Config
+	table  *table
+
+	mtx   sync.Mutex
+	inner transports.Transport
+	timer *time.Timer
+}
+
+// Module registers the reflex module with an endpoint.
+func Module(config Config) e3x.EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		return e3x.RegisterModule(moduleKey, newModule(e, config))(e)
+	}
+}
+
+// FromEndpoint returns the reflex module for e.
+func FromEndpoint(e *e3x.Endpoint) Reflex {
+	mod := e.Module(moduleKey)
+	if mod == nil {
+		return nil
+	}
+	return mod.(*module)
+}
+
+func newModule(e *e3x.Endpoint, config Config) *module {
+	if config.MinObservers <= 0 {
+		config.MinObservers = 2
+	}
+	if config.TTL <= 0 {
+		config.TTL = 10 * time.Minute
+	}
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Minute
+	}
+
+	return &module{
+		e:      e,
+		config: config,
+		table:  newTable(config.MinObservers, config.TTL),
+	}
+}
+
+func (mod *module) Init() error {
+	e3x.TransportsFromEndpoint(mod.e).Wrap(func(c transports.Config) transports.Config {
+		return transportConfig{c, mod}
+	})
+
+	mod.e.DefaultExchangeHooks().Register(e3x.ExchangeHook{
+		OnOpened: mod.onOpened,
+	})
+
+	return nil
+}
+
+func (mod *module) Start() error {
+	mod.e.Mux().HandleFunc(channelType, false, mod.handleObservationRequest)
+
+	mod.mtx.Lock()
+	mod.timer = time.AfterFunc(mod.config.Interval, mod.observeAll)
+	mod.mtx.Unlock()
+
+	return nil
+}
+
+func (mod *module) Stop() error {
+	mod.mtx.Lock()
+	if mod.timer != nil {
+		mod.timer.Stop()
+		mod.timer = nil
+	}
+	mod.mtx.Unlock()
+
+	mod.e.Mux().Remove(channelType)
+	return nil
+}
+
+// Addrs returns the addresses that were consistently observed by peers.
+func (mod *module) Addrs() []net.Addr {
+	return mod.table.Addrs(time.Now())
+}
+
+func (mod *module) onOpened(e *e3x.Endpoint, x *e3x.Exchange) error {
+	go mod.observe(x)
+	return nil
+}
+
+func (mod *module) observeAll() {
+	for _, x := range mod.e.GetExchanges() {
+		go mod.observe(x)
+	}
+
+	mod.mtx.Lock()
+	if mod.timer != nil {
+		mod.timer.Reset(mod.config.Interval)
+	}
+	mod.mtx.Unlock()
+}
+
+// observe asks the peer of x for the address it sees us at.
+func (mod *module) observe(x *e3x.Exchange) {
+	c, err := x.Open(channelType, false)
+	if err != nil {
+		return
+	}
+	defer c.Kill()
+
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if err := c.WritePacket(&lob.Packet{}); err != nil {
+		return // ignore
+	}
+
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return // ignore
+	}
+
+	header, found := pkt.Header().Get("observed")
+	if !found {
+		return // ignore
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return // ignore
+	}
+
+	addr, err := transports.DecodeAddr(data)
+	if err != nil {
+		return // ignore
+	}
+
+	if !mod.isCandidate(addr) {
+		return // ignore
+	}
+
+	mod.table.Add(addr, x.RemoteHashname(), time.Now())
+}
+
+// isCandidate returns true when addr can be a public mapping of one of the
+// local addresses.
+func (mod *module) isCandidate(addr net.Addr) bool {
+	if _, ok := addr.(nat.Addr); !ok {
+		return false
+	}
+
+	mod.mtx.Lock()
+	inner := mod.inner
+	mod.mtx.Unlock()
+
+	if inner == nil {
+		return false
+	}
+
+	var supported bool
+	for _, local := range inner.Addrs() {
+		if transports.EqualAddr(local, addr) {
+			return false // not a mapped address
+		}
+		if local.Network() == addr.Network() {
+			supported = true
+		}
+	}
+
+	return supported
+}
+
+func (mod *module) handleObservationRequest(c *e3x.Channel) {
+	defer c.Kill()
+
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// report the address the request came from (not the active path of the
+	// exchange) and reply over the same pipe.
+	_, src, err := c.ReadPacketFrom()
+	if err != nil || src == nil {
+		return // ignore
+	}
+
+	pkt := &lob.Packet{}
+	pkt.Header().Set("observed", src.RemoteAddr())
+	c.WritePacketTo(pkt, src)
+}
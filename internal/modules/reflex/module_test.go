@@ -0,0 +1,82 @@
+package reflex
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+// natted reports a private address instead of the address the peers see, like
+// a transport behind a NAT.
+type natted struct {
+	transports.Transport
+	private string
+}
+
+func (t natted) Addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, addr := range t.Transport.Addrs() {
+		_, port, _ := net.SplitHostPort(addr.String())
+		private, err := transports.ResolveAddr(addr.Network(), net.JoinHostPort(t.private, port))
+		if err == nil {
+			addrs = append(addrs, private)
+		}
+	}
+	return addrs
+}
+
+func TestObserveAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}),
+		Module(Config{MinObservers: 1}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}),
+		Module(Config{}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	// A believes it is at 10.0.0.1 while B sees it at 127.0.0.1
+	mod := FromEndpoint(A).(*module)
+	mod.mtx.Lock()
+	public := mod.inner.Addrs()[0]
+	mod.inner = natted{mod.inner, "10.0.0.1"}
+	mod.mtx.Unlock()
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = A.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var observed []net.Addr
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if observed = FromEndpoint(A).Addrs(); len(observed) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if assert.Len(observed, 1) {
+		assert.True(transports.EqualAddr(public, observed[0]), "observed=%s", observed[0])
+	}
+}
@@ -0,0 +1,90 @@
+package reflex
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// table keeps track of the addresses reported by peers.
+type table struct {
+	minObservers int
+	ttl          time.Duration
+
+	mtx     sync.Mutex
+	entries []*observation
+}
+
+type observation struct {
+	addr      net.Addr
+	observers map[hashname.H]time.Time
+}
+
+func newTable(minObservers int, ttl time.Duration) *table {
+	return &table{minObservers: minObservers, ttl: ttl}
+}
+
+// Add records that peer observed us at addr.
+func (t *table) Add(addr net.Addr, peer hashname.H, now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.expire(now)
+
+	for _, o := range t.entries {
+		if transports.EqualAddr(o.addr, addr) {
+			o.observers[peer] = now
+			return
+		}
+	}
+
+	t.entries = append(t.entries, &observation{
+		addr:      addr,
+		observers: map[hashname.H]time.Time{peer: now},
+	})
+}
+
+// Addrs returns the addresses that were reported by enough peers.
+func (t *table) Addrs(now time.Time) []net.Addr {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.expire(now)
+
+	var addrs []net.Addr
+	for _, o := range t.entries {
+		if len(o.observers) >= t.minObservers {
+			addrs = append(addrs, o.addr)
+		}
+	}
+
+	return addrs
+}
+
+func (t *table) expire(now time.Time) {
+	var (
+		deadline = now.Add(-t.ttl)
+		entries  = t.entries[:0]
+	)
+
+	for _, o := range t.entries {
+		for peer, at := range o.observers {
+			if at.Before(deadline) {
+				delete(o.observers, peer)
+			}
+		}
+
+		if len(o.observers) > 0 {
+			entries = append(entries, o)
+		}
+	}
+
+	for i := len(entries); i < len(t.entries); i++ {
+		t.entries[i] = nil
+	}
+
+	t.entries = entries
+}
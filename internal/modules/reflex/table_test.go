@@ -0,0 +1,58 @@
+package reflex
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+	_ "github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestTableRequiresDistinctObservers(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		now  = time.Now()
+		tab  = newTable(2, time.Minute)
+		addr = mustResolve(t, "udp4", "198.51.100.7:42424")
+	)
+
+	tab.Add(addr, "a", now)
+	tab.Add(addr, "a", now)
+	assert.Empty(tab.Addrs(now))
+
+	tab.Add(mustResolve(t, "udp4", "198.51.100.7:42424"), "b", now)
+	if assert.Len(tab.Addrs(now), 1) {
+		assert.True(transports.EqualAddr(addr, tab.Addrs(now)[0]))
+	}
+}
+
+func TestTableExpiresObservations(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		now  = time.Now()
+		tab  = newTable(2, time.Minute)
+		addr = mustResolve(t, "udp4", "198.51.100.7:42424")
+	)
+
+	tab.Add(addr, "a", now)
+	tab.Add(addr, "b", now.Add(30*time.Second))
+	assert.Len(tab.Addrs(now.Add(30*time.Second)), 1)
+	assert.Empty(tab.Addrs(now.Add(61 * time.Second)))
+
+	tab.Add(addr, "c", now.Add(62*time.Second))
+	assert.Len(tab.Addrs(now.Add(62*time.Second)), 1)
+	assert.Empty(tab.Addrs(now.Add(91 * time.Second)))
+}
+
+func mustResolve(t *testing.T, network, str string) net.Addr {
+	addr, err := transports.ResolveAddr(network, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
@@ -0,0 +1,70 @@
+package reflex
+
+import (
+	"net"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+var (
+	_ transports.Config    = transportConfig{}
+	_ transports.Transport = (*transport)(nil)
+)
+
+// transportConfig wraps the endpoint transport and adds the observed
+// addresses to its Addrs.
+type transportConfig struct {
+	config transports.Config
+	mod    *module
+}
+
+type transport struct {
+	t   transports.Transport
+	mod *module
+}
+
+func (c transportConfig) Open() (transports.Transport, error) {
+	t, err := c.config.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mod.mtx.Lock()
+	c.mod.inner = t
+	c.mod.mtx.Unlock()
+
+	return &transport{t, c.mod}, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	addrs := t.t.Addrs()
+
+	for _, addr := range t.mod.Addrs() {
+		if !containsAddr(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	return t.t.Dial(addr)
+}
+
+func (t *transport) Accept() (net.Conn, error) {
+	return t.t.Accept()
+}
+
+func (t *transport) Close() error {
+	return t.t.Close()
+}
+
+func containsAddr(addrs []net.Addr, addr net.Addr) bool {
+	for _, x := range addrs {
+		if transports.EqualAddr(x, addr) {
+			return true
+		}
+	}
+	return false
+}
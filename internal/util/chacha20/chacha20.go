@@ -0,0 +1,96 @@
+// Package chacha20 implements the original ChaCha20 stream cipher with a
+// 64 bit nonce and a 64 bit block counter.
+package chacha20
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// KeySize is the size of a key in bytes.
+	KeySize = 32
+
+	// NonceSize is the size of a nonce in bytes.
+	NonceSize = 8
+
+	blockSize = 64
+)
+
+var sigma = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+
+// XORKeyStream crypts bytes from in to out using the given key and nonce. in
+// and out may be the same slice but otherwise should not overlap.
+func XORKeyStream(out, in []byte, nonce *[NonceSize]byte, key *[KeySize]byte) {
+	if len(out) < len(in) {
+		panic("chacha20: output smaller than input")
+	}
+
+	var (
+		state [16]uint32
+		block [blockSize]byte
+	)
+
+	copy(state[:4], sigma[:])
+	for i := 0; i < 8; i++ {
+		state[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	state[12] = 0
+	state[13] = 0
+	state[14] = binary.LittleEndian.Uint32(nonce[0:])
+	state[15] = binary.LittleEndian.Uint32(nonce[4:])
+
+	for len(in) > 0 {
+		core(&block, &state)
+
+		n := len(in)
+		if n > blockSize {
+			n = blockSize
+		}
+
+		for i := 0; i < n; i++ {
+			out[i] = in[i] ^ block[i]
+		}
+
+		in, out = in[n:], out[n:]
+
+		state[12]++
+		if state[12] == 0 {
+			state[13]++
+		}
+	}
+}
+
+func core(out *[blockSize]byte, in *[16]uint32) {
+	x := *in
+
+	for i := 0; i < 10; i++ {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+in[i])
+	}
+}
+
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = rotl(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = rotl(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = rotl(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = rotl(x[b]^x[c], 7)
+}
+
+func rotl(v uint32, n uint) uint32 {
+	return (v << n) | (v >> (32 - n))
+}
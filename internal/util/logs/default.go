@@ -1,22 +1,32 @@
 package logs
 
 import (
+	"log/slog"
 	"os"
 
 	"github.com/telehash/gogotelehash/internal/hashname"
 )
 
-var defaultLogger = New(os.Stdout)
+var defaultLogger = NewText(os.Stderr, slog.LevelInfo)
+
+// SetDefault replaces the default logger. A nil logger disables the default
+// logger.
+func SetDefault(l *Logger) {
+	defaultLogger = l
+}
 
 func ResetLogger() {
-	defaultLogger = New(os.Stderr)
-	disabledMods = map[string]bool{}
+	defaultLogger = NewText(os.Stderr, slog.LevelInfo)
 }
 
 func DisableLogger() {
 	defaultLogger = nil
 }
 
+func Default() *Logger {
+	return defaultLogger
+}
+
 func Module(name string) *Logger {
 	return defaultLogger.Module(name)
 }
@@ -29,18 +39,18 @@ func To(id hashname.H) *Logger {
 	return defaultLogger.To(id)
 }
 
-func ResetTimer() *Logger {
-	return defaultLogger.ResetTimer()
+func Debug(msg string, args ...interface{}) {
+	defaultLogger.Debug(msg, args...)
 }
 
-func Print(args ...interface{}) {
-	defaultLogger.Print(args...)
+func Info(msg string, args ...interface{}) {
+	defaultLogger.Info(msg, args...)
 }
 
-func Println(args ...interface{}) {
-	defaultLogger.Println(args...)
+func Warn(msg string, args ...interface{}) {
+	defaultLogger.Warn(msg, args...)
 }
 
-func Printf(format string, args ...interface{}) {
-	defaultLogger.Printf(format, args...)
+func Error(msg string, args ...interface{}) {
+	defaultLogger.Error(msg, args...)
 }
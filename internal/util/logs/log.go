@@ -1,161 +1,149 @@
+// Package logs provides structured, levelled logging on top of log/slog.
+//
+// All the methods of a nil *Logger are no-ops; a nil logger disables logging.
 package logs
 
 import (
-	"fmt"
+	"context"
 	"io"
-	"log"
-	"strings"
-	"time"
+	"log/slog"
 
 	"github.com/telehash/gogotelehash/internal/hashname"
 )
 
-var disabledMods = map[string]bool{}
-
+// A Logger adds the module and the local and remote hashnames to the records
+// it writes. Module, From and To replace the previous value of their field.
 type Logger struct {
+	root   *slog.Logger
+	l      *slog.Logger
 	module string
-	from   string
-	to     string
-	start  time.Time
-	log    *log.Logger
+	from   hashname.H
+	to     hashname.H
 }
 
-func New(out io.Writer) *Logger {
-	l := new(Logger)
-	l.start = time.Now()
-	l.log = log.New(out, "", 0)
-	return l
+// New returns a logger which writes its records to h. New returns nil when h is
+// nil.
+func New(h slog.Handler) *Logger {
+	if h == nil {
+		return nil
+	}
+	root := slog.New(h)
+	return &Logger{root: root, l: root}
 }
 
-func DisableModule(name string) {
-	disabledMods[name] = true
+// NewText returns a logger which writes records with at least level in the
+// logfmt style of slog.TextHandler to w.
+func NewText(w io.Writer, level slog.Leveler) *Logger {
+	return New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
 }
 
-func (l *Logger) Module(name string) *Logger {
+// Handler returns the slog.Handler of the logger.
+func (l *Logger) Handler() slog.Handler {
 	if l == nil {
 		return nil
 	}
+	return l.l.Handler()
+}
 
-	if disabledMods[name] {
+// Slog returns the logger as a *slog.Logger.
+func (l *Logger) Slog() *slog.Logger {
+	if l == nil {
 		return nil
 	}
-
-	x := new(Logger)
-	*x = *l
-	x.module = name
-	return x
+	return l.l
 }
 
-func (l *Logger) From(id hashname.H) *Logger {
+// With returns a logger which adds args (key-value pairs) to all records.
+func (l *Logger) With(args ...interface{}) *Logger {
 	if l == nil {
 		return nil
 	}
-
-	x := new(Logger)
-	*x = *l
-	x.from = string(id)[:4]
-	return x
+	c := *l
+	c.root = l.root.With(args...)
+	return c.derive()
 }
 
-func (l *Logger) To(id hashname.H) *Logger {
+// Module returns a logger for the module name.
+func (l *Logger) Module(name string) *Logger {
 	if l == nil {
 		return nil
 	}
-
-	x := new(Logger)
-	*x = *l
-	x.to = string(id)[:4]
-	return x
+	c := *l
+	c.module = name
+	return c.derive()
 }
 
-func (l *Logger) ResetTimer() *Logger {
+// From returns a logger for records about the local endpoint id.
+func (l *Logger) From(id hashname.H) *Logger {
 	if l == nil {
 		return nil
 	}
-
-	x := new(Logger)
-	*x = *l
-	x.start = time.Now()
-	return x
+	c := *l
+	c.from = id
+	return c.derive()
 }
 
-func (l *Logger) Print(args ...interface{}) {
+// To returns a logger for records about the remote endpoint id.
+func (l *Logger) To(id hashname.H) *Logger {
 	if l == nil {
-		return
+		return nil
 	}
-
-	l.emit(fmt.Sprint(args...))
+	c := *l
+	c.to = id
+	return c.derive()
 }
 
-func (l *Logger) Println(args ...interface{}) {
-	if l == nil {
-		return
+func (l Logger) derive() *Logger {
+	var attrs []interface{}
+	if l.module != "" {
+		attrs = append(attrs, "module", l.module)
+	}
+	if l.from != "" {
+		attrs = append(attrs, "from", string(l.from))
+	}
+	if l.to != "" {
+		attrs = append(attrs, "to", string(l.to))
 	}
 
-	l.emit(fmt.Sprintln(args...))
+	l.l = l.root
+	if len(attrs) > 0 {
+		l.l = l.root.With(attrs...)
+	}
+	return &l
 }
 
-func (l *Logger) Printf(format string, args ...interface{}) {
+// Enabled returns true when records of level are logged.
+func (l *Logger) Enabled(level slog.Level) bool {
 	if l == nil {
-		return
+		return false
 	}
-
-	l.emit(fmt.Sprintf(format, args...))
+	return l.l.Enabled(context.Background(), level)
 }
 
-func (l *Logger) emit(msg string) {
+func (l *Logger) Debug(msg string, args ...interface{}) {
 	if l == nil {
 		return
 	}
+	l.l.Debug(msg, args...)
+}
 
-	if msg == "" {
+func (l *Logger) Info(msg string, args ...interface{}) {
+	if l == nil {
 		return
 	}
+	l.l.Info(msg, args...)
+}
 
-	var (
-		th, tm, ts, tms time.Duration
-		from            string
-		to              string
-		module          string
-	)
-
-	{
-		d := time.Since(l.start)
-
-		th = d / time.Hour
-		d -= th * time.Hour
-
-		tm = d / time.Minute
-		d -= tm * time.Minute
-
-		ts = d / time.Second
-		d -= ts * time.Second
-
-		tms = d / time.Millisecond
-	}
-
-	from = l.from
-	if from == "" {
-		from = "    " // 4 spaces
-	} else {
-		from = colorize(from)
-	}
-
-	to = l.to
-	if to == "" {
-		to = "    " // 4 spaces
-	} else {
-		to = colorize(to)
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	if l == nil {
+		return
 	}
+	l.l.Warn(msg, args...)
+}
 
-	module = l.module
-	moduleLen := len(module)
-	if moduleLen > 0 {
-		module = colorize(module)
-	}
-	if moduleLen < 12 {
-		module += strings.Repeat(" ", 12-moduleLen)
+func (l *Logger) Error(msg string, args ...interface{}) {
+	if l == nil {
+		return
 	}
-
-	l.log.Printf("\x1B[2;37m%02d:%02d:%02d.%03d |\x1B[0m %s %s \x1B[2;37m|\x1B[0m %s \x1B[2;37m|\x1B[0m %s", th, tm, ts, tms, from, to, module, msg)
+	l.l.Error(msg, args...)
 }
@@ -0,0 +1,55 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestLoggerFields(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	log := New(slog.NewJSONHandler(&buf, nil)).Module("e3x").From("aaaa").To("bbbb")
+	log = log.Module("addrbook")
+
+	log.Info("Changed path", "to_path", "udp4:127.0.0.1:4000")
+
+	var rec map[string]interface{}
+	if assert.NoError(json.Unmarshal(buf.Bytes(), &rec)) {
+		assert.Equal("INFO", rec["level"])
+		assert.Equal("Changed path", rec["msg"])
+		assert.Equal("addrbook", rec["module"])
+		assert.Equal("aaaa", rec["from"])
+		assert.Equal("bbbb", rec["to"])
+		assert.Equal("udp4:127.0.0.1:4000", rec["to_path"])
+	}
+	assert.Equal(1, bytes.Count(buf.Bytes(), []byte(`"module"`)))
+}
+
+func TestLoggerLevels(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	log := NewText(&buf, slog.LevelInfo)
+
+	log.Debug("hidden")
+	log.Warn("shown", "n", 1)
+
+	assert.NotContains(buf.String(), "hidden")
+	assert.Contains(buf.String(), "level=WARN msg=shown n=1")
+	assert.NotContains(buf.String(), "\x1B[")
+	assert.False(log.Enabled(slog.LevelDebug))
+}
+
+func TestNilLogger(t *testing.T) {
+	var log *Logger
+
+	assert.NotPanics(t, func() {
+		log.Module("e3x").From("aaaa").Info("ignored", "k", "v")
+	})
+	assert.False(t, log.Enabled(slog.LevelError))
+}
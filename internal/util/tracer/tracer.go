@@ -1,31 +1,88 @@
+// Package tracer emits the trace events (see package e3x/trace) which describe
+// the life of endpoints, exchanges, channels and packets.
 package tracer
 
 import (
-	"encoding/json"
-	"log"
 	"os"
 	"sync/atomic"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/trace"
 )
 
-type ID uint64
-type Info map[string]interface{}
+type (
+	ID    = trace.ID
+	Info  = trace.Info
+	Phase = trace.Phase
+	Event = trace.Event
+	Sink  = trace.Sink
+)
 
-var (
-	Enabled      bool   = os.Getenv("TH_TRACER") == "on"
-	lastTracerId uint64 = 0
-	logger       *log.Logger
+const (
+	PhaseStart = trace.PhaseStart
+	PhaseEnd   = trace.PhaseEnd
 )
 
-func init() {
-	logger = log.New(os.Stdout, "", 0)
-}
+var lastTracerId uint64 = 0
 
 func NewID() ID {
 	return ID(atomic.AddUint64(&lastTracerId, 1))
 }
 
-func Emit(typ string, info interface{}) {
-	if !Enabled {
+// Tracer passes the sampled events to its sink. All the methods of a nil
+// *Tracer are no-ops; a nil tracer disables tracing.
+type Tracer struct {
+	sink      Sink
+	threshold uint64
+	all       bool
+}
+
+// New returns a tracer which passes a fraction rate (0 < rate <= 1) of the
+// events to sink. New returns nil when sink is nil or rate <= 0.
+//
+// Sampling is decided per key (see Sampled) so all the events of a sampled
+// exchange and its channels are kept.
+func New(sink Sink, rate float64) *Tracer {
+	if sink == nil || rate <= 0 {
+		return nil
+	}
+	if rate >= 1 {
+		return &Tracer{sink: sink, all: true}
+	}
+	return &Tracer{sink: sink, threshold: uint64(rate * (1 << 64))}
+}
+
+// Default returns the tracer for endpoints which are not configured otherwise.
+// When the TH_TRACER environment variable is set to "on" all events are
+// written as JSON lines to stdout.
+func Default() *Tracer {
+	if os.Getenv("TH_TRACER") == "on" {
+		return New(trace.WriterSink(os.Stdout), 1)
+	}
+	return nil
+}
+
+// Enabled returns true when the tracer passes events to a sink.
+func (t *Tracer) Enabled() bool {
+	return t != nil
+}
+
+// Sampled returns true when the events for key must be emitted. Key 0 is
+// always sampled.
+func (t *Tracer) Sampled(key ID) bool {
+	if t == nil {
+		return false
+	}
+	if t.all || key == 0 {
+		return true
+	}
+	// spread the sequential IDs over the uint64 range
+	return uint64(key)*0x9E3779B97F4A7C15 < t.threshold
+}
+
+// Emit passes an event of type typ to the sink when key is sampled.
+func (t *Tracer) Emit(typ string, key ID, info interface{}) {
+	if !t.Sampled(key) {
 		return
 	}
 
@@ -33,22 +90,73 @@ func Emit(typ string, info interface{}) {
 		panic("type must not be blank")
 	}
 
-	e := event{
-		Type: typ,
+	t.sink.Emit(Event{
 		ID:   NewID(),
+		Type: typ,
+		Time: time.Now(),
 		Info: info,
+	})
+}
+
+// Span is an operation with a start and an end event. All the methods of a nil
+// *Span are no-ops.
+type Span struct {
+	t     *Tracer
+	id    ID
+	typ   string
+	start time.Time
+	info  interface{}
+}
+
+// Start emits the start event of a span of type typ when key is sampled. The
+// info is repeated on the end event.
+func (t *Tracer) Start(typ string, key ID, info interface{}) *Span {
+	if !t.Sampled(key) {
+		return nil
 	}
 
-	data, err := json.Marshal(&e)
-	if err != nil {
-		panic(err)
+	if typ == "" {
+		panic("type must not be blank")
 	}
 
-	logger.Println(string(data))
+	s := &Span{t: t, id: NewID(), typ: typ, start: time.Now(), info: info}
+	t.sink.Emit(Event{
+		ID:    NewID(),
+		Type:  typ,
+		Time:  s.start,
+		Span:  s.id,
+		Phase: PhaseStart,
+		Info:  info,
+	})
+	return s
 }
 
-type event struct {
-	ID   ID          `json:"id"`
-	Type string      `json:"ty"`
-	Info interface{} `json:"in,omitempty"`
+// ID returns the ID of the span.
+func (s *Span) ID() ID {
+	if s == nil {
+		return 0
+	}
+	return s.id
+}
+
+// End emits the end event of the span. err is the outcome of the operation.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	now := time.Now()
+	e := Event{
+		ID:       NewID(),
+		Type:     s.typ,
+		Time:     now,
+		Span:     s.id,
+		Phase:    PhaseEnd,
+		Duration: now.Sub(s.start),
+		Info:     s.info,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.t.sink.Emit(e)
 }
@@ -0,0 +1,67 @@
+package tracer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/trace"
+)
+
+func TestNilTracer(t *testing.T) {
+	assert := assert.New(t)
+
+	var tr = New(nil, 1)
+	assert.False(tr.Enabled())
+	assert.False(tr.Sampled(0))
+	assert.NotPanics(func() {
+		tr.Emit("x", 0, nil)
+		tr.Start("y", 0, nil).End(nil)
+	})
+}
+
+func TestSampling(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ring = trace.NewRing(10000)
+		tr   = New(ring, 0.25)
+	)
+
+	for i := 1; i <= 4000; i++ {
+		tr.Emit("packet", ID(i), nil)
+		tr.Emit("packet", ID(i), nil) // same decision for the same key
+	}
+
+	n := len(ring.Events())
+	assert.True(n%2 == 0, "n=%d", n)
+	assert.True(n > 1600 && n < 2400, "n=%d", n)
+
+	assert.True(tr.Sampled(0))
+	assert.True(New(ring, 1).Sampled(3))
+}
+
+func TestSpan(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ring = trace.NewRing(4)
+		tr   = New(ring, 1)
+	)
+
+	s := tr.Start("exchange.dial", 1, Info{"exchange_id": ID(1)})
+	s.End(errors.New("broken"))
+
+	events := ring.Events()
+	if assert.Equal(2, len(events)) {
+		start, end := events[0], events[1]
+		assert.Equal(PhaseStart, start.Phase)
+		assert.Equal(PhaseEnd, end.Phase)
+		assert.Equal(s.ID(), start.Span)
+		assert.Equal(s.ID(), end.Span)
+		assert.Equal("exchange.dial", end.Type)
+		assert.Equal("broken", end.Error)
+		assert.Equal("", start.Error)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+func writeHTML(w io.Writer, r *Report) error {
+	return htmlReport.Execute(w, r)
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"short":   short,
+	"seconds": func(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) },
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Telehash trace report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; font-family: monospace; }
+code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Telehash trace report</h1>
+
+<h2>Streams</h2>
+<table>
+<tr><th>Stream</th><th>Events</th><th>Endpoints</th></tr>
+{{range .Streams}}<tr><td>{{.Name}}</td><td class="num">{{.Events}}</td><td>{{range .Endpoints}}<code title="{{.}}">{{short .}}</code> {{end}}</td></tr>
+{{end}}</table>
+
+<h2>Exchanges</h2>
+{{range .Exchanges}}
+<h3><code title="{{.Local}}">{{short .Local}}</code> &rarr; <code title="{{.Peer}}">{{short .Peer}}</code> <small>({{.Stream}} #{{.ID}})</small></h3>
+<table>
+<tr><th>Sent</th><th>Received</th><th>Resent</th><th>Dropped</th><th>Delivered</th><th>Handshake RTT</th><th>Latency</th></tr>
+<tr><td class="num">{{.Sent}}</td><td class="num">{{.Received}}</td><td class="num">{{.Resent}} ({{percent .ResentRate}})</td><td class="num">{{.Dropped}}</td><td class="num">{{.Delivered}}</td><td>{{.HandshakeRTT}}</td><td>{{.Latency}}</td></tr>
+</table>
+<table>
+<tr><th>Time</th><th>Event</th></tr>
+{{range .Timeline}}<tr><td class="num">+{{seconds .Offset}}s</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Drops</h2>
+<table>
+<tr><th>Dropped</th><th>Reason</th><th>Count</th></tr>
+{{range .Drops}}<tr><td>{{.Type}}</td><td>{{.Reason}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/docopt/docopt-go"
+)
+
+const usage = `Telehash trace analyzer.
+
+Reads the JSON trace streams of one or more endpoints (as written by the
+tracer, TH_TRACER=on), correlates the packets across the endpoints and reports
+per-exchange timelines, drop reasons, retransmissions and latencies.
+
+Usage:
+  th-trace [--html=<file>] <trace>...
+  th-trace -h | --help
+  th-trace --version
+
+Options:
+  --html=<file>  Also write the report as HTML.
+  -h --help      Show this screen.
+  --version      Show version.
+
+Use - as <trace> to read a stream from stdin.
+`
+
+func main() {
+	args, _ := docopt.Parse(usage, nil, true, "0.1-dev", false)
+
+	var (
+		names, _ = args["<trace>"].([]string)
+		html, _  = args["--html"].(string)
+		a        = newAnalysis()
+	)
+
+	for _, name := range names {
+		err := loadFile(a, name)
+		check(err)
+	}
+
+	a.correlate()
+	report := a.report()
+
+	writeText(os.Stdout, report)
+
+	if html != "" {
+		f, err := os.Create(html)
+		check(err)
+		err = writeHTML(f, report)
+		check(err)
+		check(f.Close())
+	}
+}
+
+func loadFile(a *analysis, name string) error {
+	var r io.Reader
+
+	if name == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return a.load(name, r)
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is the result of an analysis. It is rendered as text and as HTML.
+type Report struct {
+	Streams   []StreamReport
+	Exchanges []ExchangeReport
+	Drops     []DropReport
+}
+
+type StreamReport struct {
+	Name      string
+	Events    int
+	Endpoints []string
+}
+
+type ExchangeReport struct {
+	Stream string
+	ID     uint64
+	Local  string
+	Peer   string
+
+	Timeline []TimelineEntry
+
+	Sent       int
+	Received   int
+	Resent     int
+	ResentRate float64 // resent / sent
+	Dropped    int
+	Delivered  int
+
+	HandshakeRTT Summary
+	Latency      Summary
+}
+
+type TimelineEntry struct {
+	Offset time.Duration // since the first event of all streams
+	Text   string
+}
+
+type DropReport struct {
+	Type   string
+	Reason string
+	Count  int
+}
+
+// Summary describes a set of durations.
+type Summary struct {
+	N                       int
+	Min, Avg, P50, P95, Max time.Duration
+}
+
+func (s Summary) String() string {
+	if s.N == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("n=%d min=%s avg=%s p50=%s p95=%s max=%s", s.N, s.Min, s.Avg, s.P50, s.P95, s.Max)
+}
+
+func summarize(ds []time.Duration) Summary {
+	if len(ds) == 0 {
+		return Summary{}
+	}
+
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+
+	return Summary{
+		N:   len(sorted),
+		Min: sorted[0],
+		Avg: sum / time.Duration(len(sorted)),
+		P50: sorted[(len(sorted)-1)*50/100],
+		P95: sorted[(len(sorted)-1)*95/100],
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+func (a *analysis) report() *Report {
+	r := &Report{}
+
+	for _, s := range a.streams {
+		sr := StreamReport{Name: s.name, Events: s.events}
+		for _, hn := range s.endpoints {
+			sr.Endpoints = append(sr.Endpoints, hn)
+		}
+		sort.Strings(sr.Endpoints)
+		r.Streams = append(r.Streams, sr)
+	}
+
+	for _, x := range a.sortedExchanges() {
+		xr := ExchangeReport{
+			Stream:       x.stream.name,
+			ID:           uint64(x.id),
+			Local:        x.local,
+			Peer:         x.peer,
+			Sent:         x.sent,
+			Received:     x.received,
+			Resent:       x.resent,
+			Dropped:      x.dropped,
+			Delivered:    x.delivered,
+			HandshakeRTT: summarize(x.handshakeRTT),
+			Latency:      summarize(x.latency),
+		}
+		if x.sent > 0 {
+			xr.ResentRate = float64(x.resent) / float64(x.sent)
+		}
+		for _, e := range x.timeline {
+			xr.Timeline = append(xr.Timeline, TimelineEntry{e.At.Sub(a.t0), e.Text})
+		}
+		r.Exchanges = append(r.Exchanges, xr)
+	}
+
+	for k, n := range a.drops {
+		r.Drops = append(r.Drops, DropReport{k.Type, k.Reason, n})
+	}
+	sort.Slice(r.Drops, func(i, j int) bool {
+		if r.Drops[i].Count != r.Drops[j].Count {
+			return r.Drops[i].Count > r.Drops[j].Count
+		}
+		if r.Drops[i].Type != r.Drops[j].Type {
+			return r.Drops[i].Type < r.Drops[j].Type
+		}
+		return r.Drops[i].Reason < r.Drops[j].Reason
+	})
+
+	return r
+}
+
+func writeText(w io.Writer, r *Report) {
+	tab := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tab, "STREAM\tEVENTS\tENDPOINTS\n")
+	for _, s := range r.Streams {
+		endpoints := "-"
+		for i, hn := range s.Endpoints {
+			if i == 0 {
+				endpoints = short(hn)
+			} else {
+				endpoints += " " + short(hn)
+			}
+		}
+		fmt.Fprintf(tab, "%s\t%d\t%s\n", s.Name, s.Events, endpoints)
+	}
+	tab.Flush()
+
+	for _, x := range r.Exchanges {
+		fmt.Fprintf(w, "\nexchange %s -> %s (%s #%d)\n", short(x.Local), short(x.Peer), x.Stream, x.ID)
+
+		for _, e := range x.Timeline {
+			fmt.Fprintf(tab, "  +%.3fs\t%s\n", e.Offset.Seconds(), e.Text)
+		}
+		tab.Flush()
+
+		fmt.Fprintf(w, "  packets:       sent=%d received=%d resent=%d (%.1f%%) dropped=%d delivered=%d\n",
+			x.Sent, x.Received, x.Resent, x.ResentRate*100, x.Dropped, x.Delivered)
+		fmt.Fprintf(w, "  handshake rtt: %s\n", x.HandshakeRTT)
+		fmt.Fprintf(w, "  latency:       %s\n", x.Latency)
+	}
+
+	if len(r.Drops) > 0 {
+		fmt.Fprintf(w, "\n")
+		fmt.Fprintf(tab, "DROPPED\tREASON\tCOUNT\n")
+		for _, d := range r.Drops {
+			fmt.Fprintf(tab, "%s\t%s\t%d\n", d.Type, d.Reason, d.Count)
+		}
+		tab.Flush()
+	}
+}
+
+func short(hn string) string {
+	if hn == "" {
+		return "?"
+	}
+	if len(hn) > 8 {
+		return hn[:8]
+	}
+	return hn
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/trace"
+)
+
+// analysis holds the events of all the loaded streams. The tracer IDs are only
+// unique within a stream (a process), so all the lookups are per stream.
+type analysis struct {
+	streams   []*stream
+	exchanges []*exchange
+	drops     map[dropKey]int
+	t0        time.Time
+}
+
+type stream struct {
+	name      string
+	events    int
+	endpoints map[trace.ID]string // endpoint_id -> hashname
+	exchanges map[trace.ID]*exchange
+	channels  map[trace.ID]*channel
+}
+
+type exchange struct {
+	stream *stream
+	id     trace.ID
+	local  string
+	peer   string
+
+	timeline []entry
+
+	sent      int
+	received  int
+	resent    int
+	dropped   int
+	delivered int // sent packets which were received by the peer
+
+	handshakeRTT []time.Duration
+	latency      []time.Duration // one-way latency of the delivered packets
+
+	packets []packetRecord
+}
+
+type channel struct {
+	x        *exchange
+	cid      uint32
+	typ      string
+	reliable bool
+}
+
+type entry struct {
+	At   time.Time
+	Text string
+}
+
+type dropKey struct {
+	Type   string
+	Reason string
+}
+
+// packetRecord is a sent or received channel packet.
+type packetRecord struct {
+	at     time.Time
+	sent   bool
+	c      uint32
+	seq    uint32
+	hasSeq bool
+	body   string
+}
+
+func newAnalysis() *analysis {
+	return &analysis{drops: make(map[dropKey]int)}
+}
+
+// load reads a stream of JSON trace events from r.
+func (a *analysis) load(name string, r io.Reader) error {
+	s := &stream{
+		name:      name,
+		endpoints: make(map[trace.ID]string),
+		exchanges: make(map[trace.ID]*exchange),
+		channels:  make(map[trace.ID]*channel),
+	}
+	a.streams = append(a.streams, s)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(data) == 0 || data[0] != '{' {
+			continue // not an event (test output on the same stream)
+		}
+
+		var e trace.Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("%s:%d: %s", name, line, err)
+		}
+		if e.Type == "" {
+			continue
+		}
+
+		if !e.Time.IsZero() && (a.t0.IsZero() || e.Time.Before(a.t0)) {
+			a.t0 = e.Time
+		}
+
+		s.events++
+		a.apply(s, &e)
+	}
+
+	return scanner.Err()
+}
+
+func (a *analysis) apply(s *stream, e *trace.Event) {
+	info, _ := e.Info.(map[string]interface{})
+
+	switch e.Type {
+
+	case "endpoint.new":
+		s.endpoints[getID(info, "endpoint_id")] = getString(info, "hashname")
+
+	case "endpoint.drop.packet":
+		a.drops[dropKey{e.Type, getString(info, "reason")}]++
+
+	case "exchange.new":
+		x := &exchange{
+			stream: s,
+			id:     getID(info, "exchange_id"),
+			local:  s.endpoints[getID(info, "endpoint_id")],
+		}
+		s.exchanges[x.id] = x
+		a.exchanges = append(a.exchanges, x)
+		x.log(e, "created")
+		return
+	}
+
+	var x *exchange
+	if strings.HasPrefix(e.Type, "channel.") && e.Type != "channel.new" && e.Type != "channel.lifetime" {
+		if c := s.channels[getID(info, "channel_id")]; c != nil {
+			x = c.x
+		}
+	} else {
+		x = s.exchanges[getID(info, "exchange_id")]
+	}
+	if x == nil {
+		return
+	}
+
+	switch e.Type {
+
+	case "exchange.started":
+		x.peer = getString(info, "peer")
+		x.log(e, "opened")
+
+	case "exchange.stopped":
+		x.log(e, "stopped")
+
+	case "exchange.error":
+		x.log(e, "error: "+getString(info, "error"))
+
+	case "exchange.dial":
+		switch {
+		case e.Phase == trace.PhaseStart:
+			x.log(e, "dialing")
+		case e.Error != "":
+			x.log(e, fmt.Sprintf("dial failed after %s: %s", e.Duration, e.Error))
+		default:
+			x.log(e, fmt.Sprintf("dialed in %s", e.Duration))
+		}
+
+	case "exchange.handshake":
+		if e.Phase == trace.PhaseEnd {
+			if e.Error == "" {
+				x.handshakeRTT = append(x.handshakeRTT, e.Duration)
+			} else {
+				x.log(e, "handshake unanswered")
+			}
+		}
+
+	case "exchange.drop.handshake", "exchange.drop.packet":
+		reason := getString(info, "reason")
+		a.drops[dropKey{e.Type, reason}]++
+		if e.Type == "exchange.drop.handshake" {
+			x.log(e, "dropped handshake: "+reason)
+		} else {
+			x.dropped++
+		}
+
+	case "channel.new":
+		ch := getMap(info, "channel")
+		c := &channel{
+			x:        x,
+			cid:      uint32(getFloat(ch, "cid")),
+			typ:      getString(ch, "type"),
+			reliable: ch["reliable"] == true,
+		}
+		s.channels[getID(info, "channel_id")] = c
+		mode := "unreliable"
+		if c.reliable {
+			mode = "reliable"
+		}
+		x.log(e, fmt.Sprintf("channel %d %q opened (%s)", c.cid, c.typ, mode))
+
+	case "channel.lifetime":
+		if e.Phase == trace.PhaseEnd {
+			var (
+				ch   = getMap(info, "channel")
+				text = fmt.Sprintf("channel %d %q closed after %s", uint32(getFloat(ch, "cid")), getString(ch, "type"), e.Duration)
+			)
+			if e.Error != "" {
+				text += ": " + e.Error
+			}
+			x.log(e, text)
+		}
+
+	case "channel.write":
+		x.sent++
+		x.record(e, info, true)
+
+	case "channel.resend":
+		x.resent++
+
+	case "channel.rcv.packet":
+		x.received++
+		x.record(e, info, false)
+
+	case "channel.drop.packet":
+		x.dropped++
+		a.drops[dropKey{e.Type, getString(info, "reason")}]++
+
+	case "channel.write.error":
+		a.drops[dropKey{e.Type, getString(info, "reason")}]++
+
+	}
+}
+
+func (x *exchange) log(e *trace.Event, text string) {
+	x.timeline = append(x.timeline, entry{e.Time, text})
+}
+
+func (x *exchange) record(e *trace.Event, info map[string]interface{}, sent bool) {
+	var (
+		pkt = getMap(info, "packet")
+		hdr = getMap(pkt, "header")
+	)
+
+	r := packetRecord{
+		at:   e.Time,
+		sent: sent,
+		c:    uint32(getFloat(hdr, "c")),
+		body: getString(pkt, "body"),
+	}
+	if seq, found := hdr["seq"]; found {
+		r.seq = uint32(toFloat(seq))
+		r.hasSeq = true
+	}
+
+	x.packets = append(x.packets, r)
+}
+
+// correlate matches the packets sent by one endpoint with the packets received
+// by its peer. A sent packet matches the first unmatched received packet with
+// the same channel, sequence number and body.
+func (a *analysis) correlate() {
+	type key struct {
+		from, to string
+		c        uint32
+		seq      uint32
+		hasSeq   bool
+		body     string
+	}
+
+	type pending struct {
+		x  *exchange
+		at time.Time
+	}
+
+	sends := map[key][]pending{}
+	for _, x := range a.exchanges {
+		for _, r := range x.packets {
+			if r.sent {
+				k := key{x.local, x.peer, r.c, r.seq, r.hasSeq, r.body}
+				sends[k] = append(sends[k], pending{x, r.at})
+			}
+		}
+	}
+
+	for _, x := range a.exchanges {
+		for _, r := range x.packets {
+			if r.sent {
+				continue
+			}
+
+			k := key{x.peer, x.local, r.c, r.seq, r.hasSeq, r.body}
+			queue := sends[k]
+			if len(queue) == 0 {
+				continue
+			}
+
+			p := queue[0]
+			sends[k] = queue[1:]
+
+			p.x.delivered++
+			p.x.latency = append(p.x.latency, r.at.Sub(p.at))
+		}
+	}
+}
+
+// sortedExchanges returns the exchanges ordered by their first event.
+func (a *analysis) sortedExchanges() []*exchange {
+	l := append([]*exchange(nil), a.exchanges...)
+	sort.SliceStable(l, func(i, j int) bool {
+		if len(l[i].timeline) == 0 || len(l[j].timeline) == 0 {
+			return len(l[i].timeline) > len(l[j].timeline)
+		}
+		return l[i].timeline[0].At.Before(l[j].timeline[0].At)
+	})
+	return l
+}
+
+func getMap(m map[string]interface{}, k string) map[string]interface{} {
+	v, _ := m[k].(map[string]interface{})
+	return v
+}
+
+func getString(m map[string]interface{}, k string) string {
+	v, _ := m[k].(string)
+	return v
+}
+
+func getFloat(m map[string]interface{}, k string) float64 {
+	return toFloat(m[k])
+}
+
+func getID(m map[string]interface{}, k string) trace.ID {
+	return trace.ID(getFloat(m, k))
+}
+
+func toFloat(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
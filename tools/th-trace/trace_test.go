@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/e3x/trace"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestAnalyze(t *testing.T) {
+	assert := assert.New(t)
+
+	var bufA, bufB bytes.Buffer
+
+	A, err := e3x.Open(e3x.Trace(trace.WriterSink(&bufA), 1), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	B, err := e3x.Open(e3x.Trace(trace.WriterSink(&bufB), 1), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		A.Close()
+		return
+	}
+
+	B.Mux().HandleFunc("echo", true, func(c *e3x.Channel) {
+		defer c.Close()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			if pkt.BodyLen() == 0 {
+				continue
+			}
+			c.WritePacket(lob.New(pkt.Body(nil)))
+		}
+	})
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := A.Open(ident, "echo", true)
+	if assert.NoError(err) {
+		for i := 0; i < 5; i++ {
+			assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+		}
+		assert.NoError(c.Close())
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	A.Close()
+	B.Close()
+
+	a := newAnalysis()
+	assert.NoError(a.load("a", &bufA))
+	assert.NoError(a.load("b", &bufB))
+	a.correlate()
+	r := a.report()
+
+	if !assert.Equal(2, len(r.Exchanges)) {
+		return
+	}
+
+	xA := r.Exchanges[0]
+	assert.Equal("a", xA.Stream)
+	assert.Equal(string(A.LocalHashname()), xA.Local)
+	assert.Equal(string(B.LocalHashname()), xA.Peer)
+	assert.True(xA.Sent >= 5, "sent=%d", xA.Sent)
+	assert.True(xA.Delivered >= 5, "delivered=%d", xA.Delivered)
+	assert.Equal(xA.Delivered, xA.Latency.N)
+	assert.True(xA.HandshakeRTT.N >= 1)
+
+	var timeline []string
+	for _, e := range xA.Timeline {
+		timeline = append(timeline, e.Text)
+	}
+	text := strings.Join(timeline, "\n")
+	assert.Contains(text, "dialed in")
+	assert.Regexp(`channel [0-9]+ "echo" opened \(reliable\)`, text)
+	assert.Contains(text, `"echo" closed after`)
+
+	xB := r.Exchanges[1]
+	assert.Equal("b", xB.Stream)
+	assert.True(xB.Delivered >= 5, "delivered=%d", xB.Delivered)
+
+	var out bytes.Buffer
+	writeText(&out, r)
+	assert.Contains(out.String(), "exchange "+short(xA.Local)+" -> "+short(xA.Peer))
+
+	out.Reset()
+	assert.NoError(writeHTML(&out, r))
+	assert.Contains(out.String(), "<h2>Exchanges</h2>")
+}
+
+func TestSummarize(t *testing.T) {
+	assert := assert.New(t)
+
+	s := summarize([]time.Duration{4, 1, 3, 2, 5})
+	assert.Equal(5, s.N)
+	assert.Equal(time.Duration(1), s.Min)
+	assert.Equal(time.Duration(3), s.Avg)
+	assert.Equal(time.Duration(3), s.P50)
+	assert.Equal(time.Duration(5), s.Max)
+
+	assert.Equal("-", summarize(nil).String())
+}
@@ -0,0 +1,128 @@
+// Package capture implements a transport wrapper that records all the packets
+// to a pcapng file.
+//
+// Packets are recorded with a custom link type (LinkTypeWire) and prefixed
+// with a small record header containing the direction and the local and
+// remote addresses. Optionally the decrypted lob packets can be recorded as
+// well (LinkTypeLob).
+//
+//   w, err := capture.Create("telehash.pcapng")
+//   e3x.Open(
+//     e3x.Transport(capture.Config{Config: udp.Config{}, Writer: w}),
+//     capture.Decrypted(w))
+package capture
+
+import (
+	"net"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+var (
+	_ transports.Config    = Config{}
+	_ transports.Transport = (*transport)(nil)
+	_ net.Conn             = (*conn)(nil)
+)
+
+// Config for the capture transport.
+type Config struct {
+	Config transports.Config // the sub-transport configuration
+	Writer *Writer           // the pcapng writer
+}
+
+type transport struct {
+	t transports.Transport
+	w *Writer
+}
+
+type conn struct {
+	net.Conn
+	w *Writer
+}
+
+// Open opens the sub-transport.
+func (c Config) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Writer == nil {
+		return t, nil
+	}
+
+	return &transport{t, c.Writer}, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	return t.t.Addrs()
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	c, err := t.t.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{c, t.w}, nil
+}
+
+func (t *transport) Accept() (net.Conn, error) {
+	c, err := t.t.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{c, t.w}, nil
+}
+
+func (t *transport) Close() error {
+	return t.t.Close()
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == nil {
+		c.w.writePacket(ifaceWire, time.Now(), Inbound, c.LocalAddr(), c.RemoteAddr(), b[:n])
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err == nil {
+		c.w.writePacket(ifaceWire, time.Now(), Outbound, c.LocalAddr(), c.RemoteAddr(), b[:n])
+	}
+	return n, err
+}
+
+// Decrypted records the decrypted lob packets of all the exchanges of an
+// endpoint. The local and remote addresses of these records are the hashnames
+// of the endpoints.
+func Decrypted(w *Writer) e3x.EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		e.DefaultExchangeHooks().Register(e3x.ExchangeHook{
+			OnSendPacket: func(e *e3x.Endpoint, x *e3x.Exchange, pkt *lob.Packet, pipe *e3x.Pipe) error {
+				return writeLob(w, Outbound, e, x, pkt)
+			},
+			OnReceivePacket: func(e *e3x.Endpoint, x *e3x.Exchange, pkt *lob.Packet, pipe *e3x.Pipe) error {
+				return writeLob(w, Inbound, e, x, pkt)
+			},
+		})
+		return nil
+	}
+}
+
+func writeLob(w *Writer, dir Direction, e *e3x.Endpoint, x *e3x.Exchange, pkt *lob.Packet) error {
+	buf, err := lob.Encode(pkt)
+	if err != nil {
+		return nil
+	}
+	defer buf.Free()
+
+	w.writePacket(ifaceLob, time.Now(), dir, e.LocalHashname(), x.RemoteHashname(), buf.RawBytes())
+	return nil
+}
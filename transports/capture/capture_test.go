@@ -0,0 +1,148 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestCapture(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf)
+	if !assert.NoError(err) {
+		return
+	}
+
+	A, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(Config{Config: udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}, Writer: w}),
+		Decrypted(w))
+	if !assert.NoError(err) {
+		return
+	}
+
+	B, err := e3x.Open(e3x.Log(nil), e3x.Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	if !assert.NoError(err) {
+		return
+	}
+
+	l := B.Listen("ping", false)
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return
+		}
+		c.ReadPacket()
+		c.Kill()
+	}()
+
+	Bident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := A.Open(Bident, "ping", false)
+	if assert.NoError(err) {
+		assert.NoError(c.WritePacket(lob.New([]byte("hello world"))))
+		time.Sleep(100 * time.Millisecond)
+		c.Kill()
+	}
+
+	l.Close()
+	assert.NoError(A.Close())
+	assert.NoError(B.Close())
+	assert.NoError(w.Close())
+
+	blocks := readBlocks(t, buf.Bytes())
+	if !assert.True(len(blocks) > 3) {
+		return
+	}
+
+	assert.Equal(uint32(blockSHB), blocks[0].typ)
+	assert.Equal(uint32(blockIDB), blocks[1].typ)
+	assert.Equal(uint16(LinkTypeWire), binary.LittleEndian.Uint16(blocks[1].body))
+	assert.Equal(uint32(blockIDB), blocks[2].typ)
+	assert.Equal(uint16(LinkTypeLob), binary.LittleEndian.Uint16(blocks[2].body))
+
+	var (
+		wire = map[Direction]int{}
+		body bool
+	)
+
+	for _, b := range blocks[3:] {
+		if !assert.Equal(uint32(blockEPB), b.typ) {
+			continue
+		}
+
+		iface := binary.LittleEndian.Uint32(b.body[0:])
+		caplen := binary.LittleEndian.Uint32(b.body[12:])
+		record := b.body[20 : 20+caplen]
+
+		assert.Equal(byte(recordVersion), record[0])
+		dir := Direction(record[1])
+		laddr, record := readAddr(record[2:])
+		raddr, record := readAddr(record)
+
+		switch iface {
+		case ifaceWire:
+			assert.True(strings.HasPrefix(laddr, "udp4 127.0.0.1:"), laddr)
+			assert.True(strings.HasPrefix(raddr, "udp4 127.0.0.1:"), raddr)
+			wire[dir]++
+		case ifaceLob:
+			assert.Equal("telehash "+string(A.LocalHashname()), laddr)
+			assert.Equal("telehash "+string(B.LocalHashname()), raddr)
+			if dir == Outbound && bytes.Contains(record, []byte("hello world")) {
+				body = true
+			}
+		default:
+			t.Errorf("unexpected interface %d", iface)
+		}
+	}
+
+	assert.True(wire[Inbound] > 0)
+	assert.True(wire[Outbound] > 0)
+	assert.True(body, "expected the decrypted packet to be captured")
+}
+
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, data []byte) []block {
+	var blocks []block
+
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block")
+		}
+
+		typ := binary.LittleEndian.Uint32(data[0:])
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("invalid block length %d", length)
+		}
+		if binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block trailer")
+		}
+
+		blocks = append(blocks, block{typ, data[8 : length-4]})
+		data = data[length:]
+	}
+
+	return blocks
+}
+
+func readAddr(b []byte) (string, []byte) {
+	n := binary.BigEndian.Uint16(b)
+	return string(b[2 : 2+n]), b[2+n:]
+}
@@ -0,0 +1,246 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Link types of the captured packets. These are the first two of the
+// LINKTYPE_USERn types that are reserved for private use.
+const (
+	LinkTypeWire = 147 // LINKTYPE_USER0: packets as sent over the transport
+	LinkTypeLob  = 148 // LINKTYPE_USER1: decrypted lob packets
+)
+
+// Direction of a captured packet.
+type Direction uint8
+
+const (
+	Inbound  Direction = 1
+	Outbound Direction = 2
+)
+
+// interface ids in the pcapng section
+const (
+	ifaceWire = 0
+	ifaceLob  = 1
+)
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	optEndOfOpt  = 0
+	optIfName    = 2
+	optIfTsresol = 9
+	optEpbFlags  = 2
+
+	recordVersion = 1
+)
+
+// Writer writes captured packets to a pcapng stream. A Writer can be shared by
+// multiple transports and endpoints.
+type Writer struct {
+	mtx    sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	err    error
+}
+
+// Create creates the pcapng file name.
+func Create(name string) (*Writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w.closer = f
+	return w, nil
+}
+
+// NewWriter writes the pcapng section header to w and returns a Writer for
+// the packets.
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &Writer{w: bufio.NewWriter(w)}
+
+	{ // section header
+		var body [16]byte
+		binary.LittleEndian.PutUint32(body[0:], 0x1A2B3C4D) // byte order magic
+		binary.LittleEndian.PutUint16(body[4:], 1)          // major version
+		binary.LittleEndian.PutUint16(body[6:], 0)          // minor version
+		binary.LittleEndian.PutUint64(body[8:], ^uint64(0)) // unknown section length
+		cw.writeBlock(blockSHB, body[:])
+	}
+
+	cw.writeInterface(LinkTypeWire, "telehash-wire")
+	cw.writeInterface(LinkTypeLob, "telehash-lob")
+
+	err := cw.flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return cw, nil
+}
+
+// Close flushes the writer and closes the file when the Writer was made with
+// Create.
+func (w *Writer) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	err := w.flush()
+
+	if w.closer != nil {
+		if err2 := w.closer.Close(); err == nil {
+			err = err2
+		}
+		w.closer = nil
+	}
+
+	if w.err == nil {
+		w.err = io.ErrClosedPipe
+	}
+
+	return err
+}
+
+func (w *Writer) writeInterface(linkType uint16, name string) {
+	var body []byte
+
+	body = appendUint16(body, linkType)
+	body = appendUint16(body, 0) // reserved
+	body = appendUint32(body, 0) // no snap length
+	body = appendOption(body, optIfName, []byte(name))
+	body = appendOption(body, optIfTsresol, []byte{9}) // nanoseconds
+	body = appendOption(body, optEndOfOpt, nil)
+
+	w.writeBlock(blockIDB, body)
+}
+
+// writePacket writes an enhanced packet block. The packet data is prefixed
+// with a record header:
+//
+//   uint8   version (1)
+//   uint8   direction (1 = inbound, 2 = outbound)
+//   uint16  length of the local address
+//   []byte  local address ("<network> <address>")
+//   uint16  length of the remote address
+//   []byte  remote address ("<network> <address>")
+//   []byte  packet
+//
+// All integers are big endian.
+func (w *Writer) writePacket(iface uint32, ts time.Time, dir Direction, laddr, raddr net.Addr, pkt []byte) error {
+	var (
+		record []byte
+		body   []byte
+		nanos  = uint64(ts.UnixNano())
+	)
+
+	record = append(record, recordVersion, byte(dir))
+	record = appendAddr(record, laddr)
+	record = appendAddr(record, raddr)
+	record = append(record, pkt...)
+
+	body = appendUint32(body, iface)
+	body = appendUint32(body, uint32(nanos>>32))
+	body = appendUint32(body, uint32(nanos))
+	body = appendUint32(body, uint32(len(record)))
+	body = appendUint32(body, uint32(len(record)))
+	body = append(body, record...)
+	body = pad(body)
+
+	var flags [4]byte
+	binary.LittleEndian.PutUint32(flags[:], uint32(dir))
+	body = appendOption(body, optEpbFlags, flags[:])
+	body = appendOption(body, optEndOfOpt, nil)
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.writeBlock(blockEPB, body)
+	return w.flush()
+}
+
+// writeBlock must be called with w.mtx held (or before w is shared).
+func (w *Writer) writeBlock(typ uint32, body []byte) {
+	if w.err != nil {
+		return
+	}
+
+	var (
+		hdr    [8]byte
+		trl    [4]byte
+		length = uint32(12 + len(body))
+	)
+
+	binary.LittleEndian.PutUint32(hdr[0:], typ)
+	binary.LittleEndian.PutUint32(hdr[4:], length)
+	binary.LittleEndian.PutUint32(trl[0:], length)
+
+	w.w.Write(hdr[:])
+	w.w.Write(body)
+	_, w.err = w.w.Write(trl[:])
+}
+
+func (w *Writer) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var x [2]byte
+	binary.LittleEndian.PutUint16(x[:], v)
+	return append(b, x[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var x [4]byte
+	binary.LittleEndian.PutUint32(x[:], v)
+	return append(b, x[:]...)
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = appendUint16(b, code)
+	b = appendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return pad(b)
+}
+
+func appendAddr(b []byte, addr net.Addr) []byte {
+	var s string
+	if addr != nil {
+		s = addr.Network() + " " + addr.String()
+	}
+
+	var x [2]byte
+	binary.BigEndian.PutUint16(x[:], uint16(len(s)))
+	b = append(b, x[:]...)
+	return append(b, s...)
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
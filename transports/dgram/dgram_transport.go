@@ -5,8 +5,10 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/transports"
 	"github.com/telehash/gogotelehash/transports/transportsutil"
 )
@@ -26,8 +28,37 @@ type Transport interface {
 	Close() error
 }
 
+// Message is a datagram which is read or written as part of a batch.
+type Message struct {
+	Buffer *bufpool.Buffer
+	Addr   Addr
+}
+
+// BatchTransport can be implemented by a Transport that is able to read and
+// write multiple datagrams at once (like recvmmsg/sendmmsg).
+type BatchTransport interface {
+	Transport
+
+	// BatchSize returns the maximum number of messages in a batch.
+	// Batching is not used when BatchSize returns less than 2.
+	BatchSize() int
+
+	// ReadBatch blocks until at least one message was read. Each message must
+	// have a Buffer (with the capacity of a bufpool buffer) which may be
+	// replaced by the transport. n is the number of messages that were read.
+	ReadBatch(msgs []Message) (n int, err error)
+
+	// WriteBatch writes msgs. n is the number of messages that were written
+	// before msgs[n] failed with err.
+	WriteBatch(msgs []Message) (n int, err error)
+}
+
 type transport struct {
 	inner Transport
+	batch BatchTransport
+
+	writes  chan *writeRequest
+	writing int32
 
 	mtx    sync.RWMutex
 	conns  map[interface{}]*connection
@@ -47,16 +78,35 @@ type connection struct {
 	halfPipe *transportsutil.HalfPipe
 }
 
+type writeRequest struct {
+	msg  Message
+	err  error
+	done chan struct{}
+}
+
 var (
 	_ transports.Transport = (*transport)(nil)
 )
 
+var writeRequestPool = sync.Pool{
+	New: func() interface{} {
+		return &writeRequest{done: make(chan struct{}, 1)}
+	},
+}
+
 // Wrap a drgram transport in a stream Transport
 func Wrap(inner Transport) (transports.Transport, error) {
 	t := &transport{inner: inner}
 	t.cndAccept = sync.NewCond(&t.mtxAccept)
 
-	go t.reader()
+	if batch, ok := inner.(BatchTransport); ok && batch.BatchSize() > 1 {
+		t.batch = batch
+		t.writes = make(chan *writeRequest, batch.BatchSize())
+		go t.batchReader()
+		go t.batchWriter()
+	} else {
+		go t.reader()
+	}
 
 	return t, nil
 }
@@ -79,6 +129,11 @@ func (t *transport) Close() error {
 	err := t.inner.Close()
 	t.closed = true
 	t.cndAccept.Broadcast()
+
+	if t.writes != nil {
+		close(t.writes)
+	}
+
 	return err
 }
 
@@ -184,28 +239,163 @@ func (t *transport) reader() {
 			return
 		}
 
-		conn, created := t.getConnection(addr)
-		queued := false
+		if conn := t.receivingConnection(addr); conn != nil {
+			conn.halfPipe.PushMessage(b[:n])
+		}
+	}
+}
+
+func (t *transport) batchReader() {
+	var (
+		msgs = make([]Message, t.batch.BatchSize())
+	)
+
+	defer func() {
+		for _, msg := range msgs {
+			msg.Buffer.Free()
+		}
+	}()
 
-		if created {
-			t.mtxAccept.Lock()
-			if len(t.acceptQueue) < 1024 {
-				t.acceptQueue = append(t.acceptQueue, conn)
-				t.cndAccept.Signal()
-				queued = true
+	for {
+		for i := range msgs {
+			if msgs[i].Buffer == nil {
+				msgs[i].Buffer = bufpool.New()
 			}
-			t.mtxAccept.Unlock()
+		}
 
-			if !queued {
-				t.dropConnection(addr)
-				conn = nil
+		n, err := t.batch.ReadBatch(msgs)
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			buf, addr := msgs[i].Buffer, msgs[i].Addr
+			msgs[i] = Message{}
+
+			if conn := t.receivingConnection(addr); conn != nil {
+				conn.halfPipe.PushBuffer(buf)
+			} else {
+				buf.Free()
 			}
 		}
+	}
+}
 
-		if conn != nil {
-			conn.halfPipe.PushMessage(b[:n])
+// batchWriter combines concurrent writes into batches.
+func (t *transport) batchWriter() {
+	var (
+		size = t.batch.BatchSize()
+		reqs = make([]*writeRequest, 0, size)
+		msgs = make([]Message, size)
+	)
+
+	for req := range t.writes {
+		reqs = append(reqs[:0], req)
+
+	FILL:
+		for len(reqs) < size {
+			select {
+			case req, ok := <-t.writes:
+				if !ok {
+					break FILL
+				}
+				reqs = append(reqs, req)
+			default:
+				break FILL
+			}
+		}
+
+		for i, req := range reqs {
+			msgs[i] = req.msg
+		}
+
+		// a failed message is reported to its own writer and the remaining
+		// messages are retried
+		for sent := 0; sent < len(reqs); {
+			n, err := t.batch.WriteBatch(msgs[sent:len(reqs)])
+			sent += n
+			if sent == len(reqs) {
+				break
+			}
+			if err == nil {
+				err = io.ErrShortWrite
+			}
+			reqs[sent].err = err
+			sent++
 		}
+
+		for i, req := range reqs {
+			msgs[i] = Message{}
+			req.done <- struct{}{}
+		}
+	}
+}
+
+// receivingConnection returns the connection for a received datagram.
+// New connections are queued for Accept. nil is returned when the accept
+// queue is full.
+func (t *transport) receivingConnection(addr Addr) *connection {
+	conn, created := t.getConnection(addr)
+	if !created {
+		return conn
+	}
+
+	queued := false
+
+	t.mtxAccept.Lock()
+	if len(t.acceptQueue) < 1024 {
+		t.acceptQueue = append(t.acceptQueue, conn)
+		t.cndAccept.Signal()
+		queued = true
+	}
+	t.mtxAccept.Unlock()
+
+	if !queued {
+		t.dropConnection(addr)
+		return nil
+	}
+
+	return conn
+}
+
+func (t *transport) write(b []byte, addr Addr) (int, error) {
+	if t.writes == nil {
+		return t.inner.Write(b, addr)
+	}
+
+	// write directly when there are no concurrent writers
+	if atomic.AddInt32(&t.writing, 1) == 1 {
+		n, err := t.inner.Write(b, addr)
+		atomic.AddInt32(&t.writing, -1)
+		return n, err
+	}
+	defer atomic.AddInt32(&t.writing, -1)
+
+	req := writeRequestPool.Get().(*writeRequest)
+	req.msg = Message{bufpool.New().Set(b), addr}
+	req.err = nil
+
+	t.mtx.RLock()
+	if t.closed {
+		t.mtx.RUnlock()
+		req.msg.Buffer.Free()
+		writeRequestPool.Put(req)
+		return 0, io.EOF
+	}
+	t.writes <- req
+	t.mtx.RUnlock()
+
+	<-req.done
+
+	err := req.err
+	req.msg.Buffer.Free()
+	req.msg = Message{}
+	writeRequestPool.Put(req)
+
+	if err != nil {
+		return 0, err
 	}
+	return len(b), nil
 }
 
 func (c *connection) Read(b []byte) (n int, err error) {
@@ -224,7 +414,7 @@ func (c *connection) Write(b []byte) (n int, err error) {
 	}
 	c.mtx.RUnlock()
 
-	return c.transport.inner.Write(b, c.raddr)
+	return c.transport.write(b, c.raddr)
 }
 
 func (c *connection) Close() error {
@@ -0,0 +1,136 @@
+package dgram
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+var errRejected = errors.New("rejected")
+
+type mockAddr string
+
+func (a mockAddr) Network() string  { return "mock" }
+func (a mockAddr) String() string   { return string(a) }
+func (a mockAddr) Key() interface{} { return a }
+
+// mockBatchTransport blocks all writes until the gate is closed and rejects
+// the messages sent to the "bad" address.
+type mockBatchTransport struct {
+	gate    chan struct{}
+	batches chan int
+	closed  chan struct{}
+}
+
+func (t *mockBatchTransport) Addrs() []net.Addr { return nil }
+func (t *mockBatchTransport) BatchSize() int    { return 8 }
+
+func (t *mockBatchTransport) NormalizeAddr(addr net.Addr) (Addr, error) {
+	return mockAddr(addr.String()), nil
+}
+
+func (t *mockBatchTransport) Read(b []byte) (int, Addr, error) {
+	<-t.closed
+	return 0, nil, errors.New("closed")
+}
+
+func (t *mockBatchTransport) ReadBatch(msgs []Message) (int, error) {
+	<-t.closed
+	return 0, errors.New("closed")
+}
+
+func (t *mockBatchTransport) Write(b []byte, addr Addr) (int, error) {
+	<-t.gate
+	return len(b), nil
+}
+
+func (t *mockBatchTransport) WriteBatch(msgs []Message) (int, error) {
+	t.batches <- len(msgs)
+	<-t.gate
+	for i, msg := range msgs {
+		if msg.Addr == mockAddr("bad") {
+			return i, errRejected
+		}
+	}
+	return len(msgs), nil
+}
+
+func (t *mockBatchTransport) Close() error {
+	close(t.closed)
+	return nil
+}
+
+func TestBatchWriteErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := &mockBatchTransport{
+		gate:    make(chan struct{}),
+		batches: make(chan int, 8),
+		closed:  make(chan struct{}),
+	}
+	trans, err := Wrap(mock)
+	if !assert.NoError(err) {
+		return
+	}
+	defer trans.Close()
+	x := trans.(*transport)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+		mtx  sync.Mutex
+	)
+
+	write := func(name string, addr mockAddr) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := x.write([]byte(name), addr)
+			mtx.Lock()
+			errs[name] = err
+			mtx.Unlock()
+		}()
+	}
+
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// the first write blocks in the direct path and the second in the first
+	// batch, the others are queued for the second batch.
+	write("direct", "good")
+	waitFor(func() bool { return len(x.writes) == 0 && atomic.LoadInt32(&x.writing) == 1 })
+	write("first", "good")
+	assert.Equal(1, <-mock.batches)
+	write("bad", "bad")
+	waitFor(func() bool { return len(x.writes) == 1 })
+	write("second", "good")
+	waitFor(func() bool { return len(x.writes) == 2 })
+	write("third", "good")
+	waitFor(func() bool { return len(x.writes) == 3 })
+
+	close(mock.gate)
+	wg.Wait()
+
+	assert.Equal(3, <-mock.batches)
+	select {
+	case n := <-mock.batches:
+		assert.Equal(2, n)
+	default:
+		t.Error("the messages after the failed one must be retried")
+	}
+
+	assert.NoError(errs["direct"])
+	assert.NoError(errs["first"])
+	assert.Equal(errRejected, errs["bad"])
+	assert.NoError(errs["second"])
+	assert.NoError(errs["third"])
+}
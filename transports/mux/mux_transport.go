@@ -32,6 +32,7 @@ type transport struct {
 	transports []transports.Transport
 	cAccept    chan net.Conn
 	wg         sync.WaitGroup
+	racer      *racer
 }
 
 // Open opens the sub-transports.
@@ -67,6 +68,10 @@ func (t *transport) Addrs() []net.Addr {
 }
 
 func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	if t.racer != nil {
+		return t.raceDial(addr)
+	}
+
 	for _, s := range t.transports {
 		conn, err := s.Dial(addr)
 		if err == transports.ErrInvalidAddr {
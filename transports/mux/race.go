@@ -0,0 +1,199 @@
+package mux
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+var _ transports.Config = RaceConfig{}
+
+const (
+	defaultStagger = 250 * time.Millisecond
+	latency_α      = 0.45
+)
+
+// RaceConfig is like Config except that Dial attempts all the sub-transports
+// concurrently (happy eyeballs). Attempts are started with staggered delays in
+// order of preference. The first successful connection is used and the others
+// are closed.
+//
+//   e3x.New(keys, mux.RaceConfig{
+//     Transports: mux.Config{
+//       tcp.Config{},
+//       http.Config{},
+//     },
+//     ByLatency: true,
+//   })
+type RaceConfig struct {
+	// Transports are the sub-transports in order of preference.
+	Transports Config
+
+	// Stagger is the delay between the start of two consecutive dial attempts.
+	// The next attempt is started immediately when an attempt fails.
+	// Stagger defaults to 250ms.
+	Stagger time.Duration
+
+	// ByLatency prefers the sub-transports with the lowest observed dial
+	// latency. Sub-transports that were never dialed keep their configured
+	// order but come after those with observations.
+	ByLatency bool
+}
+
+type racer struct {
+	stagger   time.Duration
+	byLatency bool
+
+	mtx     sync.Mutex
+	latency []time.Duration
+}
+
+type raceResult struct {
+	idx     int
+	conn    net.Conn
+	err     error
+	latency time.Duration
+}
+
+// Open opens the sub-transports.
+func (c RaceConfig) Open() (transports.Transport, error) {
+	tr, err := c.Transports.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	t := tr.(*transport)
+	t.racer = &racer{
+		stagger:   c.Stagger,
+		byLatency: c.ByLatency,
+		latency:   make([]time.Duration, len(t.transports)),
+	}
+
+	if t.racer.stagger <= 0 {
+		t.racer.stagger = defaultStagger
+	}
+
+	return t, nil
+}
+
+func (t *transport) raceDial(addr net.Addr) (net.Conn, error) {
+	var (
+		order   = t.racer.order()
+		results = make(chan raceResult, len(order))
+		next    int
+		pending int
+		timeout <-chan time.Time
+		lastErr = transports.ErrInvalidAddr
+	)
+
+	start := func() {
+		idx := order[next]
+		next++
+		pending++
+
+		go func() {
+			begin := time.Now()
+			conn, err := t.transports[idx].Dial(addr)
+			results <- raceResult{idx, conn, err, time.Since(begin)}
+		}()
+
+		if next < len(order) {
+			timeout = time.After(t.racer.stagger)
+		} else {
+			timeout = nil
+		}
+	}
+
+	if len(order) == 0 {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	start()
+
+	for pending > 0 {
+		select {
+
+		case <-timeout:
+			start()
+
+		case res := <-results:
+			pending--
+
+			if res.err == nil {
+				t.racer.observe(res.idx, res.latency)
+				go t.racer.cancel(results, pending)
+				return res.conn, nil
+			}
+
+			if res.err != transports.ErrInvalidAddr {
+				lastErr = res.err
+			}
+
+			if next < len(order) {
+				start()
+			}
+
+		}
+	}
+
+	return nil, lastErr
+}
+
+// order returns the indexes of the sub-transports in the order they must be
+// dialed.
+func (r *racer) order() []int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	order := make([]int, len(r.latency))
+	for i := range order {
+		order[i] = i
+	}
+
+	if r.byLatency {
+		sort.Stable(byLatency{order, r.latency})
+	}
+
+	return order
+}
+
+func (r *racer) observe(idx int, d time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.latency[idx] == 0 {
+		r.latency[idx] = d
+	} else {
+		r.latency[idx] = time.Duration(latency_α*float64(d) + (1.0-latency_α)*float64(r.latency[idx]))
+	}
+}
+
+// cancel waits for the losing dial attempts. Their latencies are still
+// recorded but their connections are closed.
+func (r *racer) cancel(results <-chan raceResult, pending int) {
+	for ; pending > 0; pending-- {
+		res := <-results
+		if res.err == nil {
+			r.observe(res.idx, res.latency)
+			res.conn.Close()
+		}
+	}
+}
+
+type byLatency struct {
+	order   []int
+	latency []time.Duration
+}
+
+func (s byLatency) Len() int      { return len(s.order) }
+func (s byLatency) Swap(i, j int) { s.order[i], s.order[j] = s.order[j], s.order[i] }
+func (s byLatency) Less(i, j int) bool {
+	a, b := s.latency[s.order[i]], s.latency[s.order[j]]
+	if a == 0 || b == 0 {
+		return a != 0 && b == 0
+	}
+	return a < b
+}
@@ -0,0 +1,181 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+func TestRaceFastestWins(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		slow = &fakeConfig{name: "slow", delay: 200 * time.Millisecond}
+		fast = &fakeConfig{name: "fast", delay: 10 * time.Millisecond}
+	)
+
+	tr, err := RaceConfig{Transports: Config{slow, fast}, Stagger: 20 * time.Millisecond}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	begin := time.Now()
+	conn, err := tr.Dial(&fakeAddr{})
+	if assert.NoError(err) {
+		assert.Equal("fast", conn.(*fakeConn).name)
+		assert.True(time.Since(begin) < 150*time.Millisecond)
+	}
+
+	// the loser is closed once it completes
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(1, slow.Closed())
+	assert.Equal(0, fast.Closed())
+}
+
+func TestRaceSkipsInvalidAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		invalid = &fakeConfig{name: "invalid", err: transports.ErrInvalidAddr}
+		valid   = &fakeConfig{name: "valid"}
+	)
+
+	tr, err := RaceConfig{Transports: Config{invalid, valid}, Stagger: time.Second}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	begin := time.Now()
+	conn, err := tr.Dial(&fakeAddr{})
+	if assert.NoError(err) {
+		assert.Equal("valid", conn.(*fakeConn).name)
+		assert.True(time.Since(begin) < 500*time.Millisecond)
+	}
+}
+
+func TestRaceAllFail(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		errBroken = errors.New("broken")
+		invalid   = &fakeConfig{name: "invalid", err: transports.ErrInvalidAddr}
+		broken    = &fakeConfig{name: "broken", err: errBroken}
+	)
+
+	tr, err := RaceConfig{Transports: Config{invalid, invalid}}.Open()
+	if assert.NoError(err) {
+		_, err = tr.Dial(&fakeAddr{})
+		assert.Equal(transports.ErrInvalidAddr, err)
+		tr.Close()
+	}
+
+	tr, err = RaceConfig{Transports: Config{broken, invalid}}.Open()
+	if assert.NoError(err) {
+		_, err = tr.Dial(&fakeAddr{})
+		assert.Equal(errBroken, err)
+		tr.Close()
+	}
+}
+
+func TestRaceByLatency(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		slow = &fakeConfig{name: "slow", delay: 50 * time.Millisecond}
+		fast = &fakeConfig{name: "fast", delay: 5 * time.Millisecond}
+	)
+
+	tr, err := RaceConfig{Transports: Config{slow, fast}, Stagger: time.Second, ByLatency: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	// without observations the configured order is used
+	conn, err := tr.Dial(&fakeAddr{})
+	if assert.NoError(err) {
+		assert.Equal("slow", conn.(*fakeConn).name)
+	}
+
+	// only the slow transport has been observed
+	assert.Equal([]int{0, 1}, tr.(*transport).racer.order())
+
+	tr.(*transport).racer.observe(1, 5*time.Millisecond)
+	assert.Equal([]int{1, 0}, tr.(*transport).racer.order())
+
+	conn, err = tr.Dial(&fakeAddr{})
+	if assert.NoError(err) {
+		assert.Equal("fast", conn.(*fakeConn).name)
+	}
+}
+
+type fakeConfig struct {
+	name  string
+	delay time.Duration
+	err   error
+
+	mtx    sync.Mutex
+	closed int
+}
+
+type fakeTransport struct {
+	config *fakeConfig
+	done   chan struct{}
+}
+
+type fakeConn struct {
+	net.Conn
+	name   string
+	config *fakeConfig
+}
+
+type fakeAddr struct{}
+
+func (c *fakeConfig) Open() (transports.Transport, error) {
+	return &fakeTransport{c, make(chan struct{})}, nil
+}
+
+func (c *fakeConfig) Closed() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.closed
+}
+
+func (t *fakeTransport) Addrs() []net.Addr { return nil }
+
+func (t *fakeTransport) Dial(addr net.Addr) (net.Conn, error) {
+	time.Sleep(t.config.delay)
+	if t.config.err != nil {
+		return nil, t.config.err
+	}
+	return &fakeConn{name: t.config.name, config: t.config}, nil
+}
+
+func (t *fakeTransport) Accept() (net.Conn, error) {
+	<-t.done
+	return nil, io.EOF
+}
+
+func (t *fakeTransport) Close() error {
+	close(t.done)
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.config.mtx.Lock()
+	c.config.closed++
+	c.config.mtx.Unlock()
+	return nil
+}
+
+func (a *fakeAddr) Network() string { return "fake" }
+func (a *fakeAddr) String() string  { return "fake" }
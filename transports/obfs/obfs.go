@@ -0,0 +1,321 @@
+// Package obfs implements a transport wrapper that makes telehash traffic
+// harder to fingerprint.
+//
+// Every packet is prefixed with a random nonce, the packet length and the
+// first bytes of the packet (which contain the recognizable handshake prefix
+// and the routing token) are XOR-masked and the packet is padded with random
+// bytes to one of a set of size buckets:
+//
+//   nonce [8] | mask(link [8]) | linkMask(length [2] | packet[:30]) | packet[30:] | padding
+//
+// Each conn picks a random link ID. The link key is derived from the
+// pre-shared secret and the link ID of the writing conn, so every link masks
+// its packets with its own key. The link ID itself is masked with a key that
+// is derived from the secret only. The masks are derived from the nonce and
+// these keys. Both ends of a link must use the same secret.
+//
+// Packets are never padded beyond the largest bucket; writing a packet which
+// doesn't fit in the largest bucket fails.
+//
+// This is not encryption; the packets themselves are already encrypted by
+// the cipher sets.
+package obfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+var (
+	_ transports.Config    = Config{}
+	_ transports.Transport = (*transport)(nil)
+	_ net.Conn             = (*conn)(nil)
+)
+
+const (
+	nonceSize  = 8
+	linkSize   = 8
+	lengthSize = 2
+	maskSize   = sha256.Size
+	overhead   = nonceSize + linkSize + lengthSize
+	maxPacket  = 2048
+)
+
+// DefaultBuckets are the packet sizes used when Config.Buckets is empty.
+var DefaultBuckets = []int{128, 256, 512, 1024, 1472}
+
+// Config for the obfs transport.
+//
+//   e3x.New(keys, obfs.Config{
+//     Config: udp.Config{},
+//     Secret: []byte("correct horse battery staple"),
+//   })
+type Config struct {
+	Config transports.Config // the sub-transport configuration
+
+	// Secret is the pre-shared secret. When Secret is empty the sub-transport
+	// is used as-is.
+	Secret []byte
+
+	// Buckets are the sizes (in ascending order) the packets are padded to.
+	// Packets are padded to either the smallest bucket they fit in or the next
+	// one (picked at random). The largest bucket limits the size of the
+	// packets to the largest bucket minus 18 bytes. Buckets defaults to
+	// DefaultBuckets.
+	Buckets []int
+}
+
+type transport struct {
+	t       transports.Transport
+	key     []byte // masks the link IDs
+	buckets []int
+}
+
+type conn struct {
+	net.Conn
+	t    *transport
+	link [linkSize]byte
+	pool sync.Pool // of *writeBuffer
+
+	mtxRead sync.Mutex
+	rmac    hash.Hash // masks the link IDs
+	rlink   [linkSize]byte
+	rlmac   hash.Hash // masks the packets of rlink
+	rmask   [maskSize]byte
+	rbuf    [maxPacket]byte
+}
+
+// Open opens the sub-transport.
+func (c Config) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Secret) == 0 {
+		return t, nil
+	}
+
+	if len(c.Buckets) == 0 {
+		c.Buckets = DefaultBuckets
+	}
+
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte("telehash obfs link key"))
+
+	tr := &transport{
+		t:       t,
+		key:     mac.Sum(nil),
+		buckets: c.Buckets,
+	}
+
+	return tr, nil
+}
+
+// linkKey returns the key which masks the packets of link.
+func (t *transport) linkKey(link []byte) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte("telehash obfs link"))
+	mac.Write(link)
+	return mac.Sum(nil)
+}
+
+func (t *transport) newConn(c net.Conn) (*conn, error) {
+	oc := &conn{Conn: c, t: t}
+
+	_, err := io.ReadFull(rand.Reader, oc.link[:])
+	if err != nil {
+		return nil, err
+	}
+
+	key := t.linkKey(oc.link[:])
+	oc.pool.New = func() interface{} {
+		return &writeBuffer{mac: hmac.New(sha256.New, t.key), lmac: hmac.New(sha256.New, key)}
+	}
+
+	return oc, nil
+}
+
+type writeBuffer struct {
+	mac  hash.Hash
+	lmac hash.Hash
+	mask [maskSize]byte
+	rnd  [1]byte
+	data [maxPacket]byte
+}
+
+func (t *transport) Addrs() []net.Addr {
+	return t.t.Addrs()
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	c, err := t.t.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	oc, err := t.newConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return oc, nil
+}
+
+func (t *transport) Accept() (net.Conn, error) {
+	c, err := t.t.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	oc, err := t.newConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return oc, nil
+}
+
+func (t *transport) Close() error {
+	return t.t.Close()
+}
+
+// paddedSize returns the size of an obfuscated packet of which n bytes are
+// used. ok is false when n exceeds the largest bucket.
+func (t *transport) paddedSize(n int, random byte) (size int, ok bool) {
+	for i, size := range t.buckets {
+		if size < n {
+			continue
+		}
+		if random&1 == 1 && i+1 < len(t.buckets) {
+			return t.buckets[i+1], true
+		}
+		return size, true
+	}
+	return 0, false
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.mtxRead.Lock()
+	defer c.mtxRead.Unlock()
+
+	for {
+		n, err := c.Conn.Read(c.rbuf[:])
+		if err != nil {
+			return 0, err
+		}
+
+		pkt, ok := c.unmask(c.rbuf[:n])
+		if !ok {
+			continue // drop
+		}
+
+		if len(pkt) > len(b) {
+			return 0, io.ErrShortBuffer
+		}
+
+		return copy(b, pkt), nil
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if len(b)+overhead > maxPacket {
+		return 0, io.ErrShortWrite
+	}
+
+	buf := c.pool.Get().(*writeBuffer)
+	defer c.pool.Put(buf)
+
+	_, err := io.ReadFull(rand.Reader, buf.data[:nonceSize])
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = io.ReadFull(rand.Reader, buf.rnd[:])
+	if err != nil {
+		return 0, err
+	}
+
+	used := overhead + len(b)
+	size, ok := c.t.paddedSize(used, buf.rnd[0])
+	if !ok {
+		return 0, io.ErrShortWrite
+	}
+	out := buf.data[:size]
+
+	copy(out[nonceSize:], c.link[:])
+	binary.BigEndian.PutUint16(out[nonceSize+linkSize:], uint16(len(b)))
+	copy(out[overhead:], b)
+
+	if size > used {
+		_, err = io.ReadFull(rand.Reader, out[used:])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	xorMask(buf.mac, buf.mask[:0], out[:nonceSize], out[nonceSize:nonceSize+linkSize])
+	xorMask(buf.lmac, buf.mask[:0], out[:nonceSize], out[nonceSize+linkSize:])
+
+	_, err = c.Conn.Write(out)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// unmask returns the packet contained in data. data is modified in place.
+func (c *conn) unmask(data []byte) ([]byte, bool) {
+	if len(data) < overhead {
+		return nil, false
+	}
+
+	if c.rmac == nil {
+		c.rmac = hmac.New(sha256.New, c.t.key)
+	}
+
+	var (
+		nonce = data[:nonceSize]
+		link  = data[nonceSize : nonceSize+linkSize]
+	)
+
+	xorMask(c.rmac, c.rmask[:0], nonce, link)
+	if c.rlmac == nil || !bytes.Equal(link, c.rlink[:]) {
+		// the remote conn changed
+		copy(c.rlink[:], link)
+		c.rlmac = hmac.New(sha256.New, c.t.linkKey(link))
+	}
+	xorMask(c.rlmac, c.rmask[:0], nonce, data[nonceSize+linkSize:])
+
+	n := int(binary.BigEndian.Uint16(data[nonceSize+linkSize:]))
+	if overhead+n > len(data) {
+		return nil, false
+	}
+
+	return data[overhead : overhead+n], true
+}
+
+// xorMask masks (or unmasks) the first bytes of data with the mask for nonce.
+func xorMask(mac hash.Hash, mask, nonce, data []byte) {
+	mac.Reset()
+	mac.Write(nonce)
+	mask = mac.Sum(mask)
+
+	if len(data) > len(mask) {
+		data = data[:len(mask)]
+	}
+
+	for i := range data {
+		data[i] ^= mask[i]
+	}
+}
@@ -0,0 +1,213 @@
+package obfs
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+var handshake = append([]byte{0x00, 0x01, 0x3a}, bytes.Repeat([]byte{0xab}, 200)...)
+
+func TestRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Config: inproc.Config{}, Secret: []byte("secret")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Config: inproc.Config{}, Secret: []byte("secret")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	var r net.Conn
+
+	// the largest packet fills the last bucket
+	max := DefaultBuckets[len(DefaultBuckets)-1] - overhead
+
+	for _, size := range []int{0, 1, 20, 200, 1400, max - 2} {
+		msg := append([]byte{0x00, 0x01}, bytes.Repeat([]byte{'x'}, size)...)
+
+		n, err := w.Write(msg)
+		assert.NoError(err)
+		assert.Equal(len(msg), n)
+
+		if r == nil {
+			r = accept(t, B)
+		}
+
+		var buf [1500]byte
+		n, err = r.Read(buf[:])
+		if assert.NoError(err) {
+			assert.Equal(msg, buf[:n])
+		}
+	}
+}
+
+func TestOversizedPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Config: inproc.Config{}, Secret: []byte("secret")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	w, err := A.Dial(A.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	max := DefaultBuckets[len(DefaultBuckets)-1] - overhead
+	n, err := w.Write(make([]byte, max+1))
+	assert.Equal(io.ErrShortWrite, err)
+	assert.Equal(0, n)
+}
+
+func TestLinkKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Config: inproc.Config{}, Secret: []byte("secret")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	a, err := A.Dial(A.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	b, err := A.Dial(A.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		x  = A.(*transport)
+		ca = a.(*conn)
+		cb = b.(*conn)
+	)
+
+	// every conn masks its packets with its own key
+	assert.False(bytes.Equal(ca.link[:], cb.link[:]))
+	assert.False(bytes.Equal(x.linkKey(ca.link[:]), x.linkKey(cb.link[:])))
+	assert.False(bytes.Equal(x.key, x.linkKey(ca.link[:])))
+}
+
+func TestWireFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Config: inproc.Config{}, Secret: []byte("secret")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	// B sees the raw packets
+	B, err := inproc.Config{}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		sizes = map[int]bool{}
+		r     net.Conn
+	)
+
+	for i := 0; i < 32; i++ {
+		_, err = w.Write(handshake)
+		assert.NoError(err)
+
+		if r == nil {
+			r = accept(t, B)
+		}
+
+		var buf [1500]byte
+		n, err := r.Read(buf[:])
+		if !assert.NoError(err) {
+			return
+		}
+
+		sizes[n] = true
+		assert.False(bytes.Contains(buf[:n], handshake[:20]), "expected the header to be masked")
+	}
+
+	// packets are padded to either the 256 or the 512 bucket
+	assert.Equal(map[int]bool{256: true, 512: true}, sizes)
+}
+
+func TestWrongSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Config: inproc.Config{}, Secret: []byte("secret")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Config: inproc.Config{}, Secret: []byte("other")}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	// packets that don't decode are dropped
+	for i := 0; i < 8; i++ {
+		_, err = w.Write([]byte{0x00, 0x01})
+		assert.NoError(err)
+	}
+
+	r := accept(t, B)
+	r.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	var buf [1500]byte
+	_, err = r.Read(buf[:])
+	if nerr, ok := err.(net.Error); assert.True(ok, "err=%v", err) {
+		assert.True(nerr.Timeout())
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Config: inproc.Config{}}.Open()
+	if assert.NoError(err) {
+		_, wrapped := A.(*transport)
+		assert.False(wrapped)
+		A.Close()
+	}
+}
+
+func accept(t *testing.T, tr transports.Transport) net.Conn {
+	c, err := tr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
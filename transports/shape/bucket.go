@@ -0,0 +1,82 @@
+package shape
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket. Tokens are bytes.
+//
+// Reservations are allowed to put the bucket in debt. This way packets that
+// are larger than the burst size still pass and prioritized packets delay the
+// packets that follow them.
+type bucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns nil when l is unlimited. A nil bucket never delays.
+func newBucket(l Limit, now time.Time) *bucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+
+	if l.Burst <= 0 {
+		l.Burst = l.Rate
+	}
+
+	return &bucket{
+		rate:   float64(l.Rate),
+		burst:  float64(l.Burst),
+		tokens: float64(l.Burst),
+		last:   now,
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller must
+// wait before the tokens are actually available.
+func (b *bucket) reserve(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.refill(now)
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle returns true when the bucket is full.
+func (b *bucket) idle(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		b.last = now
+	}
+
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
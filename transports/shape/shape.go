@@ -0,0 +1,249 @@
+// Package shape implements a traffic shaping transport wrapper.
+//
+// The wrapper enforces upload and download rate limits on a sub-transport.
+// Limits can be applied to all the traffic and to the traffic of each remote
+// address. Packets that exceed a limit are delayed until enough tokens are
+// available.
+package shape
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+var (
+	_ transports.Config    = Config{}
+	_ transports.Transport = (*transport)(nil)
+	_ net.Conn             = (*conn)(nil)
+)
+
+// Config for the shape transport.
+//
+//   e3x.New(keys, shape.Config{
+//     Config:   udp.Config{},
+//     Upload:   shape.Limit{Rate: 64 << 10},
+//     Download: shape.Limit{Rate: 256 << 10},
+//     PrioritizeHandshakes: true,
+//   })
+type Config struct {
+	Config transports.Config // the sub-transport configuration
+
+	Upload   Limit // limit for all outgoing traffic
+	Download Limit // limit for all incoming traffic
+
+	PeerUpload   Limit // limit for the outgoing traffic of each remote address
+	PeerDownload Limit // limit for the incoming traffic of each remote address
+
+	// PrioritizeHandshakes lets handshake packets pass without delay. They
+	// still count towards the limits. This makes sure that new exchanges can
+	// be established while a link is saturated.
+	PrioritizeHandshakes bool
+}
+
+// Limit describes a token bucket.
+type Limit struct {
+	// Rate is the sustained rate in bytes per second.
+	// A Rate of 0 disables the limit.
+	Rate int
+
+	// Burst is the number of bytes that may be sent at once.
+	// Burst defaults to Rate (one second of traffic).
+	Burst int
+}
+
+type transport struct {
+	t      transports.Transport
+	config Config
+	done   chan struct{}
+
+	upload   *bucket
+	download *bucket
+
+	mtx   sync.Mutex
+	peers map[string]*peer
+}
+
+type peer struct {
+	upload   *bucket
+	download *bucket
+	refs     int // live conns using the buckets
+}
+
+type conn struct {
+	net.Conn
+	t         *transport
+	peer      *peer
+	closeOnce sync.Once
+}
+
+// Open opens the sub-transport.
+func (c Config) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &transport{
+		t:        t,
+		config:   c,
+		done:     make(chan struct{}),
+		upload:   newBucket(c.Upload, time.Now()),
+		download: newBucket(c.Download, time.Now()),
+		peers:    make(map[string]*peer),
+	}, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	return t.t.Addrs()
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	c, err := t.t.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(c), nil
+}
+
+func (t *transport) Accept() (net.Conn, error) {
+	c, err := t.t.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(c), nil
+}
+
+func (t *transport) Close() error {
+	t.mtx.Lock()
+	select {
+	case <-t.done:
+		t.mtx.Unlock()
+		return nil
+	default:
+		close(t.done)
+	}
+	t.mtx.Unlock()
+
+	return t.t.Close()
+}
+
+func (t *transport) wrap(c net.Conn) net.Conn {
+	return &conn{Conn: c, t: t, peer: t.getPeer(c.RemoteAddr())}
+}
+
+func (t *transport) getPeer(addr net.Addr) *peer {
+	if t.config.PeerUpload.Rate <= 0 && t.config.PeerDownload.Rate <= 0 {
+		return &peer{}
+	}
+
+	var (
+		key = addr.Network() + "|" + addr.String()
+		now = time.Now()
+	)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	p := t.peers[key]
+	if p == nil {
+		t.expirePeers(now)
+
+		p = &peer{
+			upload:   newBucket(t.config.PeerUpload, now),
+			download: newBucket(t.config.PeerDownload, now),
+		}
+		t.peers[key] = p
+	}
+	p.refs++
+
+	return p
+}
+
+// releasePeer is called when a conn using p is closed.
+func (t *transport) releasePeer(p *peer) {
+	t.mtx.Lock()
+	p.refs--
+	t.mtx.Unlock()
+}
+
+// expirePeers removes the peers without live conns and with full buckets as
+// they are indistinguishable from new peers.
+func (t *transport) expirePeers(now time.Time) {
+	for key, p := range t.peers {
+		if p.refs == 0 && p.upload.idle(now) && p.download.idle(now) {
+			delete(t.peers, key)
+		}
+	}
+}
+
+// wait blocks until n bytes may pass both buckets.
+func (t *transport) wait(global, local *bucket, n int, prioritized bool) error {
+	var (
+		now = time.Now()
+		d1  = global.reserve(n, now)
+		d2  = local.reserve(n, now)
+	)
+
+	if d2 > d1 {
+		d1 = d2
+	}
+
+	if d1 <= 0 || prioritized {
+		return nil
+	}
+
+	timer := time.NewTimer(d1)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.done:
+		return io.EOF
+	}
+}
+
+func (t *transport) isPrioritized(b []byte) bool {
+	return t.config.PrioritizeHandshakes && isHandshake(b)
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		return n, err
+	}
+
+	err = c.t.wait(c.t.download, c.peer.download, n, c.t.isPrioritized(b[:n]))
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	err := c.t.wait(c.t.upload, c.peer.upload, len(b), c.t.isPrioritized(b))
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(b)
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() { c.t.releasePeer(c.peer) })
+	return c.Conn.Close()
+}
+
+// isHandshake returns true when b looks like an e3x handshake packet.
+// Handshakes have a one byte lob header (the csid) while channel packets have
+// no lob header.
+func isHandshake(b []byte) bool {
+	return len(b) >= 3 && b[0] == 0 && b[1] == 1
+}
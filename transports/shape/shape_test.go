@@ -0,0 +1,199 @@
+package shape
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		now = time.Now()
+		b   = newBucket(Limit{Rate: 1000, Burst: 500}, now)
+	)
+
+	assert.Equal(time.Duration(0), b.reserve(500, now))
+	assert.Equal(100*time.Millisecond, b.reserve(100, now))
+	assert.Equal(300*time.Millisecond, b.reserve(200, now))
+	assert.False(b.idle(now))
+
+	// the debt is paid after 300ms and the bucket is full after 800ms
+	assert.Equal(time.Duration(0), b.reserve(100, now.Add(400*time.Millisecond)))
+	assert.False(b.idle(now.Add(800 * time.Millisecond)))
+	assert.True(b.idle(now.Add(900 * time.Millisecond)))
+
+	// a nil bucket is unlimited
+	assert.Nil(newBucket(Limit{}, now))
+	assert.Equal(time.Duration(0), (*bucket)(nil).reserve(1<<20, now))
+}
+
+func TestUploadLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{
+		Config: inproc.Config{},
+		Upload: Limit{Rate: 100000, Burst: 10000},
+	}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := inproc.Config{}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	c, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	go drain(B)
+
+	var (
+		msg   = bytes.Repeat([]byte{'x'}, 1000)
+		begin = time.Now()
+	)
+
+	// 10KB burst + 50KB at 100KB/s
+	for i := 0; i < 60; i++ {
+		_, err = c.Write(msg)
+		assert.NoError(err)
+	}
+
+	elapsed := time.Since(begin)
+	assert.True(elapsed >= 450*time.Millisecond, "elapsed=%s", elapsed)
+}
+
+func TestExpirePeers(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{
+		Config:     inproc.Config{},
+		PeerUpload: Limit{Rate: 1000},
+	}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := inproc.Config{}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	c, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		x     = A.(*transport)
+		later = time.Now().Add(time.Hour)
+	)
+
+	// the buckets of a live conn are kept even when they are idle
+	x.mtx.Lock()
+	x.expirePeers(later)
+	assert.Equal(1, len(x.peers))
+	x.mtx.Unlock()
+
+	assert.NoError(c.Close())
+	assert.NoError(c.Close())
+
+	x.mtx.Lock()
+	x.expirePeers(later)
+	assert.Equal(0, len(x.peers))
+	x.mtx.Unlock()
+}
+
+func TestPrioritizeHandshakes(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{
+		Config:               inproc.Config{},
+		PeerUpload:           Limit{Rate: 1000, Burst: 1000},
+		PrioritizeHandshakes: true,
+	}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := inproc.Config{}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	c, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	go drain(B)
+
+	var (
+		packet    = append([]byte{0, 0}, bytes.Repeat([]byte{'x'}, 998)...)
+		handshake = append([]byte{0, 1, 0x3a}, bytes.Repeat([]byte{'x'}, 997)...)
+	)
+
+	// saturate the link
+	_, err = c.Write(packet)
+	assert.NoError(err)
+
+	begin := time.Now()
+	_, err = c.Write(handshake)
+	assert.NoError(err)
+	assert.True(time.Since(begin) < 100*time.Millisecond)
+
+	// the handshake still counts towards the limit
+	done := make(chan struct{})
+	go func() {
+		c.Write(packet)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected the packet to be delayed")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// closing the transport aborts pending writes
+	A.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the write to be aborted")
+	}
+}
+
+func drain(t transports.Transport) {
+	for {
+		c, err := t.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			var buf [1500]byte
+			for {
+				_, err := c.Read(buf[:])
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
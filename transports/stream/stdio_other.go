@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package stream
+
+import (
+	"os"
+)
+
+// openStdin returns os.Stdin. A pending read is only interrupted when the
+// platform unblocks reads on close.
+func openStdin() (*os.File, error) {
+	return os.Stdin, nil
+}
+
+func dupFile(f *os.File) (*os.File, error) {
+	return f, nil
+}
@@ -0,0 +1,38 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package stream
+
+import (
+	"os"
+	"syscall"
+)
+
+// openStdin returns a non-blocking copy of stdin. Non-blocking files are
+// handled by the runtime poller so closing the copy interrupts a pending
+// read.
+func openStdin() (*os.File, error) {
+	fd, err := syscall.Dup(syscall.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+
+	err = syscall.SetNonblock(fd, true)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), "/dev/stdin"), nil
+}
+
+func dupFile(f *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
@@ -0,0 +1,104 @@
+//go:build linux && (386 || amd64 || arm || arm64)
+// +build linux
+// +build 386 amd64 arm arm64
+
+package stream
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+const (
+	ioctlTCGETS     = 0x5401
+	ioctlTCSETS     = 0x5402
+	ioctlTIOCGPTN   = 0x80045430
+	ioctlTIOCSPTLCK = 0x40045431
+)
+
+func TestPty(t *testing.T) {
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skipf("pty not available: %s", err)
+	}
+
+	A, err := Config{Stream: fixed(master)}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	B, err := Config{Stream: fixed(slave)}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	pingPong(t, A, B)
+}
+
+// openPty opens a new pseudo terminal pair and puts the slave in raw mode so
+// the framed packets pass through the line discipline unchanged.
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	err = ioctl(master.Fd(), ioctlTIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	var n uint32
+	err = ioctl(master.Fd(), ioctlTIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	var tio syscall.Termios
+	err = ioctl(slave.Fd(), ioctlTCGETS, uintptr(unsafe.Pointer(&tio)))
+	if err == nil {
+		makeRaw(&tio)
+		err = ioctl(slave.Fd(), ioctlTCSETS, uintptr(unsafe.Pointer(&tio)))
+	}
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
+
+// makeRaw is equivalent to cfmakeraw(3).
+func makeRaw(tio *syscall.Termios) {
+	tio.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	tio.Oflag &^= syscall.OPOST
+	tio.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	tio.Cflag &^= syscall.CSIZE | syscall.PARENB
+	tio.Cflag |= syscall.CS8
+	tio.Cc[syscall.VMIN] = 1
+	tio.Cc[syscall.VTIME] = 0
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+// Package stream implements a point-to-point transport over any
+// io.ReadWriteCloser (stdin/stdout, serial ports, pipes between processes).
+//
+// Packets are framed with the same 2 byte length prefix that is used by the
+// tcp and unix transports.
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+func init() {
+	transports.RegisterAddr(&streamAddr{})
+
+	transports.RegisterResolver("stream", func(str string) (net.Addr, error) {
+		if str == "" {
+			return nil, transports.ErrInvalidAddr
+		}
+		return &streamAddr{name: str}, nil
+	})
+}
+
+// Config for the stream transport.
+//
+//   e3x.New(keys, stream.Config{Stream: stream.Stdio})
+type Config struct {
+	// Name of the stream. Both ends of the stream must use the same name.
+	// Name defaults to "stream".
+	Name string
+
+	// Stream is called when the transport is opened and must return the
+	// stream to run on. When the stream fails (EOF or a read error) it is
+	// closed and Stream is called again to reopen it. The stream is closed
+	// when the transport is closed.
+	Stream func() (io.ReadWriteCloser, error)
+}
+
+const (
+	cMinReopenDelay = 100 * time.Millisecond
+	cMaxReopenDelay = 5 * time.Second
+)
+
+type streamAddr struct {
+	name string
+}
+
+type transport struct {
+	laddr  *streamAddr
+	open   func() (io.ReadWriteCloser, error)
+	accept chan *connection
+	done   chan struct{}
+
+	mtx     sync.Mutex
+	stream  io.ReadWriteCloser // nil while the stream is reopened
+	current *connection
+	closed  bool
+
+	mtxWrite sync.Mutex
+}
+
+type connection struct {
+	transport *transport
+	pipe      *transportsutil.HalfPipe
+
+	// protected by transport.mtx
+	handedOut bool
+	closed    bool
+}
+
+var (
+	_ transports.Config    = Config{}
+	_ transports.Transport = (*transport)(nil)
+	_ net.Conn             = (*connection)(nil)
+)
+
+var (
+	errNoStream  = errors.New("stream: no stream configured")
+	errReopening = errors.New("stream: the stream is being reopened")
+)
+
+// Stdio returns a stream that reads from stdin and writes to stdout. This is
+// useful when the endpoint is started as an SSH ProxyCommand style process.
+//
+// The stream uses copies of the stdin and stdout descriptors. On unix stdin
+// is switched to non-blocking mode so closing the stream interrupts a pending
+// read.
+func Stdio() (io.ReadWriteCloser, error) {
+	stdin, err := openStdin()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := dupFile(os.Stdout)
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+
+	return &stdio{stdin, stdout}, nil
+}
+
+type stdio struct {
+	in  *os.File
+	out *os.File
+}
+
+func (s *stdio) Read(p []byte) (int, error)  { return s.in.Read(p) }
+func (s *stdio) Write(p []byte) (int, error) { return s.out.Write(p) }
+
+func (s *stdio) Close() error {
+	err := s.in.Close()
+	if err2 := s.out.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Open opens the transport.
+func (c Config) Open() (transports.Transport, error) {
+	if c.Stream == nil {
+		return nil, errNoStream
+	}
+
+	if c.Name == "" {
+		c.Name = "stream"
+	}
+
+	s, err := c.Stream()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transport{
+		laddr:  &streamAddr{name: c.Name},
+		open:   c.Stream,
+		stream: s,
+		accept: make(chan *connection),
+		done:   make(chan struct{}),
+	}
+	t.current = t.newConnection()
+
+	go t.reader(s)
+
+	return t, nil
+}
+
+func (t *transport) newConnection() *connection {
+	return &connection{transport: t, pipe: transportsutil.NewHalfPipe()}
+}
+
+// reader reads the packets from s and reopens the stream when it fails.
+func (t *transport) reader(s io.ReadWriteCloser) {
+	defer t.Close()
+
+	delay := cMinReopenDelay
+
+	for {
+		if t.readFrames(s) {
+			delay = cMinReopenDelay
+		}
+
+		if !t.dropStream(s) {
+			return
+		}
+
+		for {
+			select {
+			case <-time.After(delay):
+			case <-t.done:
+				return
+			}
+
+			if delay *= 2; delay > cMaxReopenDelay {
+				delay = cMaxReopenDelay
+			}
+
+			var err error
+			s, err = t.open()
+			if err == nil {
+				break
+			}
+		}
+
+		if !t.setStream(s) {
+			return
+		}
+	}
+}
+
+// readFrames reads packets from s until it fails or the transport is closed.
+// It returns true when at least one packet was read.
+func (t *transport) readFrames(s io.Reader) bool {
+	var (
+		bufr = bufio.NewReader(s)
+		read bool
+	)
+
+	for {
+		buf := bufpool.New()
+
+		n, err := transportsutil.ReadFrame(bufr, buf.RawBytes()[:1500])
+		if err == io.ErrShortBuffer {
+			buf.Free()
+			continue
+		}
+		if err != nil {
+			buf.Free()
+			return read
+		}
+		read = true
+
+		t.mtx.Lock()
+		if t.closed {
+			t.mtx.Unlock()
+			buf.Free()
+			return read
+		}
+		c := t.current
+		accept := !c.handedOut
+		c.handedOut = true
+		t.mtx.Unlock()
+
+		c.pipe.PushBuffer(buf.SetLen(n))
+
+		if accept {
+			select {
+			case t.accept <- c:
+			case <-t.done:
+				return read
+			}
+		}
+	}
+}
+
+// dropStream closes the failed stream s and the connection that was running
+// on it. It returns false when the transport is closed.
+func (t *transport) dropStream(s io.ReadWriteCloser) bool {
+	s.Close()
+
+	t.mtx.Lock()
+	if t.closed {
+		t.mtx.Unlock()
+		return false
+	}
+	t.stream = nil
+	c := t.current
+	c.closed = true
+	t.current = t.newConnection()
+	t.mtx.Unlock()
+
+	c.pipe.Close()
+	return true
+}
+
+// setStream installs the reopened stream s. It returns false (and closes s)
+// when the transport was closed in the meantime.
+func (t *transport) setStream(s io.ReadWriteCloser) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.closed {
+		s.Close()
+		return false
+	}
+
+	t.stream = s
+	return true
+}
+
+func (t *transport) Addrs() []net.Addr {
+	return []net.Addr{t.laddr}
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	a, ok := addr.(*streamAddr)
+	if !ok || a.name != t.laddr.name {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.closed {
+		return nil, io.EOF
+	}
+
+	// The connection is either handed out here or it was already handed out
+	// (either by an earlier Dial or by Accept). In both cases the reader will
+	// never pass it to Accept again.
+	t.current.handedOut = true
+	return t.current, nil
+}
+
+func (t *transport) Accept() (net.Conn, error) {
+	select {
+	case c := <-t.accept:
+		return c, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *transport) Close() error {
+	t.mtx.Lock()
+	if t.closed {
+		t.mtx.Unlock()
+		return nil
+	}
+	t.closed = true
+	c, s := t.current, t.stream
+	t.stream = nil
+	t.mtx.Unlock()
+
+	close(t.done)
+	c.pipe.Close()
+
+	if s == nil {
+		return nil
+	}
+	return s.Close()
+}
+
+func (t *transport) write(b []byte) (int, error) {
+	t.mtx.Lock()
+	s := t.stream
+	t.mtx.Unlock()
+
+	if s == nil {
+		return 0, errReopening
+	}
+
+	t.mtxWrite.Lock()
+	defer t.mtxWrite.Unlock()
+
+	return transportsutil.WriteFrame(s, b)
+}
+
+func (c *connection) Read(b []byte) (n int, err error) {
+	return c.pipe.Read(b)
+}
+
+func (c *connection) Write(b []byte) (n int, err error) {
+	t := c.transport
+
+	t.mtx.Lock()
+	closed := c.closed || t.closed
+	t.mtx.Unlock()
+
+	if closed {
+		return 0, io.EOF
+	}
+
+	return t.write(b)
+}
+
+func (c *connection) SetDeadline(t time.Time) error {
+	return c.pipe.SetReadDeadline(t)
+}
+
+func (c *connection) SetReadDeadline(t time.Time) error {
+	return c.pipe.SetReadDeadline(t)
+}
+
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *connection) LocalAddr() net.Addr {
+	return c.transport.laddr
+}
+
+func (c *connection) RemoteAddr() net.Addr {
+	return c.transport.laddr
+}
+
+// Close closes the connection but not the underlying stream. Packets received
+// after the connection was closed are delivered on a new connection.
+func (c *connection) Close() error {
+	t := c.transport
+
+	t.mtx.Lock()
+	if c.closed {
+		t.mtx.Unlock()
+		return nil
+	}
+	c.closed = true
+	if t.current == c && !t.closed {
+		t.current = t.newConnection()
+	}
+	t.mtx.Unlock()
+
+	return c.pipe.Close()
+}
+
+func (a *streamAddr) Network() string {
+	return "stream"
+}
+
+func (a *streamAddr) String() string {
+	return a.name
+}
+
+func (a *streamAddr) Equal(other net.Addr) bool {
+	b, ok := other.(*streamAddr)
+	return ok && a.name == b.name
+}
+
+func (a *streamAddr) MarshalJSON() ([]byte, error) {
+	var desc = struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}{
+		Type: a.Network(),
+		Name: a.name,
+	}
+	return json.Marshal(&desc)
+}
+
+func (a *streamAddr) UnmarshalJSON(data []byte) error {
+	var desc struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+
+	err := json.Unmarshal(data, &desc)
+	if err != nil {
+		return transports.ErrInvalidAddr
+	}
+
+	if desc.Name == "" {
+		return transports.ErrInvalidAddr
+	}
+
+	a.name = desc.Name
+	return nil
+}
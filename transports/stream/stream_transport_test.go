@@ -0,0 +1,254 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+func TestAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, err := transports.ResolveAddr("stream", "ttyS0")
+	if !assert.NoError(err) {
+		return
+	}
+
+	data, err := transports.EncodeAddr(addr)
+	assert.NoError(err)
+	assert.Equal(`{"type":"stream","name":"ttyS0"}`, string(data))
+
+	addr2, err := transports.DecodeAddr(data)
+	assert.NoError(err)
+	assert.True(transports.EqualAddr(addr, addr2))
+
+	_, err = transports.DecodeAddr([]byte(`{"type":"stream"}`))
+	assert.Equal(transports.ErrInvalidAddr, err)
+}
+
+func TestPipe(t *testing.T) {
+	assert := assert.New(t)
+
+	a, b := pipes(t)
+
+	A, err := Config{Stream: fixed(a)}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Stream: fixed(b)}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	pingPong(t, A, B)
+
+	// after closing both connections a new pair of connections is used
+	pingPong(t, A, B)
+}
+
+func TestReopen(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		a1, b1 = pipes(t)
+		a2, b2 = pipes(t)
+		opened = make(chan io.ReadWriteCloser, 2)
+	)
+	opened <- a1
+	opened <- a2
+
+	A, err := Config{Stream: func() (io.ReadWriteCloser, error) {
+		select {
+		case s := <-opened:
+			return s, nil
+		default:
+			return nil, io.EOF
+		}
+	}}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	w, err := A.Dial(A.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	// the remote end of the first stream goes away
+	b1.Close()
+
+	var out [1500]byte
+	_, err = w.Read(out[:])
+	assert.Equal(io.EOF, err)
+
+	// packets on the reopened stream are delivered on a new connection
+	defer b2.Close()
+	_, err = transportsutil.WriteFrame(b2, []byte("ping"))
+	assert.NoError(err)
+
+	r, err := A.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer r.Close()
+
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := r.Read(out[:])
+	if assert.NoError(err) {
+		assert.Equal("ping", string(out[:n]))
+	}
+
+	_, err = r.Write([]byte("pong"))
+	assert.NoError(err)
+	n, err = transportsutil.ReadFrame(b2, out[:])
+	if assert.NoError(err) {
+		assert.Equal("pong", string(out[:n]))
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	a, b := pipes(t)
+
+	A, err := e3x.Open(e3x.Log(nil), e3x.Transport(Config{Stream: fixed(a)}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(e3x.Log(nil), e3x.Transport(Config{Stream: fixed(b)}))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	l := B.Listen("ping", true)
+	defer l.Close()
+
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(pkt)
+	}()
+
+	Bident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := A.Open(Bident, "ping", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	pkt := lob.New([]byte("ping"))
+	assert.NoError(c.WritePacket(pkt))
+
+	pkt, err = c.ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal("ping", string(pkt.Body(nil)))
+	}
+}
+
+func pingPong(t *testing.T, A, B transports.Transport) {
+	var (
+		msg = bytes.Repeat([]byte{'x'}, 1450)
+		out [1500]byte
+	)
+
+	w, err := A.Dial(B.Addrs()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	_, err = w.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := B.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	n, err := r.Read(out[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out[:n], msg) {
+		t.Fatalf("invalid message")
+	}
+
+	_, err = r.Write([]byte("pong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err = w.Read(out[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out[:n]) != "pong" {
+		t.Fatalf("invalid message")
+	}
+}
+
+// pipes returns two streams that are connected with os.Pipe.
+func pipes(t *testing.T) (a, b io.ReadWriteCloser) {
+	ar, bw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br, aw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &rwc{ar, aw}, &rwc{br, bw}
+}
+
+func fixed(s io.ReadWriteCloser) func() (io.ReadWriteCloser, error) {
+	return func() (io.ReadWriteCloser, error) { return s, nil }
+}
+
+type rwc struct {
+	r *os.File
+	w *os.File
+}
+
+func (s *rwc) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *rwc) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+func (s *rwc) Close() error {
+	s.w.Close()
+	return s.r.Close()
+}
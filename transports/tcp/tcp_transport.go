@@ -3,9 +3,7 @@ package tcp
 
 import (
 	"bufio"
-	"encoding/binary"
 	"errors"
-	"io"
 	"net"
 	"sync"
 	"time"
@@ -165,51 +163,17 @@ func (t *transport) Close() error {
 }
 
 func (c *connection) Read(b []byte) (n int, err error) {
-	var hdr [2]byte
-
 	c.mtxRead.Lock()
 	defer c.mtxRead.Unlock()
 
-	_, err = io.ReadFull(c.bufr, hdr[:])
-	if err != nil {
-		return 0, err
-	}
-
-	msgLen := binary.BigEndian.Uint16(hdr[:])
-
-	return io.ReadFull(c.bufr, b[:msgLen])
+	return transportsutil.ReadFrame(c.bufr, b)
 }
 
 func (c *connection) Write(b []byte) (n int, err error) {
-	var lenB = len(b)
-	if lenB > 1472 {
-		return 0, io.ErrShortWrite
-	}
-
-	var hdr [2]byte
-	var hdrP = hdr[:]
-	binary.BigEndian.PutUint16(hdrP, uint16(lenB))
-
 	c.mtxWrite.Lock()
 	defer c.mtxWrite.Unlock()
 
-	for len(hdrP) > 0 {
-		n, err := c.conn.Write(hdrP)
-		if err != nil {
-			return 0, err
-		}
-		hdrP = hdrP[n:]
-	}
-
-	for len(b) > 0 {
-		n, err := c.conn.Write(b)
-		if err != nil {
-			return 0, err
-		}
-		b = b[n:]
-	}
-
-	return lenB, nil
+	return transportsutil.WriteFrame(c.conn, b)
 }
 
 func (c *connection) SetDeadline(t time.Time) error {
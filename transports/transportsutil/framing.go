@@ -0,0 +1,64 @@
+package transportsutil
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// MaxFrameSize is the maximum size of a framed packet.
+const MaxFrameSize = 1472
+
+// ReadFrame reads a length-prefixed packet from r into b.
+// io.ErrShortBuffer is returned (and the packet is discarded) when b is too small.
+func ReadFrame(r io.Reader, b []byte) (n int, err error) {
+	var hdr [2]byte
+
+	_, err = io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, err
+	}
+
+	msgLen := int(binary.BigEndian.Uint16(hdr[:]))
+
+	if msgLen > len(b) {
+		_, err = io.CopyN(ioutil.Discard, r, int64(msgLen))
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.ErrShortBuffer
+	}
+
+	return io.ReadFull(r, b[:msgLen])
+}
+
+// WriteFrame writes b to w as a length-prefixed packet.
+// Callers must make sure that concurrent writes to w are serialized.
+func WriteFrame(w io.Writer, b []byte) (n int, err error) {
+	var lenB = len(b)
+	if lenB > MaxFrameSize {
+		return 0, io.ErrShortWrite
+	}
+
+	var hdr [2]byte
+	var hdrP = hdr[:]
+	binary.BigEndian.PutUint16(hdrP, uint16(lenB))
+
+	for len(hdrP) > 0 {
+		n, err := w.Write(hdrP)
+		if err != nil {
+			return 0, err
+		}
+		hdrP = hdrP[n:]
+	}
+
+	for len(b) > 0 {
+		n, err := w.Write(b)
+		if err != nil {
+			return 0, err
+		}
+		b = b[n:]
+	}
+
+	return lenB, nil
+}
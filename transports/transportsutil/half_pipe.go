@@ -38,6 +38,22 @@ func (c *HalfPipe) PushMessage(p []byte) {
 	c.mtx.Unlock()
 }
 
+// PushBuffer queues buf without copying it. The HalfPipe takes ownership of buf.
+func (c *HalfPipe) PushBuffer(buf *bufpool.Buffer) {
+	c.mtx.Lock()
+
+	if c.closed {
+		c.mtx.Unlock()
+		buf.Free()
+		return
+	}
+
+	c.readQueue = append(c.readQueue, buf)
+
+	c.cndRead.Signal()
+	c.mtx.Unlock()
+}
+
 func (c *HalfPipe) Read(b []byte) (n int, err error) {
 	c.mtx.Lock()
 
@@ -57,8 +73,8 @@ func (c *HalfPipe) Read(b []byte) (n int, err error) {
 	n = len(buf.Get(b[:0]))
 	buf.Free()
 
-	copy(c.readQueue, c.readQueue[1:])
-	c.readQueue = c.readQueue[:len(c.readQueue)-1]
+	c.readQueue[0] = nil
+	c.readQueue = c.readQueue[1:]
 
 	if len(c.readQueue) > 0 {
 		c.cndRead.Signal()
@@ -104,6 +120,7 @@ func (c *HalfPipe) SetReadDeadline(t time.Time) error {
 func (c *HalfPipe) setDeadlineReached() {
 	c.mtx.Lock()
 	c.deadlineReached = true
+	c.cndRead.Broadcast()
 	c.mtx.Unlock()
 }
 
@@ -100,8 +100,8 @@ func (u *udpv4) Key() interface{} {
 		k connKey
 	)
 
-	copy(k[:16], u.IP.To16())
-	binary.BigEndian.PutUint16(k[16:], uint16(u.Port))
+	copy(k.addr[:16], u.IP.To16())
+	binary.BigEndian.PutUint16(k.addr[16:], uint16(u.Port))
 
 	return k
 }
@@ -110,8 +110,9 @@ func (u *udpv6) Key() interface{} {
 		k connKey
 	)
 
-	copy(k[:16], u.IP.To16())
-	binary.BigEndian.PutUint16(k[16:], uint16(u.Port))
+	copy(k.addr[:16], u.IP.To16())
+	binary.BigEndian.PutUint16(k.addr[16:], uint16(u.Port))
+	k.zone = u.Zone
 
 	return k
 }
@@ -149,6 +150,7 @@ func (u *udpv6) UnmarshalJSON(data []byte) error {
 	var desc struct {
 		IP   string `json:"ip"`
 		Port int    `json:"port"`
+		Zone string `json:"zone"`
 	}
 
 	err := json.Unmarshal(data, &desc)
@@ -165,7 +167,7 @@ func (u *udpv6) UnmarshalJSON(data []byte) error {
 		return transports.ErrInvalidAddr
 	}
 
-	addr := wrapAddr(&net.UDPAddr{IP: ip, Port: desc.Port})
+	addr := wrapAddr(&net.UDPAddr{IP: ip, Port: desc.Port, Zone: desc.Zone})
 	if !addr.IsIPv6() {
 		return transports.ErrInvalidAddr
 	}
@@ -193,10 +195,12 @@ func (u *udpv6) MarshalJSON() ([]byte, error) {
 		Type string `json:"type"`
 		IP   string `json:"ip"`
 		Port int    `json:"port"`
+		Zone string `json:"zone,omitempty"`
 	}{
 		Type: u.Network(),
 		IP:   u.IP.String(),
 		Port: u.Port,
+		Zone: u.Zone,
 	}
 
 	return json.Marshal(&desc)
@@ -227,7 +231,7 @@ func (u *udpv4) Equal(other net.Addr) bool {
 
 func (u *udpv6) Equal(other net.Addr) bool {
 	if b, ok := other.(*udpv6); ok {
-		return bytes.Equal(u.IP.To16(), b.IP.To16()) && u.Port == b.Port
+		return bytes.Equal(u.IP.To16(), b.IP.To16()) && u.Port == b.Port && u.Zone == b.Zone
 	}
 	return false
 }
@@ -0,0 +1,251 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package udp
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/telehash/gogotelehash/transports/dgram"
+)
+
+const batchSupported = true
+
+// mmsghdr mirrors struct mmsghdr from <sys/socket.h>
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+	_   [4]byte
+}
+
+// batchState holds the buffers passed to recvmmsg/sendmmsg. Each direction has
+// its own state as it is only used by one goroutine at a time.
+type batchState struct {
+	hdrs  []mmsghdr
+	iovs  []syscall.Iovec
+	names []syscall.RawSockaddrInet6
+	zones map[uint32]string
+	index map[string]uint32
+}
+
+func (s *batchState) prepare(n int) {
+	if len(s.hdrs) < n {
+		s.hdrs = make([]mmsghdr, n)
+		s.iovs = make([]syscall.Iovec, n)
+		s.names = make([]syscall.RawSockaddrInet6, n)
+	}
+
+	for i := 0; i < n; i++ {
+		s.hdrs[i] = mmsghdr{}
+		s.iovs[i] = syscall.Iovec{}
+		s.names[i] = syscall.RawSockaddrInet6{}
+	}
+}
+
+func (t *transport) ReadBatch(msgs []dgram.Message) (int, error) {
+	var (
+		s     = &t.rstate
+		n     int
+		errno syscall.Errno
+	)
+
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	s.prepare(len(msgs))
+
+	for i := range msgs {
+		b := msgs[i].Buffer.RawBytes()
+		b = b[:cap(b)]
+
+		s.iovs[i].Base = &b[0]
+		s.iovs[i].SetLen(len(b))
+
+		h := &s.hdrs[i].hdr
+		h.Name = (*byte)(unsafe.Pointer(&s.names[i]))
+		h.Namelen = syscall.SizeofSockaddrInet6
+		h.Iov = &s.iovs[i]
+		h.Iovlen = 1
+	}
+
+	err := t.rc.Read(func(fd uintptr) bool {
+		for {
+			r, _, e := syscall.Syscall6(sysRECVMMSG, fd,
+				uintptr(unsafe.Pointer(&s.hdrs[0])), uintptr(len(msgs)),
+				0, 0, 0)
+			if e == syscall.EINTR {
+				continue
+			}
+			if e == syscall.EAGAIN {
+				return false
+			}
+			n, errno = int(r), e
+			return true
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, os.NewSyscallError("recvmmsg", errno)
+	}
+
+	for i := 0; i < n; i++ {
+		msgs[i].Buffer.SetLen(int(s.hdrs[i].len))
+		msgs[i].Addr = s.decodeName(&s.names[i])
+	}
+
+	return n, nil
+}
+
+func (t *transport) WriteBatch(msgs []dgram.Message) (int, error) {
+	var (
+		s     = &t.wstate
+		sent  int
+		errno syscall.Errno
+	)
+
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	s.prepare(len(msgs))
+
+	for i, msg := range msgs {
+		b := msg.Buffer.RawBytes()
+		if len(b) > 0 {
+			s.iovs[i].Base = &b[0]
+			s.iovs[i].SetLen(len(b))
+		}
+
+		h := &s.hdrs[i].hdr
+		h.Name = (*byte)(unsafe.Pointer(&s.names[i]))
+		h.Namelen = s.encodeName(&s.names[i], msg.Addr.(udpAddr).ToUDPAddr(), t.net)
+		h.Iov = &s.iovs[i]
+		h.Iovlen = 1
+	}
+
+	err := t.rc.Write(func(fd uintptr) bool {
+		for sent < len(msgs) {
+			r, _, e := syscall.Syscall6(sysSENDMMSG, fd,
+				uintptr(unsafe.Pointer(&s.hdrs[sent])), uintptr(len(msgs)-sent),
+				0, 0, 0)
+			if e == syscall.EINTR {
+				continue
+			}
+			if e == syscall.EAGAIN {
+				return false
+			}
+			if e != 0 {
+				errno = e
+				return true
+			}
+			sent += int(r)
+		}
+		return true
+	})
+	if err != nil {
+		return sent, err
+	}
+	if errno != 0 {
+		return sent, os.NewSyscallError("sendmmsg", errno)
+	}
+
+	return sent, nil
+}
+
+func (s *batchState) decodeName(name *syscall.RawSockaddrInet6) dgram.Addr {
+	if name.Family == syscall.AF_INET {
+		name4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(name))
+		return wrapAddr(&net.UDPAddr{
+			IP:   net.IPv4(name4.Addr[0], name4.Addr[1], name4.Addr[2], name4.Addr[3]),
+			Port: ntohs(name4.Port),
+		})
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, name.Addr[:])
+
+	return wrapAddr(&net.UDPAddr{
+		IP:   ip,
+		Port: ntohs(name.Port),
+		Zone: s.zoneName(name.Scope_id),
+	})
+}
+
+func (s *batchState) encodeName(name *syscall.RawSockaddrInet6, addr *net.UDPAddr, network string) uint32 {
+	if network == UDPv4 {
+		name4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(name))
+		name4.Family = syscall.AF_INET
+		name4.Port = htons(addr.Port)
+		copy(name4.Addr[:], addr.IP.To4())
+		return syscall.SizeofSockaddrInet4
+	}
+
+	name.Family = syscall.AF_INET6
+	name.Port = htons(addr.Port)
+	copy(name.Addr[:], addr.IP.To16())
+	name.Scope_id = s.zoneIndex(addr.Zone)
+	return syscall.SizeofSockaddrInet6
+}
+
+func (s *batchState) zoneName(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+
+	if name, found := s.zones[index]; found {
+		return name
+	}
+
+	var name string
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		name = iface.Name
+	}
+
+	if s.zones == nil {
+		s.zones = make(map[uint32]string)
+	}
+	s.zones[index] = name
+	return name
+}
+
+func (s *batchState) zoneIndex(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+
+	if index, found := s.index[zone]; found {
+		return index
+	}
+
+	var index uint32
+	if iface, err := net.InterfaceByName(zone); err == nil {
+		index = uint32(iface.Index)
+	}
+
+	if s.index == nil {
+		s.index = make(map[string]uint32)
+	}
+	s.index[zone] = index
+	return index
+}
+
+// ntohs converts a port in network byte order
+func ntohs(port uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+	return int(b[0])<<8 | int(b[1])
+}
+
+// htons converts a port to network byte order
+func htons(port int) uint16 {
+	var v uint16
+	b := (*[2]byte)(unsafe.Pointer(&v))
+	b[0], b[1] = byte(port>>8), byte(port)
+	return v
+}
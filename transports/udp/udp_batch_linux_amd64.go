@@ -0,0 +1,6 @@
+package udp
+
+const (
+	sysRECVMMSG = 299
+	sysSENDMMSG = 307
+)
@@ -0,0 +1,6 @@
+package udp
+
+const (
+	sysRECVMMSG = 243
+	sysSENDMMSG = 269
+)
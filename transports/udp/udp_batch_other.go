@@ -0,0 +1,42 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package udp
+
+import (
+	"github.com/telehash/gogotelehash/transports/dgram"
+)
+
+const batchSupported = false
+
+type batchState struct{}
+
+// ReadBatch reads a single message as batched reads are not supported on
+// this platform.
+func (t *transport) ReadBatch(msgs []dgram.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	b := msgs[0].Buffer.RawBytes()
+	n, addr, err := t.Read(b[:cap(b)])
+	if err != nil {
+		return 0, err
+	}
+
+	msgs[0].Buffer.SetLen(n)
+	msgs[0].Addr = addr
+	return 1, nil
+}
+
+// WriteBatch writes the messages one by one as batched writes are not
+// supported on this platform.
+func (t *transport) WriteBatch(msgs []dgram.Message) (int, error) {
+	for i, msg := range msgs {
+		_, err := t.Write(msg.Buffer.RawBytes(), msg.Addr)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
@@ -5,8 +5,14 @@ package udp
 
 import (
 	"errors"
+	"io"
 	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/transports"
 	"github.com/telehash/gogotelehash/transports/dgram"
 	"github.com/telehash/gogotelehash/transports/transportsutil"
@@ -16,7 +22,7 @@ import (
 //
 //   e3x.New(keys, udp.Config{})
 type Config struct {
-	// Can be set to UDPv4, UDPv6 or can be left blank.
+	// Can be set to UDPv4, UDPv6, UDP or can be left blank.
 	// Defaults to UDPv4
 	Network string
 
@@ -24,7 +30,17 @@ type Config struct {
 	// The zero value will bind it to a random port while listening on all interfaces.
 	// When port is unspecified ("127.0.0.1") a random port will be chosen.
 	// When ip is unspecified (":3000") the transport will listen on all interfaces.
+	// In dual-stack mode (UDP) only the port can be set.
 	Addr string
+
+	// SamePort makes a dual-stack transport bind both IPv4 and IPv6 on the
+	// same (random) port.
+	SamePort bool
+
+	// BatchSize is the maximum number of datagrams that are read or written
+	// with a single system call (recvmmsg/sendmmsg on Linux).
+	// Defaults to 32 when batching is supported. Set to 1 to disable batching.
+	BatchSize int
 }
 
 const (
@@ -32,19 +48,50 @@ const (
 	UDPv4 = "udp4"
 	// UDPv6 is used for IPv6 UDP networks
 	UDPv6 = "udp6"
+	// UDP is used for dual-stack (IPv4 and IPv6) UDP networks
+	UDP = "udp"
 )
 
-type connKey [18]byte
+const defaultBatchSize = 32
+
+// cReadRetryDelay is the pause after a failed read of a dual-stack socket.
+const cReadRetryDelay = 10 * time.Millisecond
+
+type connKey struct {
+	addr [18]byte
+	zone string
+}
 
 type transport struct {
-	net   string
-	laddr udpAddr
-	c     *net.UDPConn
+	net       string
+	laddr     udpAddr
+	c         *net.UDPConn
+	rc        syscall.RawConn
+	batchSize int
+	rstate    batchState
+	wstate    batchState
+}
+
+// dualTransport combines an IPv4 and an IPv6 transport.
+type dualTransport struct {
+	v4 *transport
+	v6 *transport
+
+	wg        sync.WaitGroup
+	queue     chan dualPacket
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type dualPacket struct {
+	buf  *bufpool.Buffer
+	addr dgram.Addr
 }
 
 var (
-	_ dgram.Transport   = (*transport)(nil)
-	_ transports.Config = Config{}
+	_ dgram.BatchTransport = (*transport)(nil)
+	_ dgram.BatchTransport = (*dualTransport)(nil)
+	_ transports.Config    = Config{}
 )
 
 // Open opens the transport.
@@ -60,9 +107,23 @@ func (c Config) Open() (transports.Transport, error) {
 	if c.Addr == "" {
 		c.Addr = ":0"
 	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if !batchSupported {
+		c.BatchSize = 1
+	}
+
+	if c.Network == UDP {
+		t, err := openDualStack(c.Addr, c.SamePort, c.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+		return dgram.Wrap(t)
+	}
 
 	if c.Network != UDPv4 && c.Network != UDPv6 {
-		return nil, errors.New("udp: Network must be either `udp4` or `udp6`")
+		return nil, errors.New("udp: Network must be either `udp4`, `udp6` or `udp`")
 	}
 
 	{ // parse and verify source address
@@ -80,15 +141,91 @@ func (c Config) Open() (transports.Transport, error) {
 		}
 	}
 
-	conn, err := net.ListenUDP(c.Network, addr)
+	t, err := listen(c.Network, addr, c.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return dgram.Wrap(t)
+}
+
+func listen(network string, addr *net.UDPAddr, batchSize int) (*transport, error) {
+	conn, err := net.ListenUDP(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	addr = conn.LocalAddr().(*net.UDPAddr)
 
-	t := &transport{net: c.Network, laddr: wrapAddr(addr), c: conn}
-	return dgram.Wrap(t)
+	return &transport{net: network, laddr: wrapAddr(addr), c: conn, rc: rc, batchSize: batchSize}, nil
+}
+
+func openDualStack(laddr string, samePort bool, batchSize int) (*dualTransport, error) {
+	const maxAttempts = 8
+
+	host, portStr, err := net.SplitHostPort(laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if host != "" {
+		return nil, errors.New("udp: dual-stack mode requires an unspecified address")
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	for attempt := 0; ; attempt++ {
+		v4, err := listen(UDPv4, &net.UDPAddr{Port: port}, batchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		port6 := port
+		if port == 0 && samePort {
+			port6 = int(v4.laddr.GetPort())
+		}
+
+		v6, err := listen(UDPv6, &net.UDPAddr{Port: port6}, batchSize)
+		if err != nil {
+			v4.Close()
+
+			// the random port might be in use for IPv6; try another one
+			if port == 0 && samePort && attempt < maxAttempts {
+				continue
+			}
+
+			return nil, err
+		}
+
+		t := &dualTransport{
+			v4:    v4,
+			v6:    v6,
+			queue: make(chan dualPacket, 64),
+			done:  make(chan struct{}),
+		}
+		t.wg.Add(2)
+		go t.reader(v4)
+		go t.reader(v6)
+		go func() {
+			t.wg.Wait()
+			close(t.queue)
+		}()
+
+		return t, nil
+	}
+}
+
+func (t *transport) BatchSize() int {
+	return t.batchSize
 }
 
 func (t *transport) Close() error {
@@ -151,3 +288,163 @@ func (t *transport) Addrs() []net.Addr {
 
 	return addrs
 }
+
+func (t *dualTransport) reader(inner *transport) {
+	var (
+		msgs = make([]dgram.Message, inner.BatchSize())
+	)
+
+	defer t.wg.Done()
+
+	defer func() {
+		for _, msg := range msgs {
+			msg.Buffer.Free()
+		}
+	}()
+
+	for {
+		for i := range msgs {
+			if msgs[i].Buffer == nil {
+				msgs[i].Buffer = bufpool.New()
+			}
+		}
+
+		n, err := inner.ReadBatch(msgs)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// transient (like an ICMP error reported on the socket); don't
+			// spin when the error persists.
+			select {
+			case <-time.After(cReadRetryDelay):
+				continue
+			case <-t.done:
+				return
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			select {
+			case t.queue <- dualPacket{msgs[i].Buffer, msgs[i].Addr}:
+				msgs[i] = dgram.Message{}
+			case <-t.done:
+				return
+			}
+		}
+	}
+}
+
+func (t *dualTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+
+	err4 := t.v4.Close()
+	err6 := t.v6.Close()
+
+	if err4 != nil {
+		return err4
+	}
+	return err6
+}
+
+func (t *dualTransport) NormalizeAddr(addr net.Addr) (dgram.Addr, error) {
+	if a, ok := addr.(*net.UDPAddr); ok {
+		return t.NormalizeAddr(wrapAddr(a))
+	} else if a, ok := addr.(*udpv4); ok {
+		return a, nil
+	} else if a, ok := addr.(*udpv6); ok {
+		return a, nil
+	} else {
+		return nil, transports.ErrInvalidAddr
+	}
+}
+
+func (t *dualTransport) Read(b []byte) (n int, addr dgram.Addr, err error) {
+	pkt, ok := <-t.queue
+	if !ok {
+		return 0, nil, io.EOF
+	}
+
+	n = copy(b, pkt.buf.RawBytes())
+	pkt.buf.Free()
+
+	return n, pkt.addr, nil
+}
+
+func (t *dualTransport) BatchSize() int {
+	return t.v4.BatchSize()
+}
+
+// ReadBatch reads at least one message. The buffers in msgs are replaced
+// with the buffers of the received messages.
+func (t *dualTransport) ReadBatch(msgs []dgram.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	pkt, ok := <-t.queue
+	if !ok {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for {
+		msgs[n].Buffer.Free()
+		msgs[n] = dgram.Message{Buffer: pkt.buf, Addr: pkt.addr}
+		n++
+
+		if n == len(msgs) {
+			return n, nil
+		}
+
+		select {
+		case pkt, ok = <-t.queue:
+			if !ok {
+				return n, nil
+			}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// WriteBatch writes consecutive messages of the same address family
+// with a single batch.
+func (t *dualTransport) WriteBatch(msgs []dgram.Message) (int, error) {
+	var sent int
+
+	for sent < len(msgs) {
+		var (
+			isIPv6 = msgs[sent].Addr.(udpAddr).IsIPv6()
+			end    = sent + 1
+			inner  = t.v4
+		)
+
+		for end < len(msgs) && msgs[end].Addr.(udpAddr).IsIPv6() == isIPv6 {
+			end++
+		}
+
+		if isIPv6 {
+			inner = t.v6
+		}
+
+		n, err := inner.WriteBatch(msgs[sent:end])
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+func (t *dualTransport) Write(b []byte, addr dgram.Addr) (n int, err error) {
+	if addr.(udpAddr).IsIPv6() {
+		return t.v6.Write(b, addr)
+	}
+	return t.v4.Write(b, addr)
+}
+
+func (t *dualTransport) Addrs() []net.Addr {
+	return append(t.v4.Addrs(), t.v6.Addrs()...)
+}
@@ -2,10 +2,15 @@ package udp
 
 import (
 	"bytes"
+	"fmt"
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
 )
 
 func TestAddrs(t *testing.T) {
@@ -16,6 +21,8 @@ func TestAddrs(t *testing.T) {
 		{Network: "udp4", Addr: "127.0.0.1:8080"},
 		{Network: "udp4", Addr: ":0"},
 		{Network: "udp6", Addr: ":0"},
+		{Network: "udp", Addr: ":0"},
+		{Network: "udp", Addr: ":0", SamePort: true},
 	}
 
 	for _, factory := range tab {
@@ -31,6 +38,194 @@ func TestAddrs(t *testing.T) {
 	}
 }
 
+func TestDualStack(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Network: "udp", SamePort: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	var ports = map[uint16]bool{}
+	for _, addr := range A.Addrs() {
+		ports[addr.(udpAddr).GetPort()] = true
+	}
+	assert.Len(ports, 1)
+
+	for _, config := range []Config{
+		{Network: "udp4", Addr: "127.0.0.1:0"},
+		{Network: "udp6", Addr: "[::1]:0"},
+	} {
+		B, err := config.Open()
+		if !assert.NoError(err) {
+			continue
+		}
+
+		w, err := A.Dial(B.Addrs()[0])
+		if assert.NoError(err) {
+			_, err = w.Write([]byte("ping"))
+			assert.NoError(err)
+
+			r, err := B.Accept()
+			if assert.NoError(err) {
+				var buf [1500]byte
+				n, err := r.Read(buf[:])
+				assert.NoError(err)
+				assert.Equal("ping", string(buf[:n]))
+
+				_, err = r.Write([]byte("pong"))
+				assert.NoError(err)
+
+				n, err = w.Read(buf[:])
+				assert.NoError(err)
+				assert.Equal("pong", string(buf[:n]))
+			}
+		}
+
+		assert.NoError(B.Close())
+	}
+}
+
+func TestDualStackRequiresUnspecifiedAddr(t *testing.T) {
+	_, err := Config{Network: "udp", Addr: "127.0.0.1:0"}.Open()
+	assert.Error(t, err)
+}
+
+func TestBatchedIO(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, network := range []string{"udp4", "udp6", "udp"} {
+		A, err := Config{Network: network, BatchSize: 8}.Open()
+		if !assert.NoError(err) {
+			continue
+		}
+
+		laddr := "127.0.0.1:0"
+		if network == "udp6" {
+			laddr = "[::1]:0"
+		}
+		B, err := Config{Network: network, Addr: laddr, BatchSize: 8}.Open()
+		if network == "udp" {
+			B, err = Config{Network: "udp4", Addr: laddr, BatchSize: 8}.Open()
+		}
+		if !assert.NoError(err) {
+			A.Close()
+			continue
+		}
+
+		const count = 64
+
+		w, err := A.Dial(B.Addrs()[0])
+		if assert.NoError(err) {
+			var wg sync.WaitGroup
+			for i := 0; i < count; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := w.Write([]byte(fmt.Sprintf("msg-%d", i)))
+					assert.NoError(err)
+				}(i)
+			}
+			wg.Wait()
+
+			r, err := B.Accept()
+			if assert.NoError(err) {
+				var (
+					buf  [1500]byte
+					seen = map[string]bool{}
+				)
+
+				r.SetReadDeadline(time.Now().Add(5 * time.Second))
+				for len(seen) < count {
+					n, err := r.Read(buf[:])
+					if !assert.NoError(err) {
+						break
+					}
+					seen[string(buf[:n])] = true
+				}
+
+				assert.Len(seen, count, "network=%s", network)
+
+				_, err = r.Write([]byte("pong"))
+				assert.NoError(err)
+
+				w.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := w.Read(buf[:])
+				assert.NoError(err)
+				assert.Equal("pong", string(buf[:n]))
+			}
+		}
+
+		assert.NoError(A.Close())
+		assert.NoError(B.Close())
+	}
+}
+
+func TestDualStackCloseWithFullQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := openDualStack(":0", false, 1)
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(d.v4.laddr.GetPort())})
+	if !assert.NoError(err) {
+		d.Close()
+		return
+	}
+	defer c.Close()
+
+	// nobody reads from the transport; the reader blocks on the full queue
+	for deadline := time.Now().Add(5 * time.Second); len(d.queue) < cap(d.queue) && time.Now().Before(deadline); {
+		c.Write([]byte("x"))
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(cap(d.queue), len(d.queue))
+
+	assert.NoError(d.Close())
+
+	stopped := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the readers must stop when the transport is closed")
+	}
+}
+
+func TestZones(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		a = wrapAddr(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 42424, Zone: "eth0"})
+		b = wrapAddr(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 42424, Zone: "eth1"})
+		c = wrapAddr(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 42424, Zone: "eth0"})
+	)
+
+	assert.NotEqual(a.Key(), b.Key())
+	assert.Equal(a.Key(), c.Key())
+	assert.False(transports.EqualAddr(a, b))
+	assert.True(transports.EqualAddr(a, c))
+	assert.Equal("[fe80::1%eth0]:42424", a.String())
+
+	// the zone survives encoding
+	data, err := transports.EncodeAddr(a)
+	if assert.NoError(err) {
+		assert.Equal(`{"type":"udp6","ip":"fe80::1","port":42424,"zone":"eth0"}`, string(data))
+
+		d, err := transports.DecodeAddr(data)
+		if assert.NoError(err) {
+			assert.True(transports.EqualAddr(a, d))
+			assert.False(transports.EqualAddr(b, d))
+		}
+	}
+}
+
 func Benchmark(b *testing.B) {
 	A, err := Config{Network: "udp4"}.Open()
 	if err != nil {
@@ -97,3 +292,124 @@ func Benchmark(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkWritePackets(b *testing.B) {
+	for _, batchSize := range []int{1, defaultBatchSize} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			benchmarkWritePackets(b, batchSize)
+		})
+	}
+}
+
+func BenchmarkReadPackets(b *testing.B) {
+	for _, batchSize := range []int{1, defaultBatchSize} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			benchmarkReadPackets(b, batchSize)
+		})
+	}
+}
+
+func benchmarkWritePackets(b *testing.B, batchSize int) {
+	const writers = 8
+
+	A, err := Config{Network: "udp4", Addr: "127.0.0.1:0", BatchSize: batchSize}.Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer A.Close()
+
+	// the packets are never read
+	sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sink.Close()
+
+	w, err := A.Dial(wrapAddr(sink.LocalAddr().(*net.UDPAddr)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var (
+		msg = bytes.Repeat([]byte{'x'}, 64)
+		wg  sync.WaitGroup
+	)
+
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				w.Write(msg)
+			}
+		}(b.N / writers)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	b.StopTimer()
+	b.ReportMetric(float64(writers*(b.N/writers))/elapsed.Seconds(), "pkts/s")
+}
+
+// benchmarkReadPackets alternates between filling the receive buffer of the
+// socket and reading the queued packets through the transport.
+func benchmarkReadPackets(b *testing.B, batchSize int) {
+	const burst = 128
+
+	B, err := Config{Network: "udp4", Addr: "127.0.0.1:0", BatchSize: batchSize}.Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer B.Close()
+
+	src, err := net.DialUDP("udp4", nil, B.Addrs()[0].(udpAddr).ToUDPAddr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer src.Close()
+
+	var (
+		msg = bytes.Repeat([]byte{'x'}, 64)
+		out [1500]byte
+	)
+
+	_, err = src.Write(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	r, err := B.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	_, err = r.Read(out[:])
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i += burst {
+		for j := 0; j < burst; j++ {
+			src.Write(msg)
+		}
+
+		r.SetReadDeadline(time.Now().Add(time.Second))
+		for j := 0; j < burst; j++ {
+			_, err := r.Read(out[:])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	b.ReportMetric(float64((b.N+burst-1)/burst*burst)/elapsed.Seconds(), "pkts/s")
+}
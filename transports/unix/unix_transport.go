@@ -4,7 +4,6 @@ package unix
 import (
 	"bufio"
 	"crypto/rand"
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"io"
@@ -15,6 +14,7 @@ import (
 	"time"
 
 	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
 )
 
 func init() {
@@ -169,51 +169,17 @@ func (t *transport) Close() error {
 }
 
 func (c *connection) Read(b []byte) (n int, err error) {
-	var hdr [2]byte
-
 	c.mtxRead.Lock()
 	defer c.mtxRead.Unlock()
 
-	_, err = io.ReadFull(c.bufr, hdr[:])
-	if err != nil {
-		return 0, err
-	}
-
-	msgLen := binary.BigEndian.Uint16(hdr[:])
-
-	return io.ReadFull(c.bufr, b[:msgLen])
+	return transportsutil.ReadFrame(c.bufr, b)
 }
 
 func (c *connection) Write(b []byte) (n int, err error) {
-	var lenB = len(b)
-	if lenB > 1472 {
-		return 0, io.ErrShortWrite
-	}
-
-	var hdr [2]byte
-	var hdrP = hdr[:]
-	binary.BigEndian.PutUint16(hdrP, uint16(lenB))
-
 	c.mtxWrite.Lock()
 	defer c.mtxWrite.Unlock()
 
-	for len(hdrP) > 0 {
-		n, err := c.conn.Write(hdrP)
-		if err != nil {
-			return 0, err
-		}
-		hdrP = hdrP[n:]
-	}
-
-	for len(b) > 0 {
-		n, err := c.conn.Write(b)
-		if err != nil {
-			return 0, err
-		}
-		b = b[n:]
-	}
-
-	return lenB, nil
+	return transportsutil.WriteFrame(c.conn, b)
 }
 
 func (c *connection) SetDeadline(t time.Time) error {